@@ -3,6 +3,7 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -18,9 +19,20 @@ type Cache interface {
 	Delete(key string) error
 	Incr(key string) error
 	Decr(key string) error
+	//增加delta并返回新值
+	IncrBy(key string, delta int64) (int64, error)
+	IncrByFloat(key string, delta float64) (float64, error)
 	Exists(key string) bool
 	SetExpire(key string, expire ...time.Duration) error
 	NewMap(name string, expire ...time.Duration) (Map, error)
+
+	//返回匹配pattern的key, 包括Map名称, pattern语法同redis: *, ?, [abc], [^a], [a-z], \转义
+	Keys(pattern string) ([]string, error)
+	//增量遍历匹配pattern的key, cursor从0开始, 返回的next为0时遍历结束
+	//遍历期间一直存在的key至少返回一次, count为每次返回数量的建议值
+	Scan(cursor uint64, pattern string, count int) (next uint64, keys []string, err error)
+	//删除以prefix开头的key, 返回删除的数量
+	DeleteByPrefix(prefix string) (int, error)
 }
 
 type Map interface {
@@ -34,15 +46,162 @@ type Map interface {
 	Delete(key string) error
 	Incr(key string) error
 	Decr(key string) error
+	IncrBy(key string, delta int64) (int64, error)
+	IncrByFloat(key string, delta float64) (float64, error)
 	Exists(key string) bool
 	Size() (int, error)
 	Clear() error
+
+	PutMulti(vals map[string]string) error
+	//返回Map中所有数据
+	GetAll() (map[string]string, error)
+	Keys() ([]string, error)
+	//依次调用fn, fn返回false时停止, fn中可以修改Map
+	Range(fn func(key, val string) bool) error
+	DeleteMulti(keys []string) error
 }
 
 type CacheInitializer interface {
 	Init(config string) error
 }
 
+//MultiGetter Cache和Map都实现了此接口
+type MultiGetter interface {
+	GetMulti(keys []string) ([]string, error)
+	Exists(key string) bool
+}
+
+//HitsGetter 批量获取并报告每个key是否存在, 适配器可选实现
+type HitsGetter interface {
+	GetMultiHits(keys []string) (vals []string, hits []bool, err error)
+}
+
+//GetMultiHits 批量获取, hits[i]表示keys[i]是否存在, 可以区分不存在与空字符串
+//未实现HitsGetter时, 对值为空字符串的key调用Exists判断
+func GetMultiHits(g MultiGetter, keys []string) ([]string, []bool, error) {
+	if hg, ok := g.(HitsGetter); ok {
+		return hg.GetMultiHits(keys)
+	}
+	vals, err := g.GetMulti(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	hits := make([]bool, len(keys))
+	for i, v := range vals {
+		hits[i] = v != "" || g.Exists(keys[i])
+	}
+	return vals, hits, nil
+}
+
+//TTLGetter 返回key的剩余过期时间, 适配器可选实现, 多级缓存回填上一级时使用
+type TTLGetter interface {
+	//不过期时返回0, key不存在时返回ErrNil
+	TTL(key string) (time.Duration, error)
+}
+
+//TTL 返回key的剩余过期时间, 不过期时返回0, 不支持的适配器返回ErrNotSupported
+func TTL(c Cache, key string) (time.Duration, error) {
+	if tg, ok := c.(TTLGetter); ok {
+		return tg.TTL(key)
+	}
+	return 0, ErrNotSupported
+}
+
+//ObjectGetter Cache和Map都实现了此接口
+type ObjectGetter interface {
+	GetObject(key string, valptr interface{}) error
+}
+
+//ObjectsGetter 批量获取对象, 适配器可选实现, 远程适配器通过一次请求获取
+type ObjectsGetter interface {
+	//valptr(i)返回keys[i]的对象指针, hits[i]表示keys[i]是否存在
+	GetObjects(keys []string, valptr func(i int) interface{}) (hits []bool, err error)
+}
+
+//GetObjects 批量获取对象, 未实现ObjectsGetter时依次调用GetObject
+func GetObjects(g ObjectGetter, keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	if og, ok := g.(ObjectsGetter); ok {
+		return og.GetObjects(keys, valptr)
+	}
+	hits := make([]bool, len(keys))
+	for i, key := range keys {
+		err := g.GetObject(key, valptr(i))
+		if err == ErrNil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hits[i] = true
+	}
+	return hits, nil
+}
+
+//DecodeObjects 通过GetMultiHits批量获取, 使用cd反序列化, 用于以序列化后的数据保存对象的适配器实现ObjectsGetter
+func DecodeObjects(g MultiGetter, cd Codec, keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	vals, hits, err := GetMultiHits(g, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, hit := range hits {
+		if !hit {
+			continue
+		}
+		if err = cd.Unmarshal([]byte(vals[i]), valptr(i)); err != nil {
+			return nil, err
+		}
+	}
+	return hits, nil
+}
+
+//AtomicCache 原子条件操作, 适配器可选实现
+//不支持的适配器通过PutIfAbsent, CompareAndSwap, GetAndDelete函数调用时返回ErrNotSupported
+type AtomicCache interface {
+	//key不存在时写入, 返回是否写入
+	PutIfAbsent(key string, val string, expire ...time.Duration) (bool, error)
+	//当前值等于old时替换为new, 返回是否替换
+	CompareAndSwap(key string, old, new string, expire ...time.Duration) (bool, error)
+	//获取并删除, key不存在时返回ErrNil
+	GetAndDelete(key string) (string, error)
+}
+
+func PutIfAbsent(c Cache, key string, val string, expire ...time.Duration) (bool, error) {
+	if ac, ok := c.(AtomicCache); ok {
+		return ac.PutIfAbsent(key, val, expire...)
+	}
+	return false, ErrNotSupported
+}
+
+func CompareAndSwap(c Cache, key string, old, new string, expire ...time.Duration) (bool, error) {
+	if ac, ok := c.(AtomicCache); ok {
+		return ac.CompareAndSwap(key, old, new, expire...)
+	}
+	return false, ErrNotSupported
+}
+
+func GetAndDelete(c Cache, key string) (string, error) {
+	if ac, ok := c.(AtomicCache); ok {
+		return ac.GetAndDelete(key)
+	}
+	return "", ErrNotSupported
+}
+
+//ScriptCache 执行lua脚本, 适配器可选实现, 用于无法通过AtomicCache完成的原子操作
+//redis适配器使用EVALSHA, cluster模式脚本的全部key必须属于同一个slot
+type ScriptCache interface {
+	//返回值同redigo, 如int64, []byte, []interface{}
+	Eval(script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+//Eval 执行lua脚本, 不支持的适配器返回ErrNotSupported
+//多级缓存在最后一级缓存上执行, 不删除上级缓存中的数据, 脚本使用的key不应通过多级缓存读取
+func Eval(c Cache, script string, keys []string, args ...interface{}) (interface{}, error) {
+	if sc, ok := c.(ScriptCache); ok {
+		return sc.Eval(script, keys, args...)
+	}
+	return nil, ErrNotSupported
+}
+
 var adapters = make(map[string]func() Cache)
 
 func Register(name string, adapter func() Cache) {
@@ -76,5 +235,32 @@ func NewCache(adapterName, config string) (adapter Cache, err error) {
 }
 
 var (
-	ErrNil = errors.New("cache: nil returned")
+	ErrNil          = errors.New("cache: nil returned")
+	ErrNotSupported = errors.New("cache: operation not supported")
+	//ErrWrongType 对Map执行字符串操作, 或对字符串执行Map操作
+	ErrWrongType = errors.New("cache: operation against a key holding the wrong kind of value")
 )
+
+//IncrBytes 将保存为十进制字符串的val增加delta, val为nil时从0开始, 供保存[]byte的适配器实现IncrBy
+func IncrBytes(val []byte, delta int64) (int64, error) {
+	if val == nil {
+		return delta, nil
+	}
+	n, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil {
+		return 0, errors.New("cache: value is not an integer")
+	}
+	return n + delta, nil
+}
+
+//IncrFloatBytes 同IncrBytes, 用于IncrByFloat
+func IncrFloatBytes(val []byte, delta float64) (float64, error) {
+	if val == nil {
+		return delta, nil
+	}
+	f, err := strconv.ParseFloat(string(val), 64)
+	if err != nil {
+		return 0, errors.New("cache: value is not a valid float")
+	}
+	return f + delta, nil
+}
@@ -2,8 +2,22 @@ package cache
 
 import (
 	//	"fmt"
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/tryor/commons/redisutil/redistest"
 )
 
 type V struct {
@@ -375,6 +389,8 @@ func Test_Memory(t *testing.T) {
 	}
 	testCache(t, cache)
 	testMap(t, cache, "m")
+	testMapBulk(t, cache, "m")
+	testMapBulk(t, NewL2Cache(cache, newTestMemory(t)), "l2")
 }
 
 func Test_Redis(t *testing.T) {
@@ -385,6 +401,61 @@ func Test_Redis(t *testing.T) {
 	}
 	testCache(t, cache)
 	testMap(t, cache, "r")
+	testMapBulk(t, cache, "r")
+}
+
+func newTestMemory(t *testing.T) Cache {
+	cache, err := NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func testMapBulk(t *testing.T, cache Cache, p string) {
+	m, err := cache.NewMap(p + "_bulkmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Clear()
+	if err = m.PutMulti(map[string]string{"a": "1", "b": "2", "c": "3"}); err != nil {
+		t.Fatal(err)
+	}
+	m.PutObject("o", 4)
+
+	all, err := m.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 || all["b"] != "2" || all["o"] != "4" {
+		t.Fatalf("all:%v", all)
+	}
+	keys, err := m.Keys()
+	if err != nil || len(keys) != 4 {
+		t.Fatalf("keys:%v, err:%v", keys, err)
+	}
+
+	n := 0
+	err = m.Range(func(key, val string) bool {
+		if all[key] != val {
+			t.Fatalf("%v:%v != %v", key, val, all[key])
+		}
+		n++
+		return n < 2
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("n:%v, err:%v", n, err)
+	}
+
+	if err = m.DeleteMulti([]string{"a", "o", "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := m.Size(); size != 2 {
+		t.Fatalf("size:%v", size)
+	}
+	if m.Exists("a") || !m.Exists("b") {
+		t.Fatal("a is exist or b not is exist!")
+	}
 }
 
 func testMap(t *testing.T, cache Cache, p string) {
@@ -520,6 +591,15 @@ func testMap(t *testing.T, cache Cache, p string) {
 		t.Fatalf("size != 0")
 	}
 
+	n, err := m.IncrBy("mincrby", 3)
+	if err != nil || n != 3 {
+		t.Fatalf("[map] IncrBy, n:%v, err:%v", n, err)
+	}
+	f, err := m.IncrByFloat("mincrfloat", 0.5)
+	if err != nil || f != 0.5 {
+		t.Fatalf("[map] IncrByFloat, f:%v, err:%v", f, err)
+	}
+
 	m2, err := cache.NewMap(p+"_map002", time.Second*1, time.Second*2)
 	if err != nil {
 		t.Fatal(err)
@@ -640,6 +720,34 @@ func testCache(t *testing.T, cache Cache) {
 	}
 	t.Log("decr:", decr)
 
+	n, err := cache.IncrBy("incrby", 5)
+	if err != nil || n != 5 {
+		t.Fatalf("IncrBy, n:%v, err:%v", n, err)
+	}
+	n, err = cache.IncrBy("incrby", -2)
+	if err != nil || n != 3 {
+		t.Fatalf("IncrBy, n:%v, err:%v", n, err)
+	}
+	cache.SetExpire("incrby", time.Second*5)
+
+	err = cache.Put("incrstr", "10", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = cache.IncrBy("incrstr", 1)
+	if err != nil || n != 11 {
+		t.Fatalf("IncrBy, n:%v, err:%v", n, err)
+	}
+	if v, _ := cache.Get("incrstr"); v != "11" {
+		t.Fatalf("incrstr:%v", v)
+	}
+
+	f, err := cache.IncrByFloat("incrfloat", 1.5)
+	if err != nil || f != 1.5 {
+		t.Fatalf("IncrByFloat, f:%v, err:%v", f, err)
+	}
+	cache.SetExpire("incrfloat", time.Second*5)
+
 	err = cache.Put("k5", "v5", time.Second*1)
 	if err != nil {
 		t.Fatal(err)
@@ -663,3 +771,1298 @@ func testCache(t *testing.T, cache Cache) {
 	}
 
 }
+
+func Test_MemoryEvict(t *testing.T) {
+	config := `{"gccyc":60, "maxEntries":3, "evictPolicy":"lru"}`
+	cache, err := NewCache("memory", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("e1", "v1")
+	cache.Put("e2", "v2")
+	cache.Put("e3", "v3")
+	cache.Get("e1")
+	cache.Put("e4", "v4")
+	if cache.Exists("e2") {
+		t.Fatal("lru: e2 is exist!")
+	}
+	if !cache.Exists("e1") || !cache.Exists("e3") || !cache.Exists("e4") {
+		t.Fatal("lru: e1, e3, e4 not is exist!")
+	}
+
+	m, err := cache.NewMap("emap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Put("f1", "v1")
+	m.Put("f2", "v2")
+	if cache.Exists("e3") || cache.Exists("e1") {
+		t.Fatal("lru: e3 or e1 is exist!")
+	}
+	if !cache.Exists("e4") || !m.Exists("f1") || !m.Exists("f2") {
+		t.Fatal("lru: e4, f1, f2 not is exist!")
+	}
+
+	config = `{"gccyc":60, "maxEntries":2, "evictPolicy":"lfu"}`
+	cache, err = NewCache("memory", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("e1", "v1")
+	cache.Put("e2", "v2")
+	cache.Get("e1")
+	cache.Get("e1")
+	cache.Put("e3", "v3")
+	if !cache.Exists("e1") || cache.Exists("e2") {
+		t.Fatal("lfu: e2 should be evicted")
+	}
+
+	config = `{"gccyc":60, "maxBytes":10, "evictPolicy":"random"}`
+	cache, err = NewCache("memory", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("k1", "1234")
+	cache.Put("k2", "1234")
+	n := 0
+	for _, k := range []string{"k1", "k2"} {
+		if cache.Exists(k) {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("random: %d entries remain", n)
+	}
+
+	_, err = NewCache("memory", `{"maxEntries":1, "evictPolicy":"fifo"}`)
+	if err == nil {
+		t.Fatal("unknown evict policy accepted")
+	}
+}
+
+func Test_MemoryExpireHeap(t *testing.T) {
+	config := `{"gccyc":0, "shards":4}`
+	cache, err := NewCache("memory", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		cache.Put("h"+strconv.Itoa(i), "v", time.Duration(i+1)*time.Second)
+	}
+	cache.Put("forever", "v")
+	cache.SetExpire("h99", time.Second)
+	cache.SetExpire("forever", time.Second*200)
+
+	mc := cache.(*memoryCache)
+	size := func() (n, h int) {
+		for _, s := range mc.shards {
+			n += len(s.items)
+			h += len(s.expires)
+		}
+		return
+	}
+	for _, s := range mc.shards {
+		s.itemExpireds(time.Now().Add(time.Second * 50))
+	}
+	if n, h := size(); n != 50 || h != 50 {
+		t.Fatalf("items:%d, heap:%d, expected 50", n, h)
+	}
+	if !cache.Exists("h50") || cache.Exists("h99") {
+		t.Fatal("h50 not is exist or h99 is exist")
+	}
+	for _, s := range mc.shards {
+		s.itemExpireds(time.Now().Add(time.Second * 150))
+	}
+	if n, h := size(); n != 1 || h != 1 {
+		t.Fatalf("items:%d, heap:%d, expected 1", n, h)
+	}
+}
+
+func Test_GetOrLoad(t *testing.T) {
+	cache, err := NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loads int32
+	load := func() (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(time.Millisecond * 100)
+		return &V{"load", 1, 2.5}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v V
+			if err := GetOrLoad(cache, "load1", &v, load, time.Second*10); err != nil {
+				t.Error(err)
+			}
+			if v.V1 != "load" {
+				t.Errorf("v:%v", v)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("loads:%d, expected 1", loads)
+	}
+
+	var v V
+	if err := GetOrLoad(cache, "load1", &v, load); err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 || v.V2 != 1 {
+		t.Fatalf("loads:%d, v:%v", loads, v)
+	}
+
+	s, err := GetStringOrLoad(cache, "load2", func() (string, error) { return "v2", nil })
+	if err != nil || s != "v2" {
+		t.Fatalf("err:%v, s:%v", err, s)
+	}
+	if s, _ = cache.Get("load2"); s != "v2" {
+		t.Fatal("load2 not is cached")
+	}
+
+	var n int
+	loadErr := errors.New("load error")
+	err = GetOrLoad(cache, "load3", &n, func() (interface{}, error) { return nil, loadErr })
+	if err != loadErr || cache.Exists("load3") {
+		t.Fatalf("err:%v", err)
+	}
+
+	//load panic时等待的调用者也返回错误
+	var errs int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := GetStringOrLoad(cache, "load4", func() (string, error) {
+				time.Sleep(time.Millisecond * 50)
+				panic("load4")
+			})
+			if err != nil {
+				atomic.AddInt32(&errs, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if errs != 10 {
+		t.Fatalf("errs:%d, expected 10", errs)
+	}
+
+	//同一个key的对象加载与字符串加载不合并
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				s, err := GetStringOrLoad(cache, "load5", func() (string, error) {
+					time.Sleep(time.Millisecond * 50)
+					return "v5", nil
+				})
+				if err != nil || s != "v5" {
+					t.Errorf("err:%v, s:%v", err, s)
+				}
+				return
+			}
+			var v V
+			if err := GetOrLoad(cache, "load5", &v, func() (interface{}, error) {
+				time.Sleep(time.Millisecond * 50)
+				return &V{"v5", 5, 0}, nil
+			}); err != nil && err != ErrNil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	//不能作为map key的缓存不合并加载, 也不会panic
+	wrapped := taggedCache{cache, []string{"t"}}
+	if s, err = GetStringOrLoad(wrapped, "load6", func() (string, error) { return "v6", nil }); err != nil || s != "v6" {
+		t.Fatalf("err:%v, s:%v", err, s)
+	}
+	if err = GetOrLoad(wrapped, "load7", &v, func() (interface{}, error) { return &V{"v7", 7, 0}, nil }); err != nil || v.V2 != 7 {
+		t.Fatalf("err:%v, v:%v", err, v)
+	}
+}
+
+//taggedCache 不可比较的缓存, 用于测试GetOrLoad
+type taggedCache struct {
+	Cache
+	tags []string
+}
+
+func Test_AtomicCache(t *testing.T) {
+	memory, err := NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory2, err := NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAtomicCache(t, memory)
+	testAtomicCache(t, NewL2Cache(memory, memory2))
+}
+
+func testAtomicCache(t *testing.T, cache Cache) {
+	ok, err := PutIfAbsent(cache, "a1", "v1", time.Second*10)
+	if err != nil || !ok {
+		t.Fatalf("PutIfAbsent, ok:%v, err:%v", ok, err)
+	}
+	ok, err = PutIfAbsent(cache, "a1", "v2")
+	if err != nil || ok {
+		t.Fatalf("PutIfAbsent, ok:%v, err:%v", ok, err)
+	}
+
+	ok, err = CompareAndSwap(cache, "a1", "v2", "v3")
+	if err != nil || ok {
+		t.Fatalf("CompareAndSwap, ok:%v, err:%v", ok, err)
+	}
+	ok, err = CompareAndSwap(cache, "a1", "v1", "v3")
+	if err != nil || !ok {
+		t.Fatalf("CompareAndSwap, ok:%v, err:%v", ok, err)
+	}
+	if v, _ := cache.Get("a1"); v != "v3" {
+		t.Fatalf("a1:%v, expected v3", v)
+	}
+
+	v, err := GetAndDelete(cache, "a1")
+	if err != nil || v != "v3" {
+		t.Fatalf("GetAndDelete, v:%v, err:%v", v, err)
+	}
+	if cache.Exists("a1") {
+		t.Fatal("a1 is exist!")
+	}
+	_, err = GetAndDelete(cache, "a1")
+	if err != ErrNil {
+		t.Fatalf("GetAndDelete, err:%v", err)
+	}
+}
+
+func Test_MemoryLocker(t *testing.T) {
+	memory, err := NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker, err := NewLocker(memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := locker.TryLock("lock1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock("lock1", time.Second); err != ErrLockNotObtained {
+		t.Fatalf("TryLock, err:%v", err)
+	}
+	if err = locker.Unlock("lock1", "other"); err != ErrLockNotHeld {
+		t.Fatalf("Unlock, err:%v", err)
+	}
+	if err = locker.Refresh("lock1", token, time.Second*2); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		locker.Unlock("lock1", token)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	token2, err := locker.Lock(ctx, "lock1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token2 == token {
+		t.Fatal("token2 == token")
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel2()
+	if _, err = locker.Lock(ctx2, "lock1", time.Second); err != context.DeadlineExceeded {
+		t.Fatalf("Lock, err:%v", err)
+	}
+	if err = locker.Unlock("lock1", token2); err != nil {
+		t.Fatal(err)
+	}
+
+	//过期后可重新加锁
+	if _, err = locker.TryLock("lock2", time.Millisecond*50); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if _, err = locker.TryLock("lock2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_LockerTTL(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	memory, _ := NewCache("memory", `{"gccyc":60}`)
+	redis, err := NewCache("redis", `{"addr":"`+m.Addr()+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//ttl<=0时各适配器都返回ErrLockTTL
+	for _, c := range []Cache{memory, redis} {
+		locker, err := NewLocker(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = locker.TryLock("lock", 0); err != ErrLockTTL {
+			t.Fatalf("TryLock 0, err:%v", err)
+		}
+		if _, err = locker.Lock(context.Background(), "lock", -time.Second); err != ErrLockTTL {
+			t.Fatalf("Lock -1s, err:%v", err)
+		}
+		token, err := locker.TryLock("lock", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = locker.Refresh("lock", token, 0); err != ErrLockTTL {
+			t.Fatalf("Refresh 0, err:%v", err)
+		}
+		if _, err = locker.TryLock("lock", time.Second); err != ErrLockNotObtained {
+			t.Fatalf("lock not held, err:%v", err)
+		}
+		if err = locker.Unlock("lock", token); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_Invalidation(t *testing.T) {
+	shared, _ := NewCache("memory", `{"gccyc":60}`)
+	memoryA, _ := NewCache("memory", `{"gccyc":60}`)
+	memoryB, _ := NewCache("memory", `{"gccyc":60}`)
+	nodeA := NewL2Cache(memoryA, shared)
+	nodeB := NewL2Cache(memoryB, shared)
+
+	transport := NewMemoryTransport()
+	cancelA, err := EnableInvalidation(nodeA, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelA()
+	cancelB, err := EnableInvalidation(nodeB, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelB()
+
+	nodeA.Put("inv1", "v1")
+	if v, _ := nodeB.Get("inv1"); v != "v1" {
+		t.Fatalf("inv1:%v, expected v1", v)
+	}
+	nodeA.Put("inv1", "v2")
+	if memoryB.Exists("inv1") {
+		t.Fatal("memoryB: inv1 is exist!")
+	}
+	if !memoryA.Exists("inv1") {
+		t.Fatal("memoryA: inv1 not is exist!")
+	}
+	if v, _ := nodeB.Get("inv1"); v != "v2" {
+		t.Fatalf("inv1:%v, expected v2", v)
+	}
+
+	mapA, _ := nodeA.NewMap("invmap")
+	mapB, _ := nodeB.NewMap("invmap")
+	mapA.Put("f1", "v1")
+	if v, _ := mapB.Get("f1"); v != "v1" {
+		t.Fatalf("f1:%v, expected v1", v)
+	}
+	mapA.Put("f1", "v2")
+	if v, _ := mapB.Get("f1"); v != "v2" {
+		t.Fatalf("f1:%v, expected v2", v)
+	}
+
+	cancelB()
+	nodeA.Put("inv1", "v3")
+	if v, _ := nodeB.Get("inv1"); v != "v2" {
+		t.Fatalf("inv1:%v, expected stale v2", v)
+	}
+}
+
+func Test_RedisInvalidation(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	shared, err := NewCache("redis", `{"addr":"`+m.Addr()+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryA, _ := NewCache("memory", `{"gccyc":60}`)
+	memoryB, _ := NewCache("memory", `{"gccyc":60}`)
+	nodeA := NewL2Cache(memoryA, shared)
+	nodeB := NewL2Cache(memoryB, shared)
+
+	transport, err := NewRedisTransport(shared, "invalidation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelA, err := EnableInvalidation(nodeA, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelA()
+	cancelB, err := EnableInvalidation(nodeB, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelB()
+
+	waitSubscribed := func() {
+		for i := 0; i < 100 && m.PubSubNumSub("invalidation")["invalidation"] < 2; i++ {
+			time.Sleep(time.Millisecond * 20)
+		}
+		if n := m.PubSubNumSub("invalidation")["invalidation"]; n != 2 {
+			t.Fatalf("subscribers:%v, expected 2", n)
+		}
+	}
+	evicted := func(key string) bool {
+		for i := 0; i < 100 && memoryB.Exists(key); i++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+		return !memoryB.Exists(key)
+	}
+
+	waitSubscribed()
+	nodeA.Put("inv1", "v1")
+	if v, _ := nodeB.Get("inv1"); v != "v1" {
+		t.Fatalf("inv1:%v, expected v1", v)
+	}
+	nodeA.Put("inv1", "v2")
+	if !evicted("inv1") {
+		t.Fatal("memoryB: inv1 is exist!")
+	}
+
+	//服务端断开后重新连接并恢复订阅
+	m.Close()
+	if err = m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed()
+	nodeB.Get("inv1")
+	nodeA.Put("inv1", "v3")
+	if !evicted("inv1") {
+		t.Fatal("memoryB: inv1 is exist after reconnect!")
+	}
+	if v, _ := nodeB.Get("inv1"); v != "v3" {
+		t.Fatalf("inv1:%v, expected v3", v)
+	}
+}
+
+func Test_L2PromoteExpire(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	redis, err := NewCache("redis", `{"addr":"`+m.Addr()+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory2, _ := NewCache("memory", `{"gccyc":60}`)
+	for _, c2 := range []Cache{memory2, redis} {
+		memory1, _ := NewCache("memory", `{"gccyc":60}`)
+		cache := NewL2Cache(memory1, c2)
+
+		c2.Put("pe1", "v1", time.Millisecond*100)
+		c2.Put("pe2", "v2")
+		if v, err := cache.Get("pe1"); err != nil || v != "v1" {
+			t.Fatalf("pe1:%v, err:%v", v, err)
+		}
+		if _, hits, err := GetMultiHits(cache, []string{"pe2"}); err != nil || !hits[0] {
+			t.Fatalf("pe2 hits:%v, err:%v", hits, err)
+		}
+		if ttl, err := TTL(memory1, "pe1"); err != nil || ttl <= 0 || ttl > time.Millisecond*100 {
+			t.Fatalf("memory1 pe1 ttl:%v, err:%v", ttl, err)
+		}
+		if ttl, err := TTL(memory1, "pe2"); err != nil || ttl != 0 {
+			t.Fatalf("memory1 pe2 ttl:%v, err:%v", ttl, err)
+		}
+
+		lm, _ := cache.NewMap("pem")
+		m2, _ := c2.NewMap("pem")
+		m2.Put("f1", "v1")
+		c2.SetExpire("pem", time.Millisecond*100)
+		if v, err := lm.Get("f1"); err != nil || v != "v1" {
+			t.Fatalf("f1:%v, err:%v", v, err)
+		}
+		if ttl, err := TTL(memory1, "pem"); err != nil || ttl <= 0 || ttl > time.Millisecond*100 {
+			t.Fatalf("memory1 pem ttl:%v, err:%v", ttl, err)
+		}
+
+		time.Sleep(time.Millisecond * 150)
+		m.FastForward(time.Millisecond * 150)
+		if v, err := cache.Get("pe1"); err == nil {
+			t.Fatalf("pe1:%v, expected expired", v)
+		}
+		if memory1.Exists("pe1") {
+			t.Fatal("memory1: pe1 is exist!")
+		}
+		if v, err := lm.Get("f1"); err == nil {
+			t.Fatalf("f1:%v, expected expired", v)
+		}
+
+		//PromoteTTL限制回填的过期时间
+		cache = NewL2CacheWithOptions(memory1, c2, L2Options{PromoteTTL: time.Millisecond * 50})
+		c2.Put("pe3", "v3", time.Second)
+		cache.Get("pe3")
+		if ttl, err := TTL(memory1, "pe3"); err != nil || ttl <= 0 || ttl > time.Millisecond*50 {
+			t.Fatalf("memory1 pe3 ttl:%v, err:%v", ttl, err)
+		}
+	}
+}
+
+func Test_L2Options(t *testing.T) {
+	memory1, _ := NewCache("memory", `{"gccyc":60}`)
+	memory2, _ := NewCache("memory", `{"gccyc":60}`)
+	cache := NewL2CacheWithOptions(memory1, memory2, L2Options{PromoteTTL: time.Millisecond * 100})
+
+	memory2.Put("p1", "v1")
+	if v, err := cache.Get("p1"); err != nil || v != "v1" {
+		t.Fatalf("p1:%v, err:%v", v, err)
+	}
+	if !memory1.Exists("p1") {
+		t.Fatal("memory1: p1 not is exist!")
+	}
+	time.Sleep(time.Millisecond * 150)
+	if memory1.Exists("p1") {
+		t.Fatal("memory1: p1 is exist!")
+	}
+
+	memory1.Put("h1", "")
+	memory2.Put("h2", "v2")
+	vs, hits, err := GetMultiHits(cache, []string{"h1", "h2", "h3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs[0] != "" || vs[1] != "v2" || vs[2] != "" || !hits[0] || !hits[1] || hits[2] {
+		t.Fatalf("vs:%v, hits:%v", vs, hits)
+	}
+	if !memory1.Exists("h2") {
+		t.Fatal("memory1: h2 not is exist!")
+	}
+
+	around := NewL2CacheWithOptions(memory1, memory2, L2Options{WritePolicy: WriteAround})
+	memory1.Put("w1", "old")
+	around.Put("w1", "new")
+	if memory1.Exists("w1") {
+		t.Fatal("memory1: w1 is exist!")
+	}
+	if v, _ := memory2.Get("w1"); v != "new" {
+		t.Fatalf("memory2: w1:%v", v)
+	}
+	m, err := around.NewMap("wmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Put("f1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.Size(); err != nil || n != 1 {
+		t.Fatalf("size:%v, err:%v", n, err)
+	}
+
+	l1only := NewL2CacheWithOptions(memory1, memory2, L2Options{WritePolicy: WriteL1Only})
+	l1only.Put("w2", "v2")
+	if !memory1.Exists("w2") || memory2.Exists("w2") {
+		t.Fatal("w2 should only be in memory1")
+	}
+
+	memory3, _ := NewCache("memory", `{"gccyc":60}`)
+	cs := []Cache{memory1, memory2, memory3}
+	NewLNCache(cs...)
+	if cs[1] != memory2 || cs[2] != memory3 {
+		t.Fatal("NewLNCache modified its arguments")
+	}
+}
+
+const benchKeys = 1000000
+
+func Test_Codec(t *testing.T) {
+	v := &V{V1: "v1", V2: 2, V3: 3.5}
+	codecs := []Codec{JSONCodec, GobCodec, MsgpackCodec}
+	for _, method := range []string{"gzip", "snappy"} {
+		c, err := NewCompressCodec(JSONCodec, method, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codecs = append(codecs, c)
+	}
+	for i, c := range codecs {
+		b, err := c.Marshal(v)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		var v2 V
+		if err = c.Unmarshal(b, &v2); err != nil {
+			t.Fatal(i, err)
+		}
+		if v2 != *v {
+			t.Fatalf("%v: v2:%v", i, v2)
+		}
+	}
+
+	//未压缩的json数据仍可读取
+	zc, _ := NewCompressCodec(JSONCodec, "gzip", 0)
+	b, _ := JSONCodec.Marshal(v)
+	var v3 V
+	if err := zc.Unmarshal(b, &v3); err != nil || v3 != *v {
+		t.Fatalf("v3:%v, err:%v", v3, err)
+	}
+	if _, err := ProtobufCodec.Marshal(v); err == nil {
+		t.Fatal("protobuf: V is not a proto.Message")
+	}
+	if _, err := NewCache("memory", `{"codec":"xml"}`); err == nil {
+		t.Fatal("memory: codec xml is exist!")
+	}
+
+	cache, err := NewCache("memory", `{"codec":"gob", "compress":"snappy"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.PutObject("o1", v)
+	v.V1 = "changed"
+	var o1 V
+	if err = cache.GetObject("o1", &o1); err != nil || o1.V1 != "v1" {
+		t.Fatalf("o1:%v, err:%v", o1, err)
+	}
+	m, _ := cache.NewMap("omap")
+	m.PutObject("o2", v)
+	var o2 V
+	if err = m.GetObject("o2", &o2); err != nil || o2 != *v {
+		t.Fatalf("o2:%v, err:%v", o2, err)
+	}
+}
+
+func Test_Context(t *testing.T) {
+	memory1, _ := NewCache("memory", `{"gccyc":60}`)
+	memory2, _ := NewCache("memory", `{"gccyc":60}`)
+	cache := NewL2Cache(memory1, memory2)
+	cc := ContextOf(cache)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := cc.PutCtx(ctx, "c1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cc.GetCtx(ctx, "c1"); err != nil || v != "v1" {
+		t.Fatalf("c1:%v, err:%v", v, err)
+	}
+	m, _ := cache.NewMap("cmap")
+	mc := MapContextOf(m)
+	if n, err := mc.IncrByCtx(ctx, "n", 2); err != nil || n != 2 {
+		t.Fatalf("n:%v, err:%v", n, err)
+	}
+
+	cancel()
+	if err := cc.PutCtx(ctx, "c2", "v2"); err != context.Canceled {
+		t.Fatalf("c2 err:%v", err)
+	}
+	if memory1.Exists("c2") || memory2.Exists("c2") {
+		t.Fatal("c2 is exist!")
+	}
+	if _, err := cc.GetCtx(ctx, "c1"); err != context.Canceled {
+		t.Fatalf("c1 err:%v", err)
+	}
+	if _, err := cc.ExistsCtx(ctx, "c1"); err != context.Canceled {
+		t.Fatalf("c1 err:%v", err)
+	}
+	if _, err := mc.SizeCtx(ctx); err != context.Canceled {
+		t.Fatalf("cmap err:%v", err)
+	}
+}
+
+//Test_RedisDeadline 服务端不响应时, 命令在ctx的deadline后返回
+func Test_RedisDeadline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					//只响应连接初始化时的SELECT
+					if strings.TrimSpace(line) == "SELECT" {
+						conn.Write([]byte("+OK\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	cache, err := NewCache("redis", `{"addr":"`+l.Addr().String()+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	_, err = ContextOf(cache).GetCtx(ctx, "k")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("err:%v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("GetCtx took %v", d)
+	}
+}
+
+func Test_MatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{escapePattern("p[1]*") + "*", "p[1]*x", true},
+		{`[\]a]x`, "]x", true},
+		{`[\]a]x`, "ax", true},
+		{`[\]a]x`, "bx", false},
+		{"*a*b", "xaxxb", true},
+		{"a*", "b", false},
+		{"*a*a*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 1000), false},
+		{"*a*a*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 1000) + "b", true},
+	}
+	for _, c := range cases {
+		if matchPattern(c.pattern, c.key) != c.match {
+			t.Fatalf("pattern:%v, key:%v, match:%v", c.pattern, c.key, !c.match)
+		}
+	}
+}
+
+func Test_Keys(t *testing.T) {
+	cache, err := NewCache("memory", `{"gccyc":60, "shards":4}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		cache.Put("user:"+strconv.Itoa(i), "v")
+		cache.Put("order:"+strconv.Itoa(i), "v")
+	}
+	cache.NewMap("user:map")
+	cache.Put("user:expired", "v", time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	keys, err := cache.Keys("user:*")
+	if err != nil || len(keys) != 11 {
+		t.Fatalf("keys:%v, err:%v", keys, err)
+	}
+
+	var cursor uint64
+	seen := map[string]bool{}
+	for {
+		next, keys, err := cache.Scan(cursor, "order:*", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 10 {
+		t.Fatalf("seen:%v", seen)
+	}
+
+	if n, err := cache.DeleteByPrefix("user:"); err != nil || n != 11 {
+		t.Fatalf("n:%v, err:%v", n, err)
+	}
+	if keys, _ := cache.Keys(""); len(keys) != 10 {
+		t.Fatalf("keys:%v", keys)
+	}
+
+	ns1 := WithNamespace(cache, "svc1:")
+	ns2 := WithNamespace(cache, "svc2:")
+	ns1.Put("k", "v1")
+	ns2.Put("k", "v2")
+	if v, _ := ns1.Get("k"); v != "v1" {
+		t.Fatalf("svc1 k:%v", v)
+	}
+	if v, _ := cache.Get("svc2:k"); v != "v2" {
+		t.Fatalf("svc2:k:%v", v)
+	}
+	m, _ := ns1.NewMap("m")
+	m.Put("a", "1")
+	if !cache.Exists("svc1:m") {
+		t.Fatal("svc1:m not is exist!")
+	}
+	if keys, _ := ns1.Keys("*"); len(keys) != 2 || (keys[0] != "k" && keys[1] != "k") {
+		t.Fatalf("svc1 keys:%v", keys)
+	}
+	if n, _ := ns1.DeleteByPrefix(""); n != 2 {
+		t.Fatalf("svc1 deleted:%v", n)
+	}
+	if !ns2.Exists("k") {
+		t.Fatal("svc2 k not is exist!")
+	}
+
+	locker, err := NewLocker(ns1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock("l", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Exists("svc1:l") {
+		t.Fatal("svc1:l not is exist!")
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	cache, err := NewCache("memory", `{"gccyc":1, "maxEntries":3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []Operation
+	var lock sync.Mutex
+	SetObserver(cache, ObserverFunc(func(op *Operation) {
+		lock.Lock()
+		defer lock.Unlock()
+		ops = append(ops, *op)
+	}))
+
+	cache.Put("s1", "v1")
+	cache.Put("s2", "v2", time.Millisecond*10)
+	cache.Get("s1")
+	cache.Get("s3")
+	cache.Delete("s1")
+	cache.Put("s4", "v4")
+	cache.Put("s5", "v5")
+	cache.Put("s6", "v6")
+	time.Sleep(time.Millisecond * 1100)
+
+	st, err := GetStats(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v", st)
+	if st.Hits != 1 || st.Misses != 1 || st.Puts != 5 || st.Deletes != 1 {
+		t.Fatalf("stats:%+v", st)
+	}
+	if st.Evictions != 1 || st.Expirations != 0 || st.Items != 3 {
+		t.Fatalf("stats:%+v", st)
+	}
+	lock.Lock()
+	if len(ops) != 8 || ops[2].Name != "Get" || !ops[2].Hit || ops[3].Hit || ops[3].Err != nil {
+		t.Fatalf("ops:%+v", ops)
+	}
+	lock.Unlock()
+
+	memory1, _ := NewCache("memory", `{"gccyc":1}`)
+	memory2, _ := NewCache("memory", `{"gccyc":1}`)
+	l2 := NewL2Cache(memory1, memory2)
+	levels := map[int]int{}
+	SetObserver(l2, ObserverFunc(func(op *Operation) {
+		lock.Lock()
+		defer lock.Unlock()
+		levels[op.Level]++
+	}))
+	memory2.Put("k1", "v1")
+	memory2.Put("k2", "v2", time.Millisecond*10)
+	l2.Get("k1")
+	l2.Get("k1")
+	l2.Get("k0")
+	time.Sleep(time.Millisecond * 1100)
+
+	st, err = GetStats(l2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v", st)
+	if st.Hits != 2 || st.Misses != 1 || len(st.Levels) != 2 {
+		t.Fatalf("stats:%+v", st)
+	}
+	if st.Levels[0].Hits != 1 || st.Levels[0].Misses != 2 || st.Levels[1].Hits != 1 || st.Levels[1].Misses != 1 {
+		t.Fatalf("levels:%+v", st.Levels)
+	}
+	if st.Expirations != 1 || st.Items != 1 {
+		t.Fatalf("stats:%+v", st)
+	}
+	lock.Lock()
+	if levels[0] != 3 || levels[1] != 4 || levels[2] != 4 {
+		t.Fatalf("levels:%v", levels)
+	}
+	lock.Unlock()
+}
+
+func newBenchMemory(b *testing.B, config string) Cache {
+	cache, err := NewCache("memory", config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchKeys; i++ {
+		cache.Put("bk"+strconv.Itoa(i), "v", time.Hour)
+	}
+	b.ResetTimer()
+	return cache
+}
+
+func benchmarkMemoryGetPut(b *testing.B, config string) {
+	cache := newBenchMemory(b, config)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := "bk" + strconv.Itoa(i*7919%benchKeys)
+			if i%10 == 0 {
+				cache.Put(key, "v", time.Hour)
+			} else {
+				cache.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+//单锁(旧实现)与分片锁下的并发读写
+func Benchmark_MemoryGetPut_1M_1Shard(b *testing.B) {
+	benchmarkMemoryGetPut(b, `{"gccyc":0, "shards":1}`)
+}
+
+func Benchmark_MemoryGetPut_1M_16Shards(b *testing.B) {
+	benchmarkMemoryGetPut(b, `{"gccyc":0, "shards":16}`)
+}
+
+//一次GC的耗时, 过期堆只处理到期数据
+func Benchmark_MemoryGC_1M_Heap(b *testing.B) {
+	mc := newBenchMemory(b, `{"gccyc":0}`).(*memoryCache)
+	for i := 0; i < b.N; i++ {
+		for _, s := range mc.shards {
+			s.itemExpireds(time.Now())
+		}
+	}
+}
+
+//旧实现的GC, 持有写锁遍历全部数据
+func Benchmark_MemoryGC_1M_FullScan(b *testing.B) {
+	mc := newBenchMemory(b, `{"gccyc":0, "shards":1}`).(*memoryCache)
+	s := mc.shards[0]
+	for i := 0; i < b.N; i++ {
+		s.lock.Lock()
+		for key, itm := range s.items {
+			if itm.isExpire() {
+				s.drop(key, itm)
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+func Test_MemorySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := `{"gccyc":60, "snapshotPath":"` + filepath.ToSlash(filepath.Join(dir, "memory.snapshot")) + `"}`
+
+	cache, err := NewCache("memory", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("k1", "v1")
+	cache.Put("k2", "v2", time.Millisecond*100)
+	cache.Put("k3", "v3", time.Hour)
+	cache.IncrBy("n", 5)
+	cache.IncrByFloat("f", 1.5)
+	cache.PutObject("obj", &V{V1: "v1", V2: 2, V3: 3.5})
+	m, _ := cache.NewMap("m")
+	m.Put("a", "1")
+	m.PutObject("b", &V{V1: "b"})
+	cache.NewMap("empty")
+
+	if err = cache.(Snapshotter).Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 150)
+
+	cache, err = NewCache("memory", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.Get("k1"); err != nil || v != "v1" {
+		t.Fatal("k1", v, err)
+	}
+	if cache.Exists("k2") {
+		t.Fatal("k2 expired, should not be loaded")
+	}
+	if v, err := cache.Get("k3"); err != nil || v != "v3" {
+		t.Fatal("k3", v, err)
+	}
+	if n, err := cache.IncrBy("n", 1); err != nil || n != 6 {
+		t.Fatal("n", n, err)
+	}
+	if f, err := cache.IncrByFloat("f", 1); err != nil || f != 2.5 {
+		t.Fatal("f", f, err)
+	}
+	var v V
+	if err = cache.GetObject("obj", &v); err != nil || v.V1 != "v1" || v.V2 != 2 || v.V3 != 3.5 {
+		t.Fatal("obj", v, err)
+	}
+	m, _ = cache.NewMap("m")
+	if a, err := m.Get("a"); err != nil || a != "1" {
+		t.Fatal("m.a", a, err)
+	}
+	v = V{}
+	if err = m.GetObject("b", &v); err != nil || v.V1 != "b" {
+		t.Fatal("m.b", v, err)
+	}
+	if keys, _ := cache.Keys("empty"); len(keys) != 1 {
+		t.Fatal("empty map not loaded")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatal("temp file not removed", len(files))
+	}
+}
+
+func Test_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := `{"dir":"` + filepath.ToSlash(dir) + `", "gccyc":1}`
+	cache, err := NewCache("file", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCache(t, cache)
+	testMap(t, cache, "f")
+	testMapBulk(t, cache, "f")
+
+	cache.Put("user:1", "a")
+	cache.Put("user:2", "b", time.Millisecond*100)
+	cache.Put("order:1", "c")
+	if keys, _ := cache.Keys("user:*"); len(keys) != 2 {
+		t.Fatal("Keys", keys)
+	}
+	time.Sleep(time.Millisecond * 150)
+	if cache.Exists("user:2") {
+		t.Fatal("user:2 should be expired")
+	}
+
+	//重新打开同一目录, 数据仍然存在
+	cache, err = NewCache("file", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.Get("user:1"); err != nil || v != "a" {
+		t.Fatal("user:1", v, err)
+	}
+	if _, err = cache.IncrBy("user:1", 1); err == nil {
+		t.Fatal("IncrBy on a non-integer value should fail")
+	}
+	if n, err := cache.DeleteByPrefix("user:"); err != nil || n != 1 {
+		t.Fatal("DeleteByPrefix", n, err)
+	}
+
+	memory, err := NewCache("memory", `{"gccyc":1, "defaultExpire":3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testL2Cache(t, NewL2Cache(memory, cache), memory, cache)
+}
+
+func Test_RedisCluster(t *testing.T) {
+	cluster, err := redistest.NewCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	cache, err := NewCache("redis", `{"mode":"cluster", "addr":"`+strings.Join(cluster.Addrs()[:2], ",")+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"k1", "k2", "k3", "k4", "k5", "k6"}
+	for _, key := range keys {
+		if err = cache.Put(key, "v"+key); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := cluster.Owner(key).Value(key); !ok {
+			t.Fatal(key, "not stored on the owner node")
+		}
+	}
+	//GetMulti的key分布在不同slot
+	vals, err := cache.GetMulti(append(keys, "none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if vals[i] != "v"+key {
+			t.Fatalf("GetMulti %s = %v", key, vals[i])
+		}
+	}
+	if vals[len(keys)] != "" {
+		t.Fatal("GetMulti none", vals[len(keys)])
+	}
+
+	//Keys遍历所有节点
+	all, err := cache.Keys("k*")
+	if err != nil || len(all) != len(keys) {
+		t.Fatal("Keys", all, err)
+	}
+	n, err := cache.DeleteByPrefix("k")
+	if err != nil || n != len(keys) {
+		t.Fatal("DeleteByPrefix", n, err)
+	}
+	if cache.Exists("k1") {
+		t.Fatal("k1 not deleted")
+	}
+}
+
+func Test_RedisAtomicExpire(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	cache, err := NewCache("redis", `{"addr":"`+s.Addr+`", "defaultExpire":"2"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//不足1秒的过期时间不能被忽略
+	PutIfAbsent(cache, "a1", "v1", time.Millisecond*500)
+	PutIfAbsent(cache, "a2", "v1")
+	PutIfAbsent(cache, "a3", "v1", time.Microsecond)
+	if s.CommandCount("SET a1 v1 PX 500 NX") != 1 || s.CommandCount("SET a2 v1 PX 2000 NX") != 1 || s.CommandCount("SET a3 v1 PX 1 NX") != 1 {
+		t.Fatal(s.Commands)
+	}
+}
+
+func Test_RedisExpire(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	cache, err := NewCache("redis", `{"addr":"`+m.Addr()+`", "defaultExpire":"2"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//不足1秒及不是整秒的过期时间不能被忽略
+	if err = cache.Put("e1", "v1", time.Millisecond*500); err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.PutObject("e2", &V{"v2", 2, 0}, time.Millisecond*1500); err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("e3", "v3")
+	cache.Put("e4", "v4")
+	if err = cache.SetExpire("e4", time.Millisecond*200); err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("e5", "v5")
+	if err = cache.SetExpire("e5", 0); err != nil {
+		t.Fatal(err)
+	}
+	mp, _ := cache.NewMap("e6", time.Millisecond*300)
+	if err = mp.Put("f", "v"); err != nil {
+		t.Fatal(err)
+	}
+	ttls := map[string]time.Duration{"e1": time.Millisecond * 500, "e2": time.Millisecond * 1500, "e3": time.Second * 2,
+		"e4": time.Millisecond * 200, "e5": 0, "e6": time.Millisecond * 300}
+	for key, ttl := range ttls {
+		if m.TTL(key) != ttl {
+			t.Fatalf("%s ttl:%v, expected %v", key, m.TTL(key), ttl)
+		}
+	}
+	m.FastForward(time.Millisecond * 600)
+	if _, err = cache.Get("e1"); err != ErrNil {
+		t.Fatal("e1 not expired", err)
+	}
+	if !cache.Exists("e2") || !cache.Exists("e5") {
+		t.Fatal("e2, e5 expired")
+	}
+}
+
+func Test_Eval(t *testing.T) {
+	memory, err := NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Eval(memory, "return 1", nil); err != ErrNotSupported {
+		t.Fatal("memory Eval", err)
+	}
+
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	redis, err := NewCache("redis", `{"addr":"`+s.Addr+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "return KEYS[1] .. ARGV[1]"
+	s.HandleScript(src, func(keys, args []string) interface{} {
+		return keys[0] + "=" + args[0]
+	})
+	v, err := Eval(NewL2Cache(memory, WithNamespace(redis, "ns:")), src, []string{"k"}, 1)
+	if err != nil || string(v.([]byte)) != "ns:k=1" {
+		t.Fatal(v, err)
+	}
+}
+
+func Test_RedisSentinel(t *testing.T) {
+	master, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	sentinel, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sentinel.Close()
+	sentinel.SetMaster(master)
+
+	if _, err = NewCache("redis", `{"mode":"sentinel", "addr":"`+sentinel.Addr+`"}`); err == nil {
+		t.Fatal("masterName required")
+	}
+	cache, err := NewCache("redis", `{"mode":"sentinel", "masterName":"mymaster", "addr":"`+sentinel.Addr+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.Put("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := master.Value("k"); v != "v" {
+		t.Fatal("not written to master", v)
+	}
+}
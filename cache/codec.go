@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"github.com/tryor/commons/codec"
+)
+
+//Codec PutObject/GetObject使用的序列化方式, 见codec包
+type Codec = codec.Codec
+
+var (
+	JSONCodec     = codec.JSON
+	GobCodec      = codec.Gob
+	MsgpackCodec  = codec.Msgpack
+	ProtobufCodec = codec.Protobuf //对象必须实现proto.Message
+)
+
+//RegisterCodec 注册序列化方式, 注册后可在适配器配置中通过名称使用, 同codec.Register
+func RegisterCodec(name string, c Codec) {
+	codec.Register(name, c)
+}
+
+func GetCodec(name string) (Codec, error) {
+	return codec.Get(name)
+}
+
+//NewCodec 根据适配器配置创建Codec, 其它包中的适配器也可以使用, 同codec.New
+func NewCodec(name string, def Codec, compress string, threshold int) (Codec, error) {
+	return codec.New(name, def, compress, threshold)
+}
+
+//NewCompressCodec 序列化后数据达到threshold字节时进行压缩, 同codec.NewCompress
+func NewCompressCodec(c Codec, method string, threshold int) (Codec, error) {
+	return codec.NewCompress(c, method, threshold)
+}
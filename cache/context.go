@@ -0,0 +1,347 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+//CacheContext Cache的context版本, 适配器可选实现
+//ctx结束时返回ctx.Err(), redis适配器以ctx的deadline作为单条命令的超时时间
+type CacheContext interface {
+	PutCtx(ctx context.Context, key string, val string, expire ...time.Duration) error
+	GetCtx(ctx context.Context, key string) (string, error)
+	GetMultiCtx(ctx context.Context, keys []string) ([]string, error)
+	PutObjectCtx(ctx context.Context, key string, val interface{}, expire ...time.Duration) error
+	GetObjectCtx(ctx context.Context, key string, valptr interface{}) error
+	DeleteCtx(ctx context.Context, key string) error
+	IncrByCtx(ctx context.Context, key string, delta int64) (int64, error)
+	IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error)
+	ExistsCtx(ctx context.Context, key string) (bool, error)
+	SetExpireCtx(ctx context.Context, key string, expire ...time.Duration) error
+}
+
+//MapContext Map的context版本, 适配器可选实现
+type MapContext interface {
+	PutCtx(ctx context.Context, key string, val string) error
+	GetCtx(ctx context.Context, key string) (string, error)
+	GetMultiCtx(ctx context.Context, keys []string) ([]string, error)
+	PutObjectCtx(ctx context.Context, key string, val interface{}) error
+	GetObjectCtx(ctx context.Context, key string, valptr interface{}) error
+	DeleteCtx(ctx context.Context, key string) error
+	IncrByCtx(ctx context.Context, key string, delta int64) (int64, error)
+	IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error)
+	ExistsCtx(ctx context.Context, key string) (bool, error)
+	SizeCtx(ctx context.Context) (int, error)
+	ClearCtx(ctx context.Context) error
+}
+
+//ContextOf 返回c的context版本
+//c未实现CacheContext时, 只在调用前检查ctx是否已结束
+func ContextOf(c Cache) CacheContext {
+	if cc, ok := c.(CacheContext); ok {
+		return cc
+	}
+	return &ctxCache{c}
+}
+
+//MapContextOf 返回m的context版本
+//m未实现MapContext时, 只在调用前检查ctx是否已结束
+func MapContextOf(m Map) MapContext {
+	if mc, ok := m.(MapContext); ok {
+		return mc
+	}
+	return &ctxMap{m}
+}
+
+//hitsGetterContext HitsGetter的context版本
+type hitsGetterContext interface {
+	getMultiHitsCtx(ctx context.Context, keys []string) ([]string, []bool, error)
+}
+
+type ctxCache struct {
+	c Cache
+}
+
+func (cc *ctxCache) PutCtx(ctx context.Context, key string, val string, expire ...time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cc.c.Put(key, val, expire...)
+}
+func (cc *ctxCache) GetCtx(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return cc.c.Get(key)
+}
+func (cc *ctxCache) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return cc.c.GetMulti(keys)
+}
+func (cc *ctxCache) PutObjectCtx(ctx context.Context, key string, val interface{}, expire ...time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cc.c.PutObject(key, val, expire...)
+}
+func (cc *ctxCache) GetObjectCtx(ctx context.Context, key string, valptr interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cc.c.GetObject(key, valptr)
+}
+func (cc *ctxCache) DeleteCtx(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cc.c.Delete(key)
+}
+func (cc *ctxCache) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cc.c.IncrBy(key, delta)
+}
+func (cc *ctxCache) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cc.c.IncrByFloat(key, delta)
+}
+func (cc *ctxCache) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return cc.c.Exists(key), nil
+}
+func (cc *ctxCache) SetExpireCtx(ctx context.Context, key string, expire ...time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cc.c.SetExpire(key, expire...)
+}
+
+type ctxMap struct {
+	m Map
+}
+
+func (cm *ctxMap) PutCtx(ctx context.Context, key string, val string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cm.m.Put(key, val)
+}
+func (cm *ctxMap) GetCtx(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return cm.m.Get(key)
+}
+func (cm *ctxMap) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return cm.m.GetMulti(keys)
+}
+func (cm *ctxMap) PutObjectCtx(ctx context.Context, key string, val interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cm.m.PutObject(key, val)
+}
+func (cm *ctxMap) GetObjectCtx(ctx context.Context, key string, valptr interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cm.m.GetObject(key, valptr)
+}
+func (cm *ctxMap) DeleteCtx(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cm.m.Delete(key)
+}
+func (cm *ctxMap) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cm.m.IncrBy(key, delta)
+}
+func (cm *ctxMap) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cm.m.IncrByFloat(key, delta)
+}
+func (cm *ctxMap) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return cm.m.Exists(key), nil
+}
+func (cm *ctxMap) SizeCtx(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cm.m.Size()
+}
+func (cm *ctxMap) ClearCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cm.m.Clear()
+}
+
+//boundCache 将ctx绑定到c, 通过Cache接口调用c的context版本
+//用于多级缓存复用Cache接口上的读写逻辑
+type boundCache struct {
+	Cache
+	ctx context.Context
+}
+
+func bindCache(ctx context.Context, c Cache) Cache {
+	return &boundCache{Cache: c, ctx: ctx}
+}
+
+func (b *boundCache) cc() CacheContext {
+	return ContextOf(b.Cache)
+}
+
+func (b *boundCache) Put(key string, val string, expire ...time.Duration) error {
+	return b.cc().PutCtx(b.ctx, key, val, expire...)
+}
+func (b *boundCache) Get(key string) (string, error) {
+	return b.cc().GetCtx(b.ctx, key)
+}
+func (b *boundCache) GetMulti(keys []string) ([]string, error) {
+	return b.cc().GetMultiCtx(b.ctx, keys)
+}
+func (b *boundCache) GetMultiHits(keys []string) ([]string, []bool, error) {
+	if hg, ok := b.Cache.(hitsGetterContext); ok {
+		return hg.getMultiHitsCtx(b.ctx, keys)
+	}
+	if err := b.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return GetMultiHits(b.Cache, keys)
+}
+func (b *boundCache) TTL(key string) (time.Duration, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return TTL(b.Cache, key)
+}
+func (b *boundCache) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	if err := b.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return GetObjects(b.Cache, keys, valptr)
+}
+func (b *boundCache) PutObject(key string, val interface{}, expire ...time.Duration) error {
+	return b.cc().PutObjectCtx(b.ctx, key, val, expire...)
+}
+func (b *boundCache) GetObject(key string, valptr interface{}) error {
+	return b.cc().GetObjectCtx(b.ctx, key, valptr)
+}
+func (b *boundCache) Delete(key string) error {
+	return b.cc().DeleteCtx(b.ctx, key)
+}
+func (b *boundCache) IncrBy(key string, delta int64) (int64, error) {
+	return b.cc().IncrByCtx(b.ctx, key, delta)
+}
+func (b *boundCache) IncrByFloat(key string, delta float64) (float64, error) {
+	return b.cc().IncrByFloatCtx(b.ctx, key, delta)
+}
+func (b *boundCache) Exists(key string) bool {
+	ok, _ := b.cc().ExistsCtx(b.ctx, key)
+	return ok
+}
+func (b *boundCache) SetExpire(key string, expire ...time.Duration) error {
+	return b.cc().SetExpireCtx(b.ctx, key, expire...)
+}
+
+//原子操作没有context版本, 只在调用前检查ctx
+func (b *boundCache) PutIfAbsent(key string, val string, expire ...time.Duration) (bool, error) {
+	if err := b.ctx.Err(); err != nil {
+		return false, err
+	}
+	return PutIfAbsent(b.Cache, key, val, expire...)
+}
+func (b *boundCache) CompareAndSwap(key string, old, new string, expire ...time.Duration) (bool, error) {
+	if err := b.ctx.Err(); err != nil {
+		return false, err
+	}
+	return CompareAndSwap(b.Cache, key, old, new, expire...)
+}
+func (b *boundCache) GetAndDelete(key string) (string, error) {
+	if err := b.ctx.Err(); err != nil {
+		return "", err
+	}
+	return GetAndDelete(b.Cache, key)
+}
+
+//boundMap 将ctx绑定到m, 通过Map接口调用m的context版本
+type boundMap struct {
+	Map
+	ctx context.Context
+}
+
+func bindMap(ctx context.Context, m Map) Map {
+	return &boundMap{Map: m, ctx: ctx}
+}
+
+func (b *boundMap) mc() MapContext {
+	return MapContextOf(b.Map)
+}
+
+func (b *boundMap) Put(key string, val string) error {
+	return b.mc().PutCtx(b.ctx, key, val)
+}
+func (b *boundMap) Get(key string) (string, error) {
+	return b.mc().GetCtx(b.ctx, key)
+}
+func (b *boundMap) GetMulti(keys []string) ([]string, error) {
+	return b.mc().GetMultiCtx(b.ctx, keys)
+}
+func (b *boundMap) GetMultiHits(keys []string) ([]string, []bool, error) {
+	if hg, ok := b.Map.(hitsGetterContext); ok {
+		return hg.getMultiHitsCtx(b.ctx, keys)
+	}
+	if err := b.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return GetMultiHits(b.Map, keys)
+}
+func (b *boundMap) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	if err := b.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return GetObjects(b.Map, keys, valptr)
+}
+func (b *boundMap) PutObject(key string, val interface{}) error {
+	return b.mc().PutObjectCtx(b.ctx, key, val)
+}
+func (b *boundMap) GetObject(key string, valptr interface{}) error {
+	return b.mc().GetObjectCtx(b.ctx, key, valptr)
+}
+func (b *boundMap) Delete(key string) error {
+	return b.mc().DeleteCtx(b.ctx, key)
+}
+func (b *boundMap) IncrBy(key string, delta int64) (int64, error) {
+	return b.mc().IncrByCtx(b.ctx, key, delta)
+}
+func (b *boundMap) IncrByFloat(key string, delta float64) (float64, error) {
+	return b.mc().IncrByFloatCtx(b.ctx, key, delta)
+}
+func (b *boundMap) Exists(key string) bool {
+	ok, _ := b.mc().ExistsCtx(b.ctx, key)
+	return ok
+}
+func (b *boundMap) Size() (int, error) {
+	return b.mc().SizeCtx(b.ctx)
+}
+func (b *boundMap) Clear() error {
+	return b.mc().ClearCtx(b.ctx)
+}
@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+//memory adapter eviction policies
+const (
+	EvictLRU    = "lru"    //最近最少使用
+	EvictLFU    = "lfu"    //最不经常使用
+	EvictRandom = "random" //随机淘汰
+)
+
+//evictOwner - 被淘汰数据的持有者, memoryCache或memoryMap
+type evictOwner interface {
+	evict(n *evictNode)
+}
+
+type evictNode struct {
+	owner  evictOwner
+	key    string
+	size   int64
+	freq   uint64
+	seq    uint64
+	elem   *list.Element //lru
+	index  int           //lfu heap / random slice
+	linked bool
+}
+
+type evictPolicy interface {
+	add(n *evictNode)
+	access(n *evictNode)
+	remove(n *evictNode)
+	victim() *evictNode
+}
+
+//evictor 在数据条数或字节数超出限制时，按策略淘汰数据
+type evictor struct {
+	lock       sync.Mutex
+	policy     evictPolicy
+	maxEntries int
+	maxBytes   int64
+	entries    int
+	bytes      int64
+	seq        uint64
+	evictions  uint64
+}
+
+func newEvictor(policy string, maxEntries int, maxBytes int64) (*evictor, error) {
+	if maxEntries <= 0 && maxBytes <= 0 {
+		return nil, nil
+	}
+	e := &evictor{maxEntries: maxEntries, maxBytes: maxBytes}
+	switch strings.ToLower(policy) {
+	case "", EvictLRU:
+		e.policy = &lruPolicy{ll: list.New()}
+	case EvictLFU:
+		e.policy = &lfuPolicy{}
+	case EvictRandom:
+		e.policy = &randomPolicy{}
+	default:
+		return nil, errors.New("cache: unknown evict policy " + policy)
+	}
+	return e, nil
+}
+
+func (e *evictor) add(owner evictOwner, key string, size int64) *evictNode {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.seq++
+	n := &evictNode{owner: owner, key: key, size: size, seq: e.seq, linked: true}
+	e.policy.add(n)
+	e.entries++
+	e.bytes += size
+	return n
+}
+
+func (e *evictor) access(n *evictNode) {
+	if n == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if n.linked {
+		n.freq++
+		e.policy.access(n)
+	}
+}
+
+func (e *evictor) remove(n *evictNode) {
+	if n == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.unlink(n)
+}
+
+func (e *evictor) unlink(n *evictNode) {
+	if !n.linked {
+		return
+	}
+	e.policy.remove(n)
+	n.linked = false
+	e.entries--
+	e.bytes -= n.size
+}
+
+func (e *evictor) over() bool {
+	return (e.maxEntries > 0 && e.entries > e.maxEntries) ||
+		(e.maxBytes > 0 && e.bytes > e.maxBytes)
+}
+
+//shrink 淘汰数据直到不再超出限制, 调用时不能持有owner的锁
+func (e *evictor) shrink() {
+	for {
+		e.lock.Lock()
+		if !e.over() {
+			e.lock.Unlock()
+			return
+		}
+		n := e.policy.victim()
+		if n == nil {
+			e.lock.Unlock()
+			return
+		}
+		e.unlink(n)
+		e.evictions++
+		e.lock.Unlock()
+
+		n.owner.evict(n)
+	}
+}
+
+//sizeOf 估算数据占用字节数
+func sizeOf(key string, val interface{}) int64 {
+	size := int64(len(key))
+	switch v := val.(type) {
+	case nil:
+	case string:
+		size += int64(len(v))
+	case *string:
+		size += int64(len(*v))
+	case []byte:
+		size += int64(len(v))
+	case codecValue:
+		size += int64(len(v))
+	case int, int32, int64, uint, uint32, uint64, float32, float64, bool:
+		size += 8
+	case *memoryMap:
+	default:
+		if b, err := json.Marshal(v); err == nil {
+			size += int64(len(b))
+		}
+	}
+	return size
+}
+
+type lruPolicy struct {
+	ll *list.List
+}
+
+func (p *lruPolicy) add(n *evictNode) {
+	n.elem = p.ll.PushFront(n)
+}
+
+func (p *lruPolicy) access(n *evictNode) {
+	p.ll.MoveToFront(n.elem)
+}
+
+func (p *lruPolicy) remove(n *evictNode) {
+	p.ll.Remove(n.elem)
+	n.elem = nil
+}
+
+func (p *lruPolicy) victim() *evictNode {
+	ele := p.ll.Back()
+	if ele == nil {
+		return nil
+	}
+	return ele.Value.(*evictNode)
+}
+
+//lfuPolicy 访问次数最少的先淘汰, 次数相同时先淘汰较早加入的
+type lfuPolicy struct {
+	nodes []*evictNode
+}
+
+func (p *lfuPolicy) Len() int { return len(p.nodes) }
+func (p *lfuPolicy) Less(i, j int) bool {
+	if p.nodes[i].freq == p.nodes[j].freq {
+		return p.nodes[i].seq < p.nodes[j].seq
+	}
+	return p.nodes[i].freq < p.nodes[j].freq
+}
+func (p *lfuPolicy) Swap(i, j int) {
+	p.nodes[i], p.nodes[j] = p.nodes[j], p.nodes[i]
+	p.nodes[i].index = i
+	p.nodes[j].index = j
+}
+func (p *lfuPolicy) Push(x interface{}) {
+	n := x.(*evictNode)
+	n.index = len(p.nodes)
+	p.nodes = append(p.nodes, n)
+}
+func (p *lfuPolicy) Pop() interface{} {
+	last := len(p.nodes) - 1
+	n := p.nodes[last]
+	p.nodes[last] = nil
+	p.nodes = p.nodes[:last]
+	n.index = -1
+	return n
+}
+
+func (p *lfuPolicy) add(n *evictNode) {
+	heap.Push(p, n)
+}
+
+func (p *lfuPolicy) access(n *evictNode) {
+	heap.Fix(p, n.index)
+}
+
+func (p *lfuPolicy) remove(n *evictNode) {
+	heap.Remove(p, n.index)
+}
+
+func (p *lfuPolicy) victim() *evictNode {
+	if len(p.nodes) == 0 {
+		return nil
+	}
+	return p.nodes[0]
+}
+
+type randomPolicy struct {
+	nodes []*evictNode
+}
+
+func (p *randomPolicy) add(n *evictNode) {
+	n.index = len(p.nodes)
+	p.nodes = append(p.nodes, n)
+}
+
+func (p *randomPolicy) access(n *evictNode) {}
+
+func (p *randomPolicy) remove(n *evictNode) {
+	last := len(p.nodes) - 1
+	p.nodes[n.index] = p.nodes[last]
+	p.nodes[n.index].index = n.index
+	p.nodes[last] = nil
+	p.nodes = p.nodes[:last]
+	n.index = -1
+}
+
+func (p *randomPolicy) victim() *evictNode {
+	if len(p.nodes) == 0 {
+		return nil
+	}
+	return p.nodes[rand.Intn(len(p.nodes))]
+}
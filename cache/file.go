@@ -0,0 +1,750 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//fileCache 数据保存在本地磁盘, 每个key一个文件, 路径为dir/md5前2位/md5第3,4位/md5
+//写入时先写临时文件再改名, 同一进程内的读写是原子的, 多个进程共用目录时Incr等操作不保证原子性
+type fileCache struct {
+	dir           string
+	gccyc         time.Duration
+	defaultExpire time.Duration //数据默认过期时间
+	codec         Codec         //PutObject/GetObject的序列化方式
+	locks         [256]sync.Mutex
+	st            *statsCounter
+	expirations   uint64
+}
+
+//fileEntry 文件中保存的数据
+type fileEntry struct {
+	Key      string
+	Value    []byte
+	IsMap    bool
+	Map      map[string][]byte
+	Deadline int64 //过期时间, UnixNano, 0表示不过期
+}
+
+func (e *fileEntry) isExpire(now time.Time) bool {
+	return e.Deadline > 0 && e.Deadline <= now.UnixNano()
+}
+
+//fileBuckets 第一级目录数, Scan的cursor为第一级目录序号
+const fileBuckets = 256
+
+func NewFileCache() Cache {
+	return &fileCache{codec: JSONCodec, st: newStatsCounter("file")}
+}
+
+type fileConfig struct {
+	Dir           string `json:"dir"`
+	Gccyc         int    `json:"gccyc"`
+	DefaultExpire int    `json:"defaultExpire"`
+	Codec         string `json:"codec"`
+	Compress      string `json:"compress"`
+	CompressLimit int    `json:"compressThreshold"`
+}
+
+//config - {"dir":"/var/cache/app", "gccyc":600, "defaultExpire":0, "codec":"json"}
+//dir - 数据目录, 不存在时自动创建, 必须设置
+//gccyc - 删除过期文件的周期, 秒, 默认：600, 0时不删除, 过期数据在读取时删除
+//defaultExpire - 默认过期时间，秒， 默认：0, 数据将不会过期
+//codec - PutObject的序列化方式, json, gob, msgpack, protobuf或RegisterCodec注册的名称, 默认：json
+//compress - 序列化后的压缩方式, gzip或snappy, 默认：不压缩
+//compressThreshold - 序列化后达到此字节数才压缩, 默认：0
+func (c *fileCache) Init(config string) error {
+	cf := fileConfig{Gccyc: 600}
+	json.Unmarshal([]byte(config), &cf)
+	if cf.Dir == "" {
+		return errors.New("config has no dir key")
+	}
+	if err := os.MkdirAll(cf.Dir, 0755); err != nil {
+		return err
+	}
+	codec, err := NewCodec(cf.Codec, JSONCodec, cf.Compress, cf.CompressLimit)
+	if err != nil {
+		return err
+	}
+	c.dir = cf.Dir
+	c.codec = codec
+	c.gccyc = time.Duration(cf.Gccyc) * time.Second
+	c.defaultExpire = time.Duration(cf.DefaultExpire) * time.Second
+
+	go c.gc()
+
+	return nil
+}
+
+//path 返回key的文件路径及锁
+func (c *fileCache) path(key string) (string, *sync.Mutex) {
+	sum := md5.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[0:2], name[2:4], name), &c.locks[sum[0]]
+}
+
+func (c *fileCache) deadline(expire ...time.Duration) int64 {
+	var timeout time.Duration
+	if len(expire) > 0 {
+		timeout = expire[0]
+	} else if c.defaultExpire > 0 {
+		timeout = c.defaultExpire
+	}
+	if timeout <= 0 {
+		return 0
+	}
+	return time.Now().Add(timeout).UnixNano()
+}
+
+//load 读取文件, 文件不存在或已过期时返回nil, 已过期的文件被删除, 调用时需持有锁
+func (c *fileCache) load(path, key string) (*fileEntry, error) {
+	e, err := readFileEntry(path)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	if e.Key != key {
+		return nil, nil
+	}
+	if e.isExpire(time.Now()) {
+		c.expire(path)
+		return nil, nil
+	}
+	return e, nil
+}
+
+func (c *fileCache) expire(path string) {
+	if os.Remove(path) == nil {
+		atomic.AddUint64(&c.expirations, 1)
+	}
+}
+
+func readFileEntry(path string) (*fileEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	e := new(fileEntry)
+	if err = gob.NewDecoder(bufio.NewReader(f)).Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+//store 写入临时文件后改名, 调用时需持有锁
+func (c *fileCache) store(path string, e *fileEntry) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	w := bufio.NewWriter(f)
+	if err = gob.NewEncoder(w).Encode(e); err == nil {
+		err = w.Flush()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (c *fileCache) remove(path string) (bool, error) {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *fileCache) put(key string, val []byte, expire ...time.Duration) error {
+	path, lock := c.path(key)
+	lock.Lock()
+	defer lock.Unlock()
+	return c.store(path, &fileEntry{Key: key, Value: val, Deadline: c.deadline(expire...)})
+}
+
+//get 读取key的值, key不存在时返回ErrNil
+func (c *fileCache) get(key string) ([]byte, error) {
+	path, lock := c.path(key)
+	lock.Lock()
+	e, err := c.load(path, key)
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, ErrNil
+	}
+	if e.IsMap {
+		return nil, ErrWrongType
+	}
+	return e.Value, nil
+}
+
+func (c *fileCache) Put(key string, val string, expire ...time.Duration) (err error) {
+	defer c.st.write("Put", "", key, c.st.start(), &err)
+	return c.put(key, []byte(val), expire...)
+}
+
+func (c *fileCache) Get(key string) (val string, err error) {
+	defer c.st.read("Get", "", key, c.st.start(), &err)
+	b, err := c.get(key)
+	return string(b), err
+}
+
+func (c *fileCache) GetMulti(keys []string) ([]string, error) {
+	vals, _, err := c.GetMultiHits(keys)
+	return vals, err
+}
+
+func (c *fileCache) GetMultiHits(keys []string) ([]string, []bool, error) {
+	vals := make([]string, len(keys))
+	hits := make([]bool, len(keys))
+	for i, key := range keys {
+		b, err := c.get(key)
+		if err == ErrNil {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		vals[i], hits[i] = string(b), true
+	}
+	c.st.countHits(hits)
+	return vals, hits, nil
+}
+
+func (c *fileCache) PutObject(key string, val interface{}, expire ...time.Duration) (err error) {
+	defer c.st.write("PutObject", "", key, c.st.start(), &err)
+	b, err := c.codec.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.put(key, b, expire...)
+}
+
+func (c *fileCache) GetObject(key string, valptr interface{}) (err error) {
+	defer c.st.read("GetObject", "", key, c.st.start(), &err)
+	b, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(b, valptr)
+}
+
+func (c *fileCache) Delete(key string) (err error) {
+	defer c.st.remove("Delete", "", key, c.st.start(), &err)
+	path, lock := c.path(key)
+	lock.Lock()
+	defer lock.Unlock()
+	_, err = c.remove(path)
+	return err
+}
+
+func (c *fileCache) Incr(key string) error {
+	_, err := c.IncrBy(key, 1)
+	return err
+}
+
+func (c *fileCache) Decr(key string) error {
+	_, err := c.IncrBy(key, -1)
+	return err
+}
+
+//update 在锁内读取并修改key的值, key不存在时以nil调用fn, 过期时间保持不变
+func (c *fileCache) update(key string, fn func(val []byte) ([]byte, error)) error {
+	path, lock := c.path(key)
+	lock.Lock()
+	defer lock.Unlock()
+	e, err := c.load(path, key)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		e = &fileEntry{Key: key}
+	} else if e.IsMap {
+		return ErrWrongType
+	}
+	if e.Value, err = fn(e.Value); err != nil {
+		return err
+	}
+	return c.store(path, e)
+}
+
+func (c *fileCache) IncrBy(key string, delta int64) (n int64, err error) {
+	err = c.update(key, func(val []byte) ([]byte, error) {
+		n, err = IncrBytes(val, delta)
+		return []byte(strconv.FormatInt(n, 10)), err
+	})
+	return
+}
+
+func (c *fileCache) IncrByFloat(key string, delta float64) (f float64, err error) {
+	err = c.update(key, func(val []byte) ([]byte, error) {
+		f, err = IncrFloatBytes(val, delta)
+		return []byte(strconv.FormatFloat(f, 'f', -1, 64)), err
+	})
+	return
+}
+
+func (c *fileCache) Exists(key string) bool {
+	path, lock := c.path(key)
+	lock.Lock()
+	defer lock.Unlock()
+	e, _ := c.load(path, key)
+	return e != nil
+}
+
+func (c *fileCache) TTL(key string) (time.Duration, error) {
+	path, lock := c.path(key)
+	lock.Lock()
+	defer lock.Unlock()
+	e, err := c.load(path, key)
+	if err != nil {
+		return 0, err
+	}
+	if e == nil {
+		return 0, ErrNil
+	}
+	if e.Deadline == 0 {
+		return 0, nil
+	}
+	if ttl := time.Duration(e.Deadline - time.Now().UnixNano()); ttl > 0 {
+		return ttl, nil
+	}
+	return 0, ErrNil
+}
+
+//SetExpire 重新设置过期时间, key不存在时忽略
+func (c *fileCache) SetExpire(key string, expire ...time.Duration) error {
+	if len(expire) == 0 {
+		return nil
+	}
+	path, lock := c.path(key)
+	lock.Lock()
+	defer lock.Unlock()
+	e, err := c.load(path, key)
+	if err != nil || e == nil {
+		return err
+	}
+	e.Deadline = c.deadline(expire...)
+	return c.store(path, e)
+}
+
+//NewMap Map在第一次写入时创建, 并设置过期时间
+func (c *fileCache) NewMap(name string, expire ...time.Duration) (Map, error) {
+	return &fileMap{c: c, name: name, expire: expire}, nil
+}
+
+//walk 依次读取第一级目录bucket中未过期的数据
+func (c *fileCache) walk(bucket int, fn func(path string, e *fileEntry) error) error {
+	root := filepath.Join(c.dir, hex.EncodeToString([]byte{byte(bucket)}))
+	now := time.Now()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		e, err := readFileEntry(path)
+		if err != nil || e == nil {
+			return nil
+		}
+		if e.isExpire(now) {
+			c.removeExpired(path, &c.locks[bucket])
+			return nil
+		}
+		return fn(path, e)
+	})
+	return err
+}
+
+func (c *fileCache) Keys(pattern string) ([]string, error) {
+	var keys []string
+	for cursor := uint64(0); ; {
+		next, batch, err := c.Scan(cursor, pattern, fileBuckets)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if cursor = next; cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+//Scan cursor为第一级目录序号, 遍历目录直到返回的key达到count
+func (c *fileCache) Scan(cursor uint64, pattern string, count int) (uint64, []string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	if count <= 0 {
+		count = 10
+	}
+	var keys []string
+	for ; cursor < fileBuckets && len(keys) < count; cursor++ {
+		err := c.walk(int(cursor), func(path string, e *fileEntry) error {
+			if matchPattern(pattern, e.Key) {
+				keys = append(keys, e.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if cursor >= fileBuckets {
+		cursor = 0
+	}
+	return cursor, keys, nil
+}
+
+func (c *fileCache) DeleteByPrefix(prefix string) (int, error) {
+	n := 0
+	for i := 0; i < fileBuckets; i++ {
+		err := c.walk(i, func(path string, e *fileEntry) error {
+			if !strings.HasPrefix(e.Key, prefix) {
+				return nil
+			}
+			lock := &c.locks[i]
+			lock.Lock()
+			ok, err := c.remove(path)
+			lock.Unlock()
+			if ok {
+				n++
+			}
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+	atomic.AddUint64(&c.st.deletes, uint64(n))
+	return n, nil
+}
+
+func (c *fileCache) SetObserver(o Observer) {
+	c.st.setObserver(o)
+}
+
+//Stats Items和Bytes需要遍历数据目录
+func (c *fileCache) Stats() (Stats, error) {
+	st := c.st.stats()
+	for i := 0; i < fileBuckets; i++ {
+		err := c.walk(i, func(path string, e *fileEntry) error {
+			st.Items++
+			st.Bytes += int64(len(e.Key) + len(e.Value))
+			for key, val := range e.Map {
+				st.Bytes += int64(len(key) + len(val))
+			}
+			return nil
+		})
+		if err != nil {
+			return st, err
+		}
+	}
+	st.Expirations = atomic.LoadUint64(&c.expirations)
+	return st, nil
+}
+
+//removeExpired 在锁内确认已过期后删除
+func (c *fileCache) removeExpired(path string, lock *sync.Mutex) {
+	lock.Lock()
+	defer lock.Unlock()
+	if e, err := readFileEntry(path); err == nil && e != nil && e.isExpire(time.Now()) {
+		c.expire(path)
+	}
+}
+
+//gc 删除过期文件及写入中断时残留的临时文件
+func (c *fileCache) gc() {
+	if (c.gccyc / time.Second) < 1 {
+		return
+	}
+	for {
+		<-time.After(c.gccyc)
+		deadline := time.Now().Add(-c.gccyc)
+		filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasPrefix(info.Name(), ".tmp") && info.ModTime().Before(deadline) {
+				os.Remove(path)
+			}
+			return nil
+		})
+		for i := 0; i < fileBuckets; i++ {
+			c.walk(i, func(path string, e *fileEntry) error { return nil })
+		}
+	}
+}
+
+//fileMap Map的所有数据保存在一个文件中, 每次写入重写整个文件
+type fileMap struct {
+	c      *fileCache
+	name   string
+	expire []time.Duration
+}
+
+//view 读取Map数据, Map不存在时返回nil
+func (m *fileMap) view() (map[string][]byte, error) {
+	path, lock := m.c.path(m.name)
+	lock.Lock()
+	defer lock.Unlock()
+	e, err := m.c.load(path, m.name)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	if !e.IsMap {
+		return nil, ErrWrongType
+	}
+	return e.Map, nil
+}
+
+//update 在锁内修改Map数据, Map不存在时创建, 修改后为空时删除
+func (m *fileMap) update(fn func(data map[string][]byte) error) error {
+	path, lock := m.c.path(m.name)
+	lock.Lock()
+	defer lock.Unlock()
+	e, err := m.c.load(path, m.name)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		e = &fileEntry{Key: m.name, IsMap: true, Deadline: m.c.deadline(m.expire...)}
+	} else if !e.IsMap {
+		return ErrWrongType
+	}
+	if e.Map == nil {
+		e.Map = make(map[string][]byte)
+	}
+	if err = fn(e.Map); err != nil {
+		return err
+	}
+	if len(e.Map) == 0 {
+		_, err = m.c.remove(path)
+		return err
+	}
+	return m.c.store(path, e)
+}
+
+func (m *fileMap) get(key string) ([]byte, error) {
+	data, err := m.view()
+	if err != nil {
+		return nil, err
+	}
+	val, ok := data[key]
+	if !ok {
+		return nil, ErrNil
+	}
+	return val, nil
+}
+
+func (m *fileMap) put(key string, val []byte) error {
+	return m.update(func(data map[string][]byte) error {
+		data[key] = val
+		return nil
+	})
+}
+
+func (m *fileMap) Put(key string, val string) (err error) {
+	defer m.c.st.write("Put", m.name, key, m.c.st.start(), &err)
+	return m.put(key, []byte(val))
+}
+
+func (m *fileMap) Get(key string) (val string, err error) {
+	defer m.c.st.read("Get", m.name, key, m.c.st.start(), &err)
+	b, err := m.get(key)
+	return string(b), err
+}
+
+func (m *fileMap) GetMulti(keys []string) ([]string, error) {
+	vals, _, err := m.GetMultiHits(keys)
+	return vals, err
+}
+
+func (m *fileMap) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	return DecodeObjects(m, m.c.codec, keys, valptr)
+}
+
+func (m *fileMap) GetMultiHits(keys []string) ([]string, []bool, error) {
+	data, err := m.view()
+	if err != nil {
+		return nil, nil, err
+	}
+	vals := make([]string, len(keys))
+	hits := make([]bool, len(keys))
+	for i, key := range keys {
+		if val, ok := data[key]; ok {
+			vals[i], hits[i] = string(val), true
+		}
+	}
+	m.c.st.countHits(hits)
+	return vals, hits, nil
+}
+
+func (m *fileMap) PutObject(key string, val interface{}) (err error) {
+	defer m.c.st.write("PutObject", m.name, key, m.c.st.start(), &err)
+	b, err := m.c.codec.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return m.put(key, b)
+}
+
+func (m *fileMap) GetObject(key string, valptr interface{}) (err error) {
+	defer m.c.st.read("GetObject", m.name, key, m.c.st.start(), &err)
+	b, err := m.get(key)
+	if err != nil {
+		return err
+	}
+	return m.c.codec.Unmarshal(b, valptr)
+}
+
+func (m *fileMap) Delete(key string) (err error) {
+	defer m.c.st.remove("Delete", m.name, key, m.c.st.start(), &err)
+	return m.DeleteMulti([]string{key})
+}
+
+func (m *fileMap) Incr(key string) error {
+	_, err := m.IncrBy(key, 1)
+	return err
+}
+
+func (m *fileMap) Decr(key string) error {
+	_, err := m.IncrBy(key, -1)
+	return err
+}
+
+func (m *fileMap) IncrBy(key string, delta int64) (n int64, err error) {
+	err = m.update(func(data map[string][]byte) error {
+		if n, err = IncrBytes(data[key], delta); err != nil {
+			return err
+		}
+		data[key] = []byte(strconv.FormatInt(n, 10))
+		return nil
+	})
+	return
+}
+
+func (m *fileMap) IncrByFloat(key string, delta float64) (f float64, err error) {
+	err = m.update(func(data map[string][]byte) error {
+		if f, err = IncrFloatBytes(data[key], delta); err != nil {
+			return err
+		}
+		data[key] = []byte(strconv.FormatFloat(f, 'f', -1, 64))
+		return nil
+	})
+	return
+}
+
+func (m *fileMap) Exists(key string) bool {
+	data, _ := m.view()
+	_, ok := data[key]
+	return ok
+}
+
+func (m *fileMap) Size() (int, error) {
+	data, err := m.view()
+	return len(data), err
+}
+
+func (m *fileMap) Clear() error {
+	path, lock := m.c.path(m.name)
+	lock.Lock()
+	defer lock.Unlock()
+	_, err := m.c.remove(path)
+	return err
+}
+
+func (m *fileMap) PutMulti(vals map[string]string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	return m.update(func(data map[string][]byte) error {
+		for key, val := range vals {
+			data[key] = []byte(val)
+		}
+		return nil
+	})
+}
+
+func (m *fileMap) GetAll() (map[string]string, error) {
+	data, err := m.view()
+	if err != nil {
+		return nil, err
+	}
+	vals := make(map[string]string, len(data))
+	for key, val := range data {
+		vals[key] = string(val)
+	}
+	return vals, nil
+}
+
+func (m *fileMap) Keys() ([]string, error) {
+	data, err := m.view()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+//Range 遍历读取时的数据, 不持有锁, fn中可以修改Map
+func (m *fileMap) Range(fn func(key, val string) bool) error {
+	data, err := m.view()
+	if err != nil {
+		return err
+	}
+	for key, val := range data {
+		if !fn(key, string(val)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *fileMap) DeleteMulti(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return m.update(func(data map[string][]byte) error {
+		for _, key := range keys {
+			delete(data, key)
+		}
+		return nil
+	})
+}
+
+func init() {
+	Register("file", NewFileCache)
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tryor/commons/redis"
+)
+
+//Invalidation 失效通知, 多级缓存写入数据后通知其它节点删除本地缓存
+type Invalidation struct {
+	Source string   `json:"source"`           //发送节点ID, 节点忽略自己发出的通知
+	Map    string   `json:"map,omitempty"`    //不为空时Keys为Map中的key, Keys为空时清空整个Map
+	Keys   []string `json:"keys,omitempty"`   //失效的key
+	Prefix string   `json:"prefix,omitempty"` //不为空时删除以Prefix开头的key
+}
+
+//InvalidationTransport 失效通知的传输方式
+type InvalidationTransport interface {
+	Publish(inv *Invalidation) error
+	//订阅通知, 返回取消订阅函数
+	Subscribe(handler func(inv *Invalidation)) (cancel func(), err error)
+}
+
+//EnableInvalidation 为多级缓存c启用失效通知, c必须由NewL2Cache, NewL3Cache或NewLNCache创建
+//c的写操作将通过t发布失效通知, 收到其它节点的通知时删除除最后一级外各级缓存中的数据
+//需在使用c之前调用
+func EnableInvalidation(c Cache, t InvalidationTransport) (cancel func(), err error) {
+	lc, ok := c.(*l2Cache)
+	if !ok {
+		return nil, errors.New("cache: invalidation requires a multi-level cache")
+	}
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	inv := &invalidator{id: id, t: t}
+	unsubscribe, err := t.Subscribe(func(msg *Invalidation) {
+		if msg.Source != inv.id {
+			lc.evictLocal(msg)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	lc.inv = inv
+	return func() {
+		atomic.StoreInt32(&inv.stopped, 1)
+		unsubscribe()
+	}, nil
+}
+
+type invalidator struct {
+	id      string
+	t       InvalidationTransport
+	stopped int32
+}
+
+func (inv *invalidator) publish(mapName string, keys ...string) error {
+	return inv.send(&Invalidation{Source: inv.id, Map: mapName, Keys: keys})
+}
+
+func (inv *invalidator) send(msg *Invalidation) error {
+	if atomic.LoadInt32(&inv.stopped) > 0 {
+		return nil
+	}
+	return inv.t.Publish(msg)
+}
+
+//mapLookup 查找已存在的Map, 不会创建Map或改变其过期时间
+type mapLookup interface {
+	lookupMap(name string) Map
+}
+
+func (c *memoryCache) lookupMap(name string) Map {
+	if itm, ok := c.lget(name).(*memoryEntry); ok {
+		if m, ok := itm.value.(*memoryMap); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+//evictLocal 删除除最后一级外各级缓存中的数据
+func (lc *l2Cache) evictLocal(msg *Invalidation) {
+	for c := Cache(lc); ; {
+		l, ok := c.(*l2Cache)
+		if !ok {
+			return
+		}
+		evictFrom(l.c1, msg)
+		c = l.c2
+	}
+}
+
+func evictFrom(c Cache, msg *Invalidation) {
+	if msg.Prefix != "" {
+		c.DeleteByPrefix(msg.Prefix)
+		return
+	}
+	if msg.Map == "" {
+		for _, key := range msg.Keys {
+			c.Delete(key)
+		}
+		return
+	}
+
+	ml, ok := c.(mapLookup)
+	if !ok {
+		c.Delete(msg.Map)
+		return
+	}
+	m := ml.lookupMap(msg.Map)
+	if m == nil {
+		return
+	}
+	if len(msg.Keys) == 0 {
+		m.Clear()
+		return
+	}
+	for _, key := range msg.Keys {
+		m.Delete(key)
+	}
+}
+
+//NewMemoryTransport 进程内的失效通知, 用于测试或同一进程中的多个多级缓存
+func NewMemoryTransport() InvalidationTransport {
+	return &memoryTransport{handlers: make(map[int]func(*Invalidation))}
+}
+
+type memoryTransport struct {
+	lock     sync.RWMutex
+	seq      int
+	handlers map[int]func(*Invalidation)
+}
+
+func (t *memoryTransport) Publish(inv *Invalidation) error {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	for _, h := range t.handlers {
+		h(inv)
+	}
+	return nil
+}
+
+func (t *memoryTransport) Subscribe(handler func(inv *Invalidation)) (func(), error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.seq++
+	id := t.seq
+	t.handlers[id] = handler
+	return func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		delete(t.handlers, id)
+	}, nil
+}
+
+//NewRedisTransport 通过redis发布/订阅传输失效通知, c必须是redis适配器
+//连接断开后将自动重连, 断开期间的通知会丢失
+func NewRedisTransport(c Cache, channel string) (InvalidationTransport, error) {
+	rc, ok := c.(*redisCache)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return &redisTransport{rc: rc, channel: channel}, nil
+}
+
+type redisTransport struct {
+	rc      *redisCache
+	channel string
+}
+
+func (t *redisTransport) Publish(inv *Invalidation) error {
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return t.rc.send("PUBLISH", t.channel, b)
+}
+
+//Subscribe 使用redis.Subscriber接收通知, 连接断开或心跳超时后按退避时间重新连接
+func (t *redisTransport) Subscribe(handler func(inv *Invalidation)) (func(), error) {
+	s := redis.NewClientPool(t.rc.p).NewSubscriber(func(m *redis.Message) {
+		var inv Invalidation
+		if err := json.Unmarshal(m.Data, &inv); err == nil {
+			handler(&inv)
+		}
+	})
+	if err := s.Subscribe(t.channel); err != nil {
+		return nil, err
+	}
+	go s.Run(context.Background())
+	return func() { s.Close() }, nil
+}
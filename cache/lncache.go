@@ -1,24 +1,42 @@
 package cache
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"time"
 )
 
 //multi-level cache
 
+//l2Cache write policies
+const (
+	WriteThrough = iota //同时写入c1和c2, 默认
+	WriteAround         //只写入c2, 并删除c1中的旧数据
+	WriteL1Only         //只写入c1
+)
+
+type L2Options struct {
+	//从c2读取的数据回填到c1时的最长过期时间, 回填的数据不晚于c2中的数据过期
+	//0时不限制, c2中的数据不过期或c2不支持TTL时使用c1的默认过期时间
+	PromoteTTL time.Duration
+	//写策略, WriteThrough, WriteAround 或 WriteL1Only
+	WritePolicy int
+}
+
 func NewLNCache(cs ...Cache) Cache {
+	return NewLNCacheWithOptions(L2Options{}, cs...)
+}
+
+//NewLNCacheWithOptions 各级缓存使用相同的选项
+func NewLNCacheWithOptions(opts L2Options, cs ...Cache) Cache {
 	switch len(cs) {
 	case 0:
 		return nil
 	case 1:
 		return cs[0]
-	case 2:
-		return NewL2Cache(cs[0], cs[1])
 	default:
-		i := len(cs) - 1
-		c := NewL2Cache(cs[i-1], cs[i])
-		return NewLNCache(append(cs[0:i-1], c)...)
+		return NewL2CacheWithOptions(cs[0], NewLNCacheWithOptions(opts, cs[1:]...), opts)
 	}
 }
 
@@ -27,41 +45,99 @@ func NewL3Cache(c1 Cache, c2 Cache, c3 Cache) Cache {
 }
 
 func NewL2Cache(c1 Cache, c2 Cache) Cache {
-	return &l2Cache{c1: c1, c2: c2}
+	return NewL2CacheWithOptions(c1, c2, L2Options{})
+}
+
+func NewL2CacheWithOptions(c1 Cache, c2 Cache, opts L2Options) Cache {
+	return &l2Cache{c1: c1, c2: c2, opts: opts, st: newStatsCounter("l2")}
 }
 
 type l2Cache struct {
-	c1 Cache
-	c2 Cache
+	c1   Cache
+	c2   Cache
+	opts L2Options
+	inv  *invalidator //失效通知, 见EnableInvalidation
+	st   *statsCounter
+}
+
+//invalidate 通知其它节点删除本地缓存
+func (lc *l2Cache) invalidate(mapName string, keys ...string) error {
+	if lc.inv == nil {
+		return nil
+	}
+	return lc.inv.publish(mapName, keys...)
 }
 
 func (lc *l2Cache) Error(err1, err2 error) error {
 	if err1 == nil {
 		return err2
 	}
-	if err2 == nil {
+	if err2 == nil || err2 == err1 {
 		return err1
 	}
 	return errors.New(err1.Error() + ", " + err2.Error())
 }
 
-func (lc *l2Cache) Put(key string, val string, expire ...time.Duration) error {
-	var err1, err2 error
-	switch len(expire) {
-	case 0:
-		err1 = lc.c1.Put(key, val)
-		err2 = lc.c2.Put(key, val)
-	case 1:
-		err1 = lc.c1.Put(key, val, expire[0])
-		err2 = lc.c2.Put(key, val)
+//expire1, expire2 分别取c1, c2的过期时间参数, c2为多级缓存时依次向下传递
+func expire1(expire []time.Duration) []time.Duration {
+	if len(expire) > 0 {
+		return expire[:1]
+	}
+	return nil
+}
+
+func expire2(expire []time.Duration) []time.Duration {
+	if len(expire) > 1 {
+		return expire[1:]
+	}
+	return nil
+}
+
+//promoteExpire 从c2回填c1时的过期时间, 取c2中的剩余过期时间, PromoteTTL不为0时不超过PromoteTTL
+//c2中不过期或不支持TTL时使用PromoteTTL, 均为0时使用c1的默认过期时间
+func (lc *l2Cache) promoteExpire(key string) []time.Duration {
+	ttl, err := TTL(lc.c2, key)
+	if err != nil || ttl <= 0 || (lc.opts.PromoteTTL > 0 && ttl > lc.opts.PromoteTTL) {
+		ttl = lc.opts.PromoteTTL
+	}
+	if ttl > 0 {
+		return []time.Duration{ttl}
+	}
+	return nil
+}
+
+//TTL 返回c1中的剩余过期时间, c1中不存在时返回c2中的
+func (lc *l2Cache) TTL(key string) (time.Duration, error) {
+	ttl, err := TTL(lc.c1, key)
+	if err == nil {
+		return ttl, nil
+	}
+	return TTL(lc.c2, key)
+}
+
+//write 按写策略写入, w1写入c1, w2写入c2, del1删除c1中的旧数据
+func (lc *l2Cache) write(w1, w2, del1 func() error) error {
+	switch lc.opts.WritePolicy {
+	case WriteAround:
+		return lc.Error(w2(), del1())
+	case WriteL1Only:
+		return w1()
 	default:
-		err1 = lc.c1.Put(key, val, expire[0])
-		err2 = lc.c2.Put(key, val, expire[1])
+		return lc.Error(w1(), w2())
 	}
-	return lc.Error(err1, err2)
 }
 
-func (lc *l2Cache) Get(key string) (string, error) {
+func (lc *l2Cache) Put(key string, val string, expire ...time.Duration) (err error) {
+	defer lc.st.write("Put", "", key, lc.st.start(), &err)
+	err = lc.write(
+		func() error { return lc.c1.Put(key, val, expire1(expire)...) },
+		func() error { return lc.c2.Put(key, val, expire2(expire)...) },
+		func() error { return lc.c1.Delete(key) })
+	return lc.Error(err, lc.invalidate("", key))
+}
+
+func (lc *l2Cache) Get(key string) (str string, err error) {
+	defer lc.st.read("Get", "", key, lc.st.start(), &err)
 
 	v, err := lc.c1.Get(key)
 	if err == nil {
@@ -70,7 +146,7 @@ func (lc *l2Cache) Get(key string) (string, error) {
 
 	v, err = lc.c2.Get(key)
 	if err == nil {
-		lc.c1.Put(key, v)
+		lc.c1.Put(key, v, lc.promoteExpire(key)...)
 		return v, nil
 	}
 
@@ -79,74 +155,183 @@ func (lc *l2Cache) Get(key string) (string, error) {
 }
 
 func (lc *l2Cache) GetMulti(keys []string) ([]string, error) {
-	vs, err := lc.c1.GetMulti(keys)
-	if err == nil {
-		nullNums := 0
-		for i := 0; i < len(vs); i++ {
-			if vs[i] == "" {
-				nullNums++
-			}
-		}
-		if nullNums < len(vs) {
-			return vs, nil
+	vs, _, err := lc.GetMultiHits(keys)
+	return vs, err
+}
+
+//GetMultiHits 先从c1获取, 只从c2获取c1中不存在的key
+func (lc *l2Cache) GetMultiHits(keys []string) ([]string, []bool, error) {
+	vs, hits, err := GetMultiHits(lc.c1, keys)
+	if err != nil {
+		vs, hits = make([]string, len(keys)), make([]bool, len(keys))
+	}
+	missing, idx := missingKeys(keys, hits)
+	if len(missing) == 0 {
+		lc.st.countHits(hits)
+		return vs, hits, nil
+	}
+
+	vs2, hits2, err := GetMultiHits(lc.c2, missing)
+	if err != nil {
+		return nil, nil, err
+	}
+	for j, i := range idx {
+		if hits2[j] {
+			vs[i], hits[i] = vs2[j], true
+			lc.c1.Put(keys[i], vs2[j], lc.promoteExpire(keys[i])...)
 		}
 	}
-	vs, err = lc.c2.GetMulti(keys)
-	if err == nil {
-		for i, k := range keys {
-			lc.c1.Put(k, vs[i])
+	lc.st.countHits(hits)
+	return vs, hits, nil
+}
+
+//GetObjects 先从c1获取, 只从c2获取c1中不存在的key, 同GetMultiHits
+func (lc *l2Cache) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	hits, err := GetObjects(lc.c1, keys, valptr)
+	if err != nil {
+		hits = make([]bool, len(keys))
+	}
+	missing, idx := missingKeys(keys, hits)
+	if len(missing) == 0 {
+		lc.st.countHits(hits)
+		return hits, nil
+	}
+
+	hits2, err := GetObjects(lc.c2, missing, func(j int) interface{} { return valptr(idx[j]) })
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range idx {
+		if hits2[j] {
+			hits[i] = true
+			lc.c1.PutObject(keys[i], elemCopy(valptr(i)), lc.promoteExpire(keys[i])...)
 		}
-		return vs, nil
 	}
-	return nil, err
+	lc.st.countHits(hits)
+	return hits, nil
 }
 
-func (lc *l2Cache) PutObject(key string, val interface{}, expire ...time.Duration) error {
-	var err1, err2 error
-	switch len(expire) {
-	case 0:
-		err1 = lc.c1.PutObject(key, val)
-		err2 = lc.c2.PutObject(key, val)
-	case 1:
-		err1 = lc.c1.PutObject(key, val, expire[0])
-		err2 = lc.c2.PutObject(key, val)
-	default:
-		err1 = lc.c1.PutObject(key, val, expire[0])
-		err2 = lc.c2.PutObject(key, val, expire[1])
+//missingKeys 返回hits中不存在的key及其序号
+func missingKeys(keys []string, hits []bool) (missing []string, idx []int) {
+	for i, hit := range hits {
+		if !hit {
+			missing = append(missing, keys[i])
+			idx = append(idx, i)
+		}
 	}
-	return lc.Error(err1, err2)
+	return missing, idx
+}
+
+func (lc *l2Cache) PutObject(key string, val interface{}, expire ...time.Duration) (err error) {
+	defer lc.st.write("PutObject", "", key, lc.st.start(), &err)
+	err = lc.write(
+		func() error { return lc.c1.PutObject(key, val, expire1(expire)...) },
+		func() error { return lc.c2.PutObject(key, val, expire2(expire)...) },
+		func() error { return lc.c1.Delete(key) })
+	return lc.Error(err, lc.invalidate("", key))
 }
 
-func (lc *l2Cache) GetObject(key string, valptr interface{}) error {
+func (lc *l2Cache) GetObject(key string, valptr interface{}) (err error) {
+	defer lc.st.read("GetObject", "", key, lc.st.start(), &err)
 
-	err := lc.c1.GetObject(key, valptr)
+	err = lc.c1.GetObject(key, valptr)
 	if err == nil {
 		return nil
 	}
 
 	err = lc.c2.GetObject(key, valptr)
 	if err == nil {
-		lc.c1.PutObject(key, valptr)
+		lc.c1.PutObject(key, elemCopy(valptr), lc.promoteExpire(key)...)
 		return nil
 	}
 
 	return err
 }
 
-func (lc *l2Cache) Delete(key string) error {
+//elemCopy 回填c1时保存对象副本, 避免c1引用调用者的变量
+func elemCopy(valptr interface{}) interface{} {
+	v := reflect.ValueOf(valptr)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return v.Elem().Interface()
+	}
+	return valptr
+}
+
+//PutIfAbsent 以c2为准进行判断, 写入成功后按写策略同步到c1
+func (lc *l2Cache) PutIfAbsent(key string, val string, expire ...time.Duration) (bool, error) {
+	if lc.opts.WritePolicy == WriteL1Only {
+		return PutIfAbsent(lc.c1, key, val, expire1(expire)...)
+	}
+	ok, err := PutIfAbsent(lc.c2, key, val, expire2(expire)...)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, lc.Error(lc.sync1(key, val, expire), lc.invalidate("", key))
+}
+
+func (lc *l2Cache) CompareAndSwap(key string, old, new string, expire ...time.Duration) (bool, error) {
+	if lc.opts.WritePolicy == WriteL1Only {
+		return CompareAndSwap(lc.c1, key, old, new, expire1(expire)...)
+	}
+	ok, err := CompareAndSwap(lc.c2, key, old, new, expire2(expire)...)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, lc.Error(lc.sync1(key, new, expire), lc.invalidate("", key))
+}
+
+//sync1 c2写入成功后更新c1
+func (lc *l2Cache) sync1(key string, val string, expire []time.Duration) error {
+	if lc.opts.WritePolicy == WriteAround {
+		return lc.c1.Delete(key)
+	}
+	return lc.c1.Put(key, val, expire1(expire)...)
+}
+
+func (lc *l2Cache) GetAndDelete(key string) (string, error) {
+	if lc.opts.WritePolicy == WriteL1Only {
+		return GetAndDelete(lc.c1, key)
+	}
+	v, err := GetAndDelete(lc.c2, key)
+	if err == ErrNotSupported {
+		return v, err
+	}
+	return v, lc.Error(lc.Error(err, lc.c1.Delete(key)), lc.invalidate("", key))
+}
+
+func (lc *l2Cache) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	return Eval(lc.c2, script, keys, args...)
+}
+
+func (lc *l2Cache) Delete(key string) (err error) {
+	defer lc.st.remove("Delete", "", key, lc.st.start(), &err)
 	err1 := lc.c1.Delete(key)
 	err2 := lc.c2.Delete(key)
-	return lc.Error(err1, err2)
+	return lc.Error(lc.Error(err1, err2), lc.invalidate("", key))
 }
 func (lc *l2Cache) Incr(key string) error {
-	err1 := lc.c1.Incr(key)
-	err2 := lc.c2.Incr(key)
-	return lc.Error(err1, err2)
+	_, err := lc.IncrBy(key, 1)
+	return err
 }
 func (lc *l2Cache) Decr(key string) error {
-	err1 := lc.c1.Decr(key)
-	err2 := lc.c2.Decr(key)
-	return lc.Error(err1, err2)
+	_, err := lc.IncrBy(key, -1)
+	return err
+}
+
+//IncrBy 以c2中的计数为准, c1中的旧值将被删除
+func (lc *l2Cache) IncrBy(key string, delta int64) (int64, error) {
+	if lc.opts.WritePolicy == WriteL1Only {
+		return lc.c1.IncrBy(key, delta)
+	}
+	n, err := lc.c2.IncrBy(key, delta)
+	return n, lc.Error(lc.Error(err, lc.c1.Delete(key)), lc.invalidate("", key))
+}
+func (lc *l2Cache) IncrByFloat(key string, delta float64) (float64, error) {
+	if lc.opts.WritePolicy == WriteL1Only {
+		return lc.c1.IncrByFloat(key, delta)
+	}
+	n, err := lc.c2.IncrByFloat(key, delta)
+	return n, lc.Error(lc.Error(err, lc.c1.Delete(key)), lc.invalidate("", key))
 }
 func (lc *l2Cache) Exists(key string) bool {
 	if lc.c1.Exists(key) {
@@ -169,36 +354,169 @@ func (lc *l2Cache) SetExpire(key string, expire ...time.Duration) error {
 	return lc.Error(err1, err2)
 }
 
+//last 返回数据完整的一级, 除WriteL1Only外为c2
+func (lc *l2Cache) last() Cache {
+	if lc.opts.WritePolicy == WriteL1Only {
+		return lc.c1
+	}
+	return lc.c2
+}
+
+func (lc *l2Cache) Keys(pattern string) ([]string, error) {
+	return lc.last().Keys(pattern)
+}
+
+func (lc *l2Cache) Scan(cursor uint64, pattern string, count int) (uint64, []string, error) {
+	return lc.last().Scan(cursor, pattern, count)
+}
+
+//DeleteByPrefix 删除各级缓存中的数据, 返回数据完整的一级中删除的数量
+func (lc *l2Cache) DeleteByPrefix(prefix string) (int, error) {
+	n1, err1 := lc.c1.DeleteByPrefix(prefix)
+	n2, err2 := lc.c2.DeleteByPrefix(prefix)
+	n := n2
+	if lc.opts.WritePolicy == WriteL1Only {
+		n = n1
+	}
+	var err3 error
+	if lc.inv != nil {
+		err3 = lc.inv.send(&Invalidation{Source: lc.inv.id, Prefix: prefix})
+	}
+	return n, lc.Error(lc.Error(err1, err2), err3)
+}
+
+//levels 返回各级缓存, c2为多级缓存时展开
+func (lc *l2Cache) levels() []Cache {
+	if l, ok := lc.c2.(*l2Cache); ok {
+		return append([]Cache{lc.c1}, l.levels()...)
+	}
+	return []Cache{lc.c1, lc.c2}
+}
+
+//lastLevel 返回数据完整的一级, c2为多级缓存时继续向下查找
+func (lc *l2Cache) lastLevel() Cache {
+	if l, ok := lc.last().(*l2Cache); ok {
+		return l.lastLevel()
+	}
+	return lc.last()
+}
+
+func (lc *l2Cache) SetObserver(o Observer) {
+	lc.st.setObserver(o)
+	for i, c := range lc.levels() {
+		if o == nil {
+			SetObserver(c, nil)
+		} else {
+			SetObserver(c, &levelObserver{o: o, level: i + 1})
+		}
+	}
+}
+
+//Stats Hits, Misses, Puts, Deletes为多级缓存本身的统计数据, Levels为各级的统计数据
+//Evictions, Expirations为各级之和, Items, Bytes取自数据完整的一级
+func (lc *l2Cache) Stats() (Stats, error) {
+	st := lc.st.stats()
+	last := lc.lastLevel()
+	var err error
+	for _, c := range lc.levels() {
+		ls, err1 := GetStats(c)
+		if err1 != nil && err1 != ErrNotSupported {
+			err = lc.Error(err, err1)
+		}
+		st.Evictions += ls.Evictions
+		st.Expirations += ls.Expirations
+		if c == last {
+			st.Items, st.Bytes = ls.Items, ls.Bytes
+		}
+		st.Levels = append(st.Levels, ls)
+	}
+	return st, err
+}
+
+//withCtx 返回绑定ctx的l2Cache, 各级缓存通过context版本读写
+func (lc *l2Cache) withCtx(ctx context.Context) *l2Cache {
+	return &l2Cache{c1: bindCache(ctx, lc.c1), c2: bindCache(ctx, lc.c2), opts: lc.opts, inv: lc.inv, st: lc.st}
+}
+
+func (lc *l2Cache) PutCtx(ctx context.Context, key string, val string, expire ...time.Duration) error {
+	return lc.withCtx(ctx).Put(key, val, expire...)
+}
+func (lc *l2Cache) GetCtx(ctx context.Context, key string) (string, error) {
+	return lc.withCtx(ctx).Get(key)
+}
+func (lc *l2Cache) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
+	return lc.withCtx(ctx).GetMulti(keys)
+}
+func (lc *l2Cache) getMultiHitsCtx(ctx context.Context, keys []string) ([]string, []bool, error) {
+	return lc.withCtx(ctx).GetMultiHits(keys)
+}
+func (lc *l2Cache) PutObjectCtx(ctx context.Context, key string, val interface{}, expire ...time.Duration) error {
+	return lc.withCtx(ctx).PutObject(key, val, expire...)
+}
+func (lc *l2Cache) GetObjectCtx(ctx context.Context, key string, valptr interface{}) error {
+	return lc.withCtx(ctx).GetObject(key, valptr)
+}
+func (lc *l2Cache) DeleteCtx(ctx context.Context, key string) error {
+	return lc.withCtx(ctx).Delete(key)
+}
+func (lc *l2Cache) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	return lc.withCtx(ctx).IncrBy(key, delta)
+}
+func (lc *l2Cache) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	return lc.withCtx(ctx).IncrByFloat(key, delta)
+}
+func (lc *l2Cache) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	ok, err := ContextOf(lc.c1).ExistsCtx(ctx, key)
+	if err != nil || ok {
+		return ok, err
+	}
+	return ContextOf(lc.c2).ExistsCtx(ctx, key)
+}
+func (lc *l2Cache) SetExpireCtx(ctx context.Context, key string, expire ...time.Duration) error {
+	return lc.withCtx(ctx).SetExpire(key, expire...)
+}
+
 func (lc *l2Cache) NewMap(name string, expire ...time.Duration) (Map, error) {
-	m := &l2CacheMap{}
+	m := &l2CacheMap{lc: lc, name: name}
 	var err1, err2 error
-	switch len(expire) {
-	case 0:
-		m.m1, err1 = lc.c1.NewMap(name)
-		m.m2, err2 = lc.c2.NewMap(name)
-	case 1:
-		m.m1, err1 = lc.c1.NewMap(name, expire[0])
-		m.m2, err2 = lc.c2.NewMap(name)
-	default:
-		m.m1, err1 = lc.c1.NewMap(name, expire[0])
-		m.m2, err2 = lc.c2.NewMap(name, expire[1:]...)
-	}
+	m.m1, err1 = lc.c1.NewMap(name, expire1(expire)...)
+	m.m2, err2 = lc.c2.NewMap(name, expire2(expire)...)
 	return m, lc.Error(err1, err2)
 }
 
 type l2CacheMap struct {
-	lc *l2Cache
-	m1 Map
-	m2 Map
+	lc   *l2Cache
+	name string
+	m1   Map
+	m2   Map
+}
+
+func (m *l2CacheMap) write(w1, w2 func() error, keys ...string) error {
+	err := m.lc.write(w1, w2, func() error { return m.m1.DeleteMulti(keys) })
+	return m.lc.Error(err, m.lc.invalidate(m.name, keys...))
 }
 
-func (m *l2CacheMap) Put(key string, val string) error {
-	err1 := m.m1.Put(key, val)
-	err2 := m.m2.Put(key, val)
-	return m.lc.Error(err1, err2)
+//promoteExpire 回填m1后缩短c1中Map的过期时间, 使其不晚于m2过期, Map中的数据没有单独的过期时间
+func (m *l2CacheMap) promoteExpire() {
+	expire := m.lc.promoteExpire(m.name)
+	if len(expire) == 0 {
+		return
+	}
+	if ttl, err := TTL(m.lc.c1, m.name); err == nil && ttl > 0 && ttl <= expire[0] {
+		return
+	}
+	m.lc.c1.SetExpire(m.name, expire[0])
 }
 
-func (m *l2CacheMap) Get(key string) (string, error) {
+func (m *l2CacheMap) Put(key string, val string) (err error) {
+	defer m.lc.st.write("Put", m.name, key, m.lc.st.start(), &err)
+	return m.write(
+		func() error { return m.m1.Put(key, val) },
+		func() error { return m.m2.Put(key, val) }, key)
+}
+
+func (m *l2CacheMap) Get(key string) (str string, err error) {
+	defer m.lc.st.read("Get", m.name, key, m.lc.st.start(), &err)
 	v, err := m.m1.Get(key)
 	if err == nil {
 		return v, nil
@@ -207,69 +525,128 @@ func (m *l2CacheMap) Get(key string) (string, error) {
 	v, err = m.m2.Get(key)
 	if err == nil {
 		m.m1.Put(key, v)
+		m.promoteExpire()
 		return v, nil
 	}
 
 	return "", err
 }
 func (m *l2CacheMap) GetMulti(keys []string) ([]string, error) {
-	vs, err := m.m1.GetMulti(keys)
-	if err == nil {
-		nullNums := 0
-		for i := 0; i < len(vs); i++ {
-			if vs[i] == "" {
-				nullNums++
-			}
-		}
-		if nullNums < len(vs) {
-			return vs, nil
+	vs, _, err := m.GetMultiHits(keys)
+	return vs, err
+}
+
+func (m *l2CacheMap) GetMultiHits(keys []string) ([]string, []bool, error) {
+	vs, hits, err := GetMultiHits(m.m1, keys)
+	if err != nil {
+		vs, hits = make([]string, len(keys)), make([]bool, len(keys))
+	}
+	missing, idx := missingKeys(keys, hits)
+	if len(missing) == 0 {
+		m.lc.st.countHits(hits)
+		return vs, hits, nil
+	}
+
+	vs2, hits2, err := GetMultiHits(m.m2, missing)
+	if err != nil {
+		return nil, nil, err
+	}
+	promoted := false
+	for j, i := range idx {
+		if hits2[j] {
+			vs[i], hits[i] = vs2[j], true
+			m.m1.Put(keys[i], vs2[j])
+			promoted = true
 		}
 	}
-	vs, err = m.m2.GetMulti(keys)
-	if err == nil {
-		for i, k := range keys {
-			m.m1.Put(k, vs[i])
+	if promoted {
+		m.promoteExpire()
+	}
+	m.lc.st.countHits(hits)
+	return vs, hits, nil
+}
+
+func (m *l2CacheMap) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	hits, err := GetObjects(m.m1, keys, valptr)
+	if err != nil {
+		hits = make([]bool, len(keys))
+	}
+	missing, idx := missingKeys(keys, hits)
+	if len(missing) == 0 {
+		m.lc.st.countHits(hits)
+		return hits, nil
+	}
+
+	hits2, err := GetObjects(m.m2, missing, func(j int) interface{} { return valptr(idx[j]) })
+	if err != nil {
+		return nil, err
+	}
+	promoted := false
+	for j, i := range idx {
+		if hits2[j] {
+			hits[i] = true
+			m.m1.PutObject(keys[i], elemCopy(valptr(i)))
+			promoted = true
 		}
-		return vs, nil
 	}
-	return nil, err
+	if promoted {
+		m.promoteExpire()
+	}
+	m.lc.st.countHits(hits)
+	return hits, nil
 }
 
-func (m *l2CacheMap) PutObject(key string, val interface{}) error {
-	err1 := m.m1.PutObject(key, val)
-	err2 := m.m2.PutObject(key, val)
-	return m.lc.Error(err1, err2)
+func (m *l2CacheMap) PutObject(key string, val interface{}) (err error) {
+	defer m.lc.st.write("PutObject", m.name, key, m.lc.st.start(), &err)
+	return m.write(
+		func() error { return m.m1.PutObject(key, val) },
+		func() error { return m.m2.PutObject(key, val) }, key)
 }
 
-func (m *l2CacheMap) GetObject(key string, valptr interface{}) error {
-	err := m.m1.GetObject(key, valptr)
+func (m *l2CacheMap) GetObject(key string, valptr interface{}) (err error) {
+	defer m.lc.st.read("GetObject", m.name, key, m.lc.st.start(), &err)
+	err = m.m1.GetObject(key, valptr)
 	if err == nil {
 		return nil
 	}
 
 	err = m.m2.GetObject(key, valptr)
 	if err == nil {
-		m.m1.PutObject(key, valptr)
+		m.m1.PutObject(key, elemCopy(valptr))
+		m.promoteExpire()
 		return nil
 	}
 
 	return err
 }
 
-func (m *l2CacheMap) Delete(key string) error {
+func (m *l2CacheMap) Delete(key string) (err error) {
+	defer m.lc.st.remove("Delete", m.name, key, m.lc.st.start(), &err)
 	err1 := m.m1.Delete(key)
 	err2 := m.m2.Delete(key)
-	return m.lc.Error(err1, err2)
+	return m.lc.Error(m.lc.Error(err1, err2), m.lc.invalidate(m.name, key))
 }
 func (m *l2CacheMap) Incr(key string) error {
-	err1 := m.m1.Incr(key)
-	err2 := m.m2.Incr(key)
-	return m.lc.Error(err1, err2)
+	_, err := m.IncrBy(key, 1)
+	return err
 }
 func (m *l2CacheMap) Decr(key string) error {
-	err1 := m.m1.Decr(key)
-	err2 := m.m2.Decr(key)
-	return m.lc.Error(err1, err2)
+	_, err := m.IncrBy(key, -1)
+	return err
+}
+func (m *l2CacheMap) IncrBy(key string, delta int64) (int64, error) {
+	if m.lc.opts.WritePolicy == WriteL1Only {
+		return m.m1.IncrBy(key, delta)
+	}
+	n, err := m.m2.IncrBy(key, delta)
+	return n, m.lc.Error(m.lc.Error(err, m.m1.Delete(key)), m.lc.invalidate(m.name, key))
+}
+func (m *l2CacheMap) IncrByFloat(key string, delta float64) (float64, error) {
+	if m.lc.opts.WritePolicy == WriteL1Only {
+		return m.m1.IncrByFloat(key, delta)
+	}
+	n, err := m.m2.IncrByFloat(key, delta)
+	return n, m.lc.Error(m.lc.Error(err, m.m1.Delete(key)), m.lc.invalidate(m.name, key))
 }
 func (m *l2CacheMap) Exists(key string) bool {
 	if m.m1.Exists(key) {
@@ -277,15 +654,101 @@ func (m *l2CacheMap) Exists(key string) bool {
 	}
 	return m.m2.Exists(key)
 }
-func (m *l2CacheMap) Size() (int, error) {
-	n, err := m.m1.Size()
-	if err == nil {
-		return n, nil
+
+//last 返回数据完整的一级, m1中的数据可能不完整, 除WriteL1Only外为m2
+func (m *l2CacheMap) last() Map {
+	if m.lc.opts.WritePolicy == WriteL1Only {
+		return m.m1
 	}
-	return m.m2.Size()
+	return m.m2
+}
+
+func (m *l2CacheMap) Size() (int, error) {
+	return m.last().Size()
 }
 func (m *l2CacheMap) Clear() error {
 	err1 := m.m1.Clear()
 	err2 := m.m2.Clear()
-	return m.lc.Error(err1, err2)
+	return m.lc.Error(m.lc.Error(err1, err2), m.lc.invalidate(m.name))
+}
+
+//PutMulti, DeleteMulti 没有key时直接返回, 避免发出清空Map的失效通知
+func (m *l2CacheMap) PutMulti(vals map[string]string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(vals))
+	for key := range vals {
+		keys = append(keys, key)
+	}
+	return m.write(
+		func() error { return m.m1.PutMulti(vals) },
+		func() error { return m.m2.PutMulti(vals) }, keys...)
+}
+
+func (m *l2CacheMap) GetAll() (map[string]string, error) {
+	return m.last().GetAll()
+}
+
+func (m *l2CacheMap) Keys() ([]string, error) {
+	return m.last().Keys()
+}
+
+func (m *l2CacheMap) Range(fn func(key, val string) bool) error {
+	return m.last().Range(fn)
+}
+
+func (m *l2CacheMap) DeleteMulti(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	err1 := m.m1.DeleteMulti(keys)
+	err2 := m.m2.DeleteMulti(keys)
+	return m.lc.Error(m.lc.Error(err1, err2), m.lc.invalidate(m.name, keys...))
+}
+
+//withCtx 返回绑定ctx的l2CacheMap, 各级Map通过context版本读写
+func (m *l2CacheMap) withCtx(ctx context.Context) *l2CacheMap {
+	return &l2CacheMap{lc: m.lc, name: m.name, m1: bindMap(ctx, m.m1), m2: bindMap(ctx, m.m2)}
+}
+
+func (m *l2CacheMap) PutCtx(ctx context.Context, key string, val string) error {
+	return m.withCtx(ctx).Put(key, val)
+}
+func (m *l2CacheMap) GetCtx(ctx context.Context, key string) (string, error) {
+	return m.withCtx(ctx).Get(key)
+}
+func (m *l2CacheMap) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
+	return m.withCtx(ctx).GetMulti(keys)
+}
+func (m *l2CacheMap) getMultiHitsCtx(ctx context.Context, keys []string) ([]string, []bool, error) {
+	return m.withCtx(ctx).GetMultiHits(keys)
+}
+func (m *l2CacheMap) PutObjectCtx(ctx context.Context, key string, val interface{}) error {
+	return m.withCtx(ctx).PutObject(key, val)
+}
+func (m *l2CacheMap) GetObjectCtx(ctx context.Context, key string, valptr interface{}) error {
+	return m.withCtx(ctx).GetObject(key, valptr)
+}
+func (m *l2CacheMap) DeleteCtx(ctx context.Context, key string) error {
+	return m.withCtx(ctx).Delete(key)
+}
+func (m *l2CacheMap) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	return m.withCtx(ctx).IncrBy(key, delta)
+}
+func (m *l2CacheMap) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	return m.withCtx(ctx).IncrByFloat(key, delta)
+}
+func (m *l2CacheMap) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	ok, err := MapContextOf(m.m1).ExistsCtx(ctx, key)
+	if err != nil || ok {
+		return ok, err
+	}
+	return MapContextOf(m.m2).ExistsCtx(ctx, key)
+}
+func (m *l2CacheMap) SizeCtx(ctx context.Context) (int, error) {
+	return m.withCtx(ctx).Size()
+}
+func (m *l2CacheMap) ClearCtx(ctx context.Context) error {
+	return m.withCtx(ctx).Clear()
 }
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//flightCall 正在进行的一次加载
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+//flightGroup 合并同一个key的并发加载, 只有一个调用者真正执行加载函数
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[flightKey]*flightCall
+}
+
+//flightKey c为可以作为map key的缓存, 见hashable, str表示GetStringOrLoad的加载, 与GetOrLoad加载的值类型不同, 不能合并
+type flightKey struct {
+	c   Cache
+	str bool
+	key string
+}
+
+//do 执行fn, fn panic时恢复并作为错误返回给全部调用者
+func (g *flightGroup) do(k flightKey, fn func() (interface{}, error)) (interface{}, error) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[flightKey]*flightCall)
+	}
+	if call, ok := g.calls[k]; ok {
+		g.lock.Unlock()
+		call.wg.Wait()
+		return call.val, call.err
+	}
+	call := new(flightCall)
+	call.wg.Add(1)
+	g.calls[k] = call
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, k)
+		g.lock.Unlock()
+		call.wg.Done()
+	}()
+	call.val, call.err = safeCall(fn)
+	return call.val, call.err
+}
+
+//safeCall 执行fn, fn panic时恢复并作为错误返回
+func safeCall(fn func() (interface{}, error)) (val interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			val, err = nil, fmt.Errorf("cache: load panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+//loadGroup GetOrLoad及GetStringOrLoad使用
+var loadGroup flightGroup
+
+//loadOnce 合并c中同一个key的并发加载
+//c的动态类型不能作为map key时(如包含map或slice的struct值)没有固定的标识, 不合并, 每个调用者各自加载
+func loadOnce(c Cache, key string, str bool, fn func() (interface{}, error)) (interface{}, error) {
+	if !hashable(reflect.ValueOf(c)) {
+		return safeCall(fn)
+	}
+	return loadGroup.do(flightKey{c, str, key}, fn)
+}
+
+//hashable v是否可以作为map key, 接口类型按实际的值判断
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return false
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//GetOrLoad 从缓存中获取对象, 不存在时调用load加载并写入缓存, 同一个key的并发加载只执行一次
+//valptr - object ptr
+//load - 加载函数, 返回对象或对象指针
+//expire - 过期时间, 同PutObject
+//写入缓存失败时不影响返回结果
+func GetOrLoad(c Cache, key string, valptr interface{}, load func() (interface{}, error), expire ...time.Duration) error {
+	rv := reflect.ValueOf(valptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("cache: valptr must be a non-nil pointer")
+	}
+	if err := c.GetObject(key, valptr); err == nil {
+		return nil
+	}
+
+	val, err := loadOnce(c, key, false, func() (interface{}, error) {
+		val, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.PutObject(key, val, expire...)
+		return val, nil
+	})
+	if err != nil {
+		return err
+	}
+	return assign(rv, val)
+}
+
+//GetStringOrLoad 从缓存中获取字符串, 不存在时调用load加载并写入缓存, 同一个key的并发加载只执行一次
+func GetStringOrLoad(c Cache, key string, load func() (string, error), expire ...time.Duration) (string, error) {
+	if v, err := c.Get(key); err == nil {
+		return v, nil
+	}
+
+	val, err := loadOnce(c, key, true, func() (interface{}, error) {
+		val, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.Put(key, val, expire...)
+		return val, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	s, ok := val.(string)
+	if !ok {
+		return "", errors.New("cache: loaded value is not a string")
+	}
+	return s, nil
+}
+
+//assign 将加载的对象赋值给valptr指向的变量
+func assign(ptr reflect.Value, val interface{}) error {
+	if val == nil {
+		return ErrNil
+	}
+	v := reflect.ValueOf(val)
+	dst := ptr.Elem()
+	if v.Kind() == reflect.Ptr && !v.Type().AssignableTo(dst.Type()) {
+		if v.IsNil() {
+			return ErrNil
+		}
+		v = v.Elem()
+	}
+	if !v.Type().AssignableTo(dst.Type()) {
+		return errors.New("cache: loaded value of type " + v.Type().String() + " is not assignable to " + dst.Type().String())
+	}
+	dst.Set(v)
+	return nil
+}
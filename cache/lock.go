@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrLockNotObtained = errors.New("cache: lock not obtained")
+	ErrLockNotHeld     = errors.New("cache: lock not held")
+	//ErrLockTTL ttl<=0, 不过期的锁在持有者崩溃后无法释放, 各适配器统一拒绝
+	ErrLockTTL = errors.New("cache: lock ttl must be positive")
+)
+
+//Locker 基于缓存的锁, 每次加锁生成唯一token, 只有持有token才能解锁或续期
+type Locker interface {
+	//阻塞直到获得锁, ctx结束时返回ctx.Err(), ttl<=0时返回ErrLockTTL, 下同
+	Lock(ctx context.Context, name string, ttl time.Duration) (token string, err error)
+	//尝试加锁, 锁已被占用时返回ErrLockNotObtained
+	TryLock(name string, ttl time.Duration) (token string, err error)
+	//释放锁, 锁已过期或被他人持有时返回ErrLockNotHeld
+	Unlock(name, token string) error
+	//续期, 锁已过期或被他人持有时返回ErrLockNotHeld
+	Refresh(name, token string, ttl time.Duration) error
+}
+
+//lockBackend 由适配器实现的原子加锁操作
+type lockBackend interface {
+	acquireLock(name, token string, ttl time.Duration) (bool, error)
+	releaseLock(name, token string) (bool, error)
+	refreshLock(name, token string, ttl time.Duration) (bool, error)
+}
+
+//NewLocker 创建基于c的锁, memory适配器只在进程内有效, redis适配器可跨进程使用
+//多级缓存使用最后一级缓存
+func NewLocker(c Cache) (Locker, error) {
+	b := lockBackendOf(c)
+	if b == nil {
+		return nil, ErrNotSupported
+	}
+	return &locker{b: b, minRetry: time.Millisecond * 10, maxRetry: time.Millisecond * 500}, nil
+}
+
+func lockBackendOf(c Cache) lockBackend {
+	switch c := c.(type) {
+	case *l2Cache:
+		return lockBackendOf(c.c2)
+	case *nsCache:
+		if b := lockBackendOf(c.c); b != nil {
+			return &nsLockBackend{b: b, prefix: c.prefix}
+		}
+	case lockBackend:
+		return c
+	}
+	return nil
+}
+
+type locker struct {
+	b        lockBackend
+	minRetry time.Duration
+	maxRetry time.Duration
+}
+
+func (l *locker) Lock(ctx context.Context, name string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", ErrLockTTL
+	}
+	retry := l.minRetry
+	for {
+		token, err := l.TryLock(name, ttl)
+		if err != ErrLockNotObtained {
+			return token, err
+		}
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+		if retry *= 2; retry > l.maxRetry {
+			retry = l.maxRetry
+		}
+	}
+}
+
+func (l *locker) TryLock(name string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", ErrLockTTL
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	ok, err := l.b.acquireLock(name, token, ttl)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrLockNotObtained
+	}
+	return token, nil
+}
+
+func (l *locker) Unlock(name, token string) error {
+	ok, err := l.b.releaseLock(name, token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *locker) Refresh(name, token string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrLockTTL
+	}
+	ok, err := l.b.refreshLock(name, token, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,18 +1,27 @@
 package cache
 
 import (
+	"container/heap"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type memoryEntry struct {
+	key         string
 	value       interface{}
 	createdtime time.Time
 	expire      time.Duration
+	node        *evictNode
+	index       int //在过期堆中的位置, -1表示不在堆中
 }
 
 func (e *memoryEntry) isExpire() bool {
@@ -22,121 +31,282 @@ func (e *memoryEntry) isExpire() bool {
 	return time.Now().Sub(e.createdtime) > e.expire
 }
 
+func (e *memoryEntry) deadline() time.Time {
+	return e.createdtime.Add(e.expire)
+}
+
+//expireHeap 按过期时间排序的最小堆, GC时只需处理已到期的数据
+type expireHeap []*memoryEntry
+
+func (h expireHeap) Len() int           { return len(h) }
+func (h expireHeap) Less(i, j int) bool { return h[i].deadline().Before(h[j].deadline()) }
+func (h expireHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *expireHeap) Push(x interface{}) {
+	e := x.(*memoryEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *expireHeap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	e := old[n]
+	old[n] = nil
+	*h = old[:n]
+	e.index = -1
+	return e
+}
+
+//memoryShard 数据按key分片存放, 各分片独立加锁
+type memoryShard struct {
+	c       *memoryCache
+	lock    *sync.RWMutex
+	items   map[string]*memoryEntry
+	expires expireHeap
+}
+
+func newMemoryShard(c *memoryCache) *memoryShard {
+	return &memoryShard{c: c, items: make(map[string]*memoryEntry), lock: new(sync.RWMutex)}
+}
+
 type memoryCache struct {
-	lock          *sync.RWMutex
+	shards        []*memoryShard
 	gccyc         time.Duration
-	items         map[string]*memoryEntry
 	defaultExpire time.Duration //数据默认过期时间
+	ev            *evictor      //nil时不限制数据条数及字节数
+	codec         Codec         //nil时PutObject直接保存对象
+	st            *statsCounter
+	expirations   uint64
+	snapshotPath  string //为空时不保存快照
+	snapshotLock  sync.Mutex
 }
 
+//codecValue PutObject经codec序列化后保存的数据
+type codecValue []byte
+
 func NewMemoryCache() Cache {
-	return &memoryCache{items: make(map[string]*memoryEntry), lock: new(sync.RWMutex)}
+	c := &memoryCache{st: newStatsCounter("memory")}
+	c.shards = []*memoryShard{newMemoryShard(c)}
+	return c
+}
+
+type memoryConfig struct {
+	Gccyc         int    `json:"gccyc"`
+	DefaultExpire int    `json:"defaultExpire"`
+	Shards        int    `json:"shards"`
+	MaxEntries    int    `json:"maxEntries"`
+	MaxBytes      int64  `json:"maxBytes"`
+	EvictPolicy   string `json:"evictPolicy"`
+	Codec         string `json:"codec"`
+	Compress      string `json:"compress"`
+	CompressLimit int    `json:"compressThreshold"`
+	SnapshotPath  string `json:"snapshotPath"`
+	SnapshotInt   int    `json:"snapshotInterval"`
 }
 
-//config - {"gccyc":60, "defaultExpire":10}, second
+//config - {"gccyc":60, "defaultExpire":10, "shards":16, "maxEntries":10000, "maxBytes":0, "evictPolicy":"lru"}, second
 //gccyc - GC周期， 秒， 默认：60
 //defaultExpire - 默认过期时间，秒， 默认：0, 数据将不会过期
+//shards - 数据分片数, 分片越多锁竞争越少， 默认：16
+//maxEntries - 最大数据条数(包括Map中的数据), 默认：0, 不限制
+//maxBytes - 最大数据字节数(估算值), 默认：0, 不限制
+//evictPolicy - 超出限制时的淘汰策略, lru, lfu 或 random, 默认：lru
+//codec - PutObject的序列化方式, json, gob, msgpack, protobuf或RegisterCodec注册的名称, 默认：不序列化, 直接保存对象
+//compress - 序列化后的压缩方式, gzip或snappy, 默认：不压缩
+//compressThreshold - 序列化后达到此字节数才压缩, 默认：0
+//snapshotPath - 快照文件路径, Init时加载快照, 已过期的数据不加载, 默认：不保存快照
+//  保存快照时PutObject的数据必须序列化, 未配置codec时使用json
+//snapshotInterval - 定期保存快照的周期, 秒, 默认：0, 不定期保存, 可调用Snapshot保存
 func (c *memoryCache) Init(config string) error {
-	cf := make(map[string]int)
+	cf := memoryConfig{Gccyc: 60, Shards: 16}
 	json.Unmarshal([]byte(config), &cf)
-	if _, ok := cf["gccyc"]; !ok {
-		cf["gccyc"] = 60
+	if cf.Shards < 1 {
+		cf.Shards = 1
+	}
+
+	ev, err := newEvictor(cf.EvictPolicy, cf.MaxEntries, cf.MaxBytes)
+	if err != nil {
+		return err
 	}
 
-	if _, ok := cf["defaultExpire"]; !ok {
-		cf["defaultExpire"] = 0
+	if cf.Codec != "" || cf.Compress != "" || cf.SnapshotPath != "" {
+		if c.codec, err = NewCodec(cf.Codec, JSONCodec, cf.Compress, cf.CompressLimit); err != nil {
+			return err
+		}
 	}
 
-	c.gccyc = time.Duration(cf["gccyc"]) * time.Second
-	c.defaultExpire = time.Duration(cf["defaultExpire"]) * time.Second
+	c.gccyc = time.Duration(cf.Gccyc) * time.Second
+	c.defaultExpire = time.Duration(cf.DefaultExpire) * time.Second
+	c.ev = ev
+	c.shards = make([]*memoryShard, cf.Shards)
+	for i := range c.shards {
+		c.shards[i] = newMemoryShard(c)
+	}
+
+	if c.snapshotPath = cf.SnapshotPath; c.snapshotPath != "" {
+		if err = c.loadSnapshot(); err != nil {
+			log.Printf("cache: load memory snapshot error, %v\n", err)
+		}
+		if cf.SnapshotInt > 0 {
+			go c.snapshotLoop(time.Duration(cf.SnapshotInt) * time.Second)
+		}
+	}
 
 	go c.gc()
 
 	return nil
 }
 
+func (c *memoryCache) shard(key string) *memoryShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	//FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
 func (c *memoryCache) gc() {
 	if (c.gccyc / time.Second) < 1 {
 		return
 	}
 	for {
 		<-time.After(c.gccyc)
-		if c.items == nil {
+		if c.shards == nil {
 			return
 		}
 
-		c.itemExpireds()
-		//for name := range c.items {
-		//	c.itemExpired(name)
-		//}
+		for _, s := range c.shards {
+			s.itemExpireds(time.Now())
+		}
 	}
 }
 
-func (c *memoryCache) itemExpireds() {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	for key, itm := range c.items {
-		if itm.isExpire() {
-			delete(c.items, key)
-			return
-		}
+//itemExpireds 从过期堆中删除所有已到期的数据
+func (s *memoryShard) itemExpireds(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.expires) > 0 && !s.expires[0].deadline().After(now) {
+		itm := s.expires[0]
+		s.drop(itm.key, itm)
+		atomic.AddUint64(&s.c.expirations, 1)
 	}
 }
 
-func (c *memoryCache) itemExpired(name string) bool {
-	c.lock.Lock()
-	defer c.lock.Unlock()
+//drop 删除数据，并释放其淘汰记录
+func (s *memoryShard) drop(key string, itm *memoryEntry) {
+	delete(s.items, key)
+	if itm.index >= 0 {
+		heap.Remove(&s.expires, itm.index)
+	}
+	s.release(itm)
+}
 
-	itm, ok := c.items[name]
-	if !ok {
-		return true
+func (s *memoryShard) release(itm *memoryEntry) {
+	if s.c.ev == nil {
+		return
 	}
-	if itm.isExpire() {
-		delete(c.items, name)
-		return true
+	s.c.ev.remove(itm.node)
+	if m, ok := itm.value.(*memoryMap); ok {
+		m.release()
+	}
+}
+
+//schedule 过期时间变化后更新过期堆
+func (s *memoryShard) schedule(itm *memoryEntry) {
+	switch {
+	case itm.expire > 0 && itm.index >= 0:
+		heap.Fix(&s.expires, itm.index)
+	case itm.expire > 0:
+		heap.Push(&s.expires, itm)
+	case itm.index >= 0:
+		heap.Remove(&s.expires, itm.index)
+	}
+}
+
+//evict 由evictor回调, 淘汰数据
+func (s *memoryShard) evict(n *evictNode) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if itm, ok := s.items[n.key]; ok && itm.node == n {
+		s.drop(n.key, itm)
 	}
-	return false
 }
 
-func (c *memoryCache) put(key string, val interface{}, expire ...time.Duration) *memoryEntry {
+//shrink 淘汰超出限制的数据, 调用时不能持有锁
+func (c *memoryCache) shrink() {
+	if c.ev != nil {
+		c.ev.shrink()
+	}
+}
+
+func (c *memoryCache) access(itm *memoryEntry) {
+	if c.ev != nil {
+		c.ev.access(itm.node)
+	}
+}
+
+func (s *memoryShard) put(key string, val interface{}, expire ...time.Duration) *memoryEntry {
 
 	var timeout time.Duration
 	if len(expire) > 0 {
 		timeout = expire[0]
-	} else if c.defaultExpire > 0 {
-		timeout = c.defaultExpire
+	} else if s.c.defaultExpire > 0 {
+		timeout = s.c.defaultExpire
 	}
 
 	itm := &memoryEntry{
+		key:         key,
 		value:       val,
 		createdtime: time.Now(),
 		expire:      timeout,
+		index:       -1,
+	}
+	if old, ok := s.items[key]; ok {
+		s.drop(key, old)
 	}
-	c.items[key] = itm
+	if s.c.ev != nil {
+		itm.node = s.c.ev.add(s, key, sizeOf(key, val))
+	}
+	s.items[key] = itm
+	s.schedule(itm)
 	return itm
 }
 
-func (c *memoryCache) Put(key string, val string, expire ...time.Duration) error {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	c.put(key, val, expire...)
+func (c *memoryCache) Put(key string, val string, expire ...time.Duration) (err error) {
+	defer c.st.write("Put", "", key, c.st.start(), &err)
+	defer c.shrink()
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.put(key, val, expire...)
 	return nil
 }
 
 func (c *memoryCache) lget(key string) interface{} {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	if itm, ok := c.items[key]; !ok || itm.isExpire() {
+	s := c.shard(key)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if itm, ok := s.items[key]; !ok || itm.isExpire() {
 		return nil
 	} else {
 		return itm
 	}
 }
 
-func (c *memoryCache) Get(key string) (string, error) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	if itm, ok := c.items[key]; ok {
+func (c *memoryCache) Get(key string) (str string, err error) {
+	defer c.st.read("Get", "", key, c.st.start(), &err)
+	s := c.shard(key)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if itm, ok := s.items[key]; ok {
 		if itm.isExpire() {
 			return "", ErrNil
 		}
@@ -144,11 +314,14 @@ func (c *memoryCache) Get(key string) (string, error) {
 		if itm.value == nil {
 			return "", ErrNil
 		}
+		c.access(itm)
 		switch v := itm.value.(type) {
 		case string:
 			return v, nil
 		case *string:
 			return *v, nil
+		case codecValue:
+			return string(v), nil
 		default:
 			return fmt.Sprint(itm.value), nil
 		}
@@ -167,119 +340,220 @@ func (c *memoryCache) GetMulti(keys []string) ([]string, error) {
 	}
 	return rs, err
 }
-func (c *memoryCache) PutObject(key string, val interface{}, expire ...time.Duration) error {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	c.put(key, val, expire...)
+func (c *memoryCache) GetMultiHits(keys []string) ([]string, []bool, error) {
+	rs := make([]string, len(keys))
+	hits := make([]bool, len(keys))
+	for i, key := range keys {
+		v, err := c.Get(key)
+		rs[i], hits[i] = v, err == nil
+	}
+	return rs, hits, nil
+}
+func (c *memoryCache) PutObject(key string, val interface{}, expire ...time.Duration) (err error) {
+	defer c.st.write("PutObject", "", key, c.st.start(), &err)
+	val, err = c.encode(val)
+	if err != nil {
+		return err
+	}
+	defer c.shrink()
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.put(key, val, expire...)
 	return nil
 }
 
-//valptr - object ptr
-func (c *memoryCache) GetObject(key string, valptr interface{}) error {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	if itm, ok := c.items[key]; ok {
-		if itm.isExpire() {
+func (c *memoryCache) encode(val interface{}) (interface{}, error) {
+	if c.codec == nil {
+		return val, nil
+	}
+	b, err := c.codec.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	return codecValue(b), nil
+}
+
+//decode 将保存的数据赋值给valptr
+func (c *memoryCache) decode(value interface{}, valptr interface{}) error {
+	if b, ok := value.(codecValue); ok {
+		return c.codec.Unmarshal(b, valptr)
+	}
+	dst := reflect.ValueOf(valptr)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return errors.New("cache: valptr must be a non-nil pointer")
+	}
+	dst = dst.Elem()
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr && !v.Type().AssignableTo(dst.Type()) {
+		if v.IsNil() {
 			return ErrNil
 		}
-		v := reflect.ValueOf(itm.value)
-		if v.Type().Kind() == reflect.Ptr {
-			reflect.ValueOf(valptr).Elem().Set(v.Elem())
-		} else {
-			reflect.ValueOf(valptr).Elem().Set(v)
+		v = v.Elem()
+	}
+	if !v.Type().AssignableTo(dst.Type()) {
+		return fmt.Errorf("cache: cannot assign %v to %v", v.Type(), dst.Type())
+	}
+	dst.Set(v)
+	return nil
+}
+
+//valptr - object ptr
+func (c *memoryCache) GetObject(key string, valptr interface{}) (err error) {
+	defer c.st.read("GetObject", "", key, c.st.start(), &err)
+	s := c.shard(key)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if itm, ok := s.items[key]; ok {
+		if itm.isExpire() || itm.value == nil {
+			return ErrNil
 		}
-		return nil
+		c.access(itm)
+		return c.decode(itm.value, valptr)
 	}
 	return ErrNil
 }
-func (c *memoryCache) Delete(key string) error {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	if _, ok := c.items[key]; !ok {
+func (c *memoryCache) Delete(key string) (err error) {
+	defer c.st.remove("Delete", "", key, c.st.start(), &err)
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	itm, ok := s.items[key]
+	if !ok {
 		//return errors.New("key not exist")
 		return nil
 	}
-	delete(c.items, key)
-	if _, ok := c.items[key]; ok {
+	s.drop(key, itm)
+	if _, ok := s.items[key]; ok {
 		return errors.New("delete key error")
 	}
 	return nil
 }
 func (c *memoryCache) Incr(key string) error {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	itm, ok := c.items[key]
-	if !ok {
-		itm = c.put(key, 0, 0)
-	} else {
-		if itm.value == nil {
-			itm.value = 0
-		}
+	_, err := c.IncrBy(key, 1)
+	return err
+}
+func (c *memoryCache) Decr(key string) error {
+	_, err := c.IncrBy(key, -1)
+	return err
+}
+func (c *memoryCache) IncrBy(key string, delta int64) (int64, error) {
+	defer c.shrink()
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	itm, ok := s.items[key]
+	if !ok || itm.isExpire() {
+		itm = s.put(key, 0, 0)
+	}
+	value, n, err := incrValue(itm.value, delta)
+	if err != nil {
+		return 0, err
+	}
+	itm.value = value
+	return n, nil
+}
+func (c *memoryCache) IncrByFloat(key string, delta float64) (float64, error) {
+	defer c.shrink()
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	itm, ok := s.items[key]
+	if !ok || itm.isExpire() {
+		itm = s.put(key, 0, 0)
+	}
+	value, n, err := incrFloatValue(itm.value, delta)
+	if err != nil {
+		return 0, err
 	}
-	switch itm.value.(type) {
+	itm.value = value
+	return n, nil
+}
+
+//incrValue 数值加上delta, 保持原数据类型, 字符串按整数解析
+func incrValue(value interface{}, delta int64) (interface{}, int64, error) {
+	switch v := value.(type) {
+	case nil:
+		return int(delta), delta, nil
 	case int:
-		itm.value = itm.value.(int) + 1
+		n := int64(v) + delta
+		return int(n), n, nil
 	case int32:
-		itm.value = itm.value.(int32) + 1
+		n := int64(v) + delta
+		return int32(n), n, nil
 	case int64:
-		itm.value = itm.value.(int64) + 1
+		n := v + delta
+		return n, n, nil
 	case uint:
-		itm.value = itm.value.(uint) + 1
+		n := int64(v) + delta
+		if n < 0 {
+			return nil, 0, errors.New("item val is less than 0")
+		}
+		return uint(n), n, nil
 	case uint32:
-		itm.value = itm.value.(uint32) + 1
+		n := int64(v) + delta
+		if n < 0 {
+			return nil, 0, errors.New("item val is less than 0")
+		}
+		return uint32(n), n, nil
 	case uint64:
-		itm.value = itm.value.(uint64) + 1
+		n := int64(v) + delta
+		if n < 0 {
+			return nil, 0, errors.New("item val is less than 0")
+		}
+		return uint64(n), n, nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, 0, errors.New("item val is not an integer")
+		}
+		n += delta
+		return strconv.FormatInt(n, 10), n, nil
 	default:
-		return errors.New("item val is not (u)int (u)int32 (u)int64")
+		return nil, 0, errors.New("item val is not (u)int (u)int32 (u)int64")
 	}
-	return nil
 }
-func (c *memoryCache) Decr(key string) error {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	itm, ok := c.items[key]
-	if !ok {
-		itm = c.put(key, 0, 0)
-	} else {
-		if itm.value == nil {
-			itm.value = 0
-		}
-	}
 
-	switch itm.value.(type) {
+//incrFloatValue 数值加上delta, 整数将转为float64, 字符串按浮点数解析
+func incrFloatValue(value interface{}, delta float64) (interface{}, float64, error) {
+	var f float64
+	switch v := value.(type) {
+	case nil:
+	case float32:
+		n := float64(v) + delta
+		return float32(n), n, nil
+	case float64:
+		f = v
 	case int:
-		itm.value = itm.value.(int) - 1
-	case int64:
-		itm.value = itm.value.(int64) - 1
+		f = float64(v)
 	case int32:
-		itm.value = itm.value.(int32) - 1
+		f = float64(v)
+	case int64:
+		f = float64(v)
 	case uint:
-		if itm.value.(uint) > 0 {
-			itm.value = itm.value.(uint) - 1
-		} else {
-			return errors.New("item val is less than 0")
-		}
+		f = float64(v)
 	case uint32:
-		if itm.value.(uint32) > 0 {
-			itm.value = itm.value.(uint32) - 1
-		} else {
-			return errors.New("item val is less than 0")
-		}
+		f = float64(v)
 	case uint64:
-		if itm.value.(uint64) > 0 {
-			itm.value = itm.value.(uint64) - 1
-		} else {
-			return errors.New("item val is less than 0")
+		f = float64(v)
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, 0, errors.New("item val is not a float")
 		}
+		n += delta
+		return strconv.FormatFloat(n, 'f', -1, 64), n, nil
 	default:
-		return errors.New("item val is not int int64 int32")
+		return nil, 0, errors.New("item val is not a number")
 	}
-	return nil
+	f += delta
+	return f, f, nil
 }
 func (c *memoryCache) Exists(key string) bool {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	if itm, ok := c.items[key]; ok {
+	s := c.shard(key)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if itm, ok := s.items[key]; ok {
 		if itm.isExpire() {
 			return false
 		}
@@ -288,16 +562,117 @@ func (c *memoryCache) Exists(key string) bool {
 	return false
 }
 
-func (c *memoryCache) setExpire(key string, expire time.Duration) *memoryEntry {
+func (c *memoryCache) TTL(key string) (time.Duration, error) {
+	s := c.shard(key)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	itm, ok := s.items[key]
+	if !ok || itm.isExpire() {
+		return 0, ErrNil
+	}
+	if itm.expire == 0 {
+		return 0, nil
+	}
+	if ttl := itm.deadline().Sub(time.Now()); ttl > 0 {
+		return ttl, nil
+	}
+	return 0, ErrNil
+}
+
+//getString 获取未过期数据的字符串值, 调用时需持有锁
+func (s *memoryShard) getString(key string) (string, bool) {
+	itm, ok := s.items[key]
+	if !ok || itm.isExpire() || itm.value == nil {
+		return "", false
+	}
+	switch v := itm.value.(type) {
+	case string:
+		return v, true
+	case *string:
+		return *v, true
+	case codecValue:
+		return string(v), true
+	default:
+		return fmt.Sprint(itm.value), true
+	}
+}
+
+func (c *memoryCache) PutIfAbsent(key string, val string, expire ...time.Duration) (bool, error) {
+	defer c.shrink()
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.getString(key); ok {
+		return false, nil
+	}
+	s.put(key, val, expire...)
+	return true, nil
+}
+
+func (c *memoryCache) CompareAndSwap(key string, old, new string, expire ...time.Duration) (bool, error) {
+	defer c.shrink()
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.getString(key); !ok || v != old {
+		return false, nil
+	}
+	s.put(key, new, expire...)
+	return true, nil
+}
+
+func (c *memoryCache) GetAndDelete(key string) (string, error) {
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	v, ok := s.getString(key)
+	if !ok {
+		return "", ErrNil
+	}
+	s.drop(key, s.items[key])
+	return v, nil
+}
+
+func (c *memoryCache) acquireLock(name, token string, ttl time.Duration) (bool, error) {
+	return c.PutIfAbsent(name, token, ttl)
+}
+
+func (c *memoryCache) releaseLock(name, token string) (bool, error) {
+	s := c.shard(name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.getString(name); !ok || v != token {
+		return false, nil
+	}
+	s.drop(name, s.items[name])
+	return true, nil
+}
+
+func (c *memoryCache) refreshLock(name, token string, ttl time.Duration) (bool, error) {
+	s := c.shard(name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.getString(name); !ok || v != token {
+		return false, nil
+	}
+	s.setExpire(name, ttl)
+	return true, nil
+}
+
+func (s *memoryShard) setExpire(key string, expire time.Duration) *memoryEntry {
 	var me *memoryEntry
-	if itm, ok := c.items[key]; ok && !itm.isExpire() {
+	if itm, ok := s.items[key]; ok && !itm.isExpire() {
 		itm.createdtime = time.Now()
 		itm.expire = expire
 		me = itm
 	} else {
-		me = &memoryEntry{value: nil, createdtime: time.Now(), expire: expire}
-		c.items[key] = me
+		if ok {
+			s.drop(key, itm)
+		}
+		me = &memoryEntry{key: key, value: nil, createdtime: time.Now(), expire: expire, index: -1}
+		s.items[key] = me
 	}
+	s.schedule(me)
 	return me
 }
 
@@ -305,11 +680,104 @@ func (c *memoryCache) SetExpire(key string, expire ...time.Duration) error {
 	if len(expire) == 0 {
 		return nil
 	}
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	c.setExpire(key, expire[0])
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setExpire(key, expire[0])
 	return nil
 }
+func (c *memoryCache) Keys(pattern string) ([]string, error) {
+	var keys []string
+	for _, s := range c.shards {
+		keys = s.keys(pattern, keys)
+	}
+	return keys, nil
+}
+
+//Scan 每次返回一个分片中匹配的key, cursor为分片序号, count被忽略
+func (c *memoryCache) Scan(cursor uint64, pattern string, count int) (uint64, []string, error) {
+	if cursor >= uint64(len(c.shards)) {
+		return 0, nil, nil
+	}
+	keys := c.shards[cursor].keys(pattern, nil)
+	if cursor++; cursor == uint64(len(c.shards)) {
+		cursor = 0
+	}
+	return cursor, keys, nil
+}
+
+func (s *memoryShard) keys(pattern string, keys []string) []string {
+	if pattern == "" {
+		pattern = "*"
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for key, itm := range s.items {
+		if !itm.isExpire() && matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (c *memoryCache) DeleteByPrefix(prefix string) (int, error) {
+	n := 0
+	for _, s := range c.shards {
+		s.lock.Lock()
+		for key, itm := range s.items {
+			if strings.HasPrefix(key, prefix) {
+				if !itm.isExpire() {
+					n++
+				}
+				s.drop(key, itm)
+			}
+		}
+		s.lock.Unlock()
+	}
+	atomic.AddUint64(&c.st.deletes, uint64(n))
+	return n, nil
+}
+
+func (c *memoryCache) SetObserver(o Observer) {
+	c.st.setObserver(o)
+}
+
+//Stats 未限制数据条数及字节数时, Bytes在每次调用时计算
+func (c *memoryCache) Stats() (Stats, error) {
+	st := c.st.stats()
+	st.Expirations = atomic.LoadUint64(&c.expirations)
+	if c.ev != nil {
+		c.ev.lock.Lock()
+		st.Evictions = c.ev.evictions
+		st.Bytes = c.ev.bytes
+		c.ev.lock.Unlock()
+	}
+	for _, s := range c.shards {
+		s.lock.RLock()
+		st.Items += int64(len(s.items))
+		if c.ev == nil {
+			for key, itm := range s.items {
+				st.Bytes += sizeOf(key, itm.value)
+				if m, ok := itm.value.(*memoryMap); ok {
+					st.Bytes += m.bytes()
+				}
+			}
+		}
+		s.lock.RUnlock()
+	}
+	return st, nil
+}
+
+func (m *memoryMap) bytes() int64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var n int64
+	for key, val := range m.data {
+		n += sizeOf(key, val)
+	}
+	return n
+}
+
 func (c *memoryCache) NewMap(name string, expire ...time.Duration) (Map, error) {
 	var timeout time.Duration
 	if len(expire) > 0 {
@@ -317,12 +785,15 @@ func (c *memoryCache) NewMap(name string, expire ...time.Duration) (Map, error)
 	} else if c.defaultExpire > 0 {
 		timeout = c.defaultExpire
 	}
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	itm := c.setExpire(name, timeout)
-	if itm.value == nil {
-		itm.value = newMemoryMap(c, name)
-	} else if _, ok := itm.value.(Map); !ok {
+	s := c.shard(name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	itm := s.setExpire(name, timeout)
+	if _, ok := itm.value.(*memoryMap); !ok {
+		if c.ev != nil {
+			c.ev.remove(itm.node)
+			itm.node = nil
+		}
 		itm.value = newMemoryMap(c, name)
 	}
 	return itm.value.(Map), nil
@@ -330,27 +801,80 @@ func (c *memoryCache) NewMap(name string, expire ...time.Duration) (Map, error)
 
 //type memoryMap map[string]interface{}
 type memoryMap struct {
-	c    *memoryCache
-	name string
-	data map[string]interface{}
-	lock *sync.RWMutex
+	c     *memoryCache
+	name  string
+	data  map[string]interface{}
+	nodes map[string]*evictNode
+	lock  *sync.RWMutex
 }
 
 func newMemoryMap(c *memoryCache, name string) *memoryMap {
-	return &memoryMap{c, name, make(map[string]interface{}), new(sync.RWMutex)}
+	m := &memoryMap{c: c, name: name, data: make(map[string]interface{}), lock: new(sync.RWMutex)}
+	if c.ev != nil {
+		m.nodes = make(map[string]*evictNode)
+	}
+	return m
 }
 
-func (m *memoryMap) Put(key string, val string) error {
+//set 保存数据并更新淘汰记录, 调用时需持有写锁
+func (m *memoryMap) set(key string, val interface{}) {
+	m.data[key] = val
+	if m.nodes == nil {
+		return
+	}
+	m.c.ev.remove(m.nodes[key])
+	m.nodes[key] = m.c.ev.add(m, key, sizeOf(key, val))
+}
+
+func (m *memoryMap) unset(key string) {
+	delete(m.data, key)
+	if m.nodes == nil {
+		return
+	}
+	m.c.ev.remove(m.nodes[key])
+	delete(m.nodes, key)
+}
+
+func (m *memoryMap) access(key string) {
+	if m.nodes != nil {
+		m.c.ev.access(m.nodes[key])
+	}
+}
+
+//release Map已被删除或过期，释放所有淘汰记录
+func (m *memoryMap) release() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, n := range m.nodes {
+		m.c.ev.remove(n)
+	}
+	m.nodes = nil
+}
+
+//evict 由evictor回调, 淘汰Map中数据
+func (m *memoryMap) evict(n *evictNode) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.nodes != nil && m.nodes[n.key] == n {
+		delete(m.data, n.key)
+		delete(m.nodes, n.key)
+	}
+}
+
+func (m *memoryMap) Put(key string, val string) (err error) {
+	defer m.c.st.write("Put", m.name, key, m.c.st.start(), &err)
 	if m.c.lget(m.name) == nil {
 		return errors.New("cache: map(" + m.name + ")." + key + " is expired")
 	}
+	defer m.c.shrink()
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	m.data[key] = val
+	m.set(key, val)
 	return nil
 }
-func (m *memoryMap) Get(key string) (string, error) {
+func (m *memoryMap) Get(key string) (str string, err error) {
+	defer m.c.st.read("Get", m.name, key, m.c.st.start(), &err)
 	if m.c.lget(m.name) == nil {
 		return "", ErrNil
 	}
@@ -361,15 +885,22 @@ func (m *memoryMap) Get(key string) (string, error) {
 	if value == nil {
 		return "", ErrNil
 	}
+	m.access(key)
+	return valueString(value), nil
+}
+
+//valueString 将保存的数据转换为字符串
+func valueString(value interface{}) string {
 	switch v := value.(type) {
 	case string:
-		return v, nil
+		return v
 	case *string:
-		return *v, nil
+		return *v
+	case codecValue:
+		return string(v)
 	default:
-		return fmt.Sprint(value), nil
+		return fmt.Sprint(value)
 	}
-
 }
 func (m *memoryMap) GetMulti(keys []string) ([]string, error) {
 	var rs []string
@@ -391,18 +922,35 @@ func (m *memoryMap) GetMulti(keys []string) ([]string, error) {
 	return rs, err
 }
 
-func (m *memoryMap) PutObject(key string, val interface{}) error {
+func (m *memoryMap) GetMultiHits(keys []string) ([]string, []bool, error) {
+	rs := make([]string, len(keys))
+	hits := make([]bool, len(keys))
+	for i, key := range keys {
+		v, err := m.Get(key)
+		rs[i], hits[i] = v, err == nil
+	}
+	return rs, hits, nil
+}
+
+func (m *memoryMap) PutObject(key string, val interface{}) (err error) {
+	defer m.c.st.write("PutObject", m.name, key, m.c.st.start(), &err)
 	if m.c.lget(m.name) == nil {
 		return errors.New("cache: map(" + m.name + ")." + key + " is expired")
 	}
+	val, err = m.c.encode(val)
+	if err != nil {
+		return err
+	}
+	defer m.c.shrink()
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	m.data[key] = val
+	m.set(key, val)
 	return nil
 }
 
-func (m *memoryMap) GetObject(key string, valptr interface{}) error {
+func (m *memoryMap) GetObject(key string, valptr interface{}) (err error) {
+	defer m.c.st.read("GetObject", m.name, key, m.c.st.start(), &err)
 	if m.c.lget(m.name) == nil {
 		return ErrNil
 	}
@@ -410,25 +958,22 @@ func (m *memoryMap) GetObject(key string, valptr interface{}) error {
 	defer m.lock.RUnlock()
 
 	if value, ok := m.data[key]; ok {
-		v := reflect.ValueOf(value)
-		if v.Kind() == reflect.Ptr {
-			v = v.Elem()
-		}
-		reflect.ValueOf(valptr).Elem().Set(v)
-		return nil
+		m.access(key)
+		return m.c.decode(value, valptr)
 	}
 	return ErrNil
 
 }
 
-func (m *memoryMap) Delete(key string) error {
+func (m *memoryMap) Delete(key string) (err error) {
+	defer m.c.st.remove("Delete", m.name, key, m.c.st.start(), &err)
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	if _, ok := m.data[key]; !ok {
 		//return errors.New("key not exist")
 		return nil
 	}
-	delete(m.data, key)
+	m.unset(key)
 	if _, ok := m.data[key]; ok {
 		return errors.New("delete key error")
 	}
@@ -436,75 +981,45 @@ func (m *memoryMap) Delete(key string) error {
 }
 
 func (m *memoryMap) Incr(key string) error {
+	_, err := m.IncrBy(key, 1)
+	return err
+}
+
+func (m *memoryMap) Decr(key string) error {
+	_, err := m.IncrBy(key, -1)
+	return err
+}
+
+func (m *memoryMap) IncrBy(key string, delta int64) (int64, error) {
 	if m.c.lget(m.name) == nil {
-		return errors.New("cache: map(" + m.name + ")." + key + " is expired")
+		return 0, errors.New("cache: map(" + m.name + ")." + key + " is expired")
 	}
+	defer m.c.shrink()
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	value, ok := m.data[key]
-	if !ok {
-		value = 0
-	} else {
-		if value == nil {
-			value = 0
-		}
+	value, n, err := incrValue(m.data[key], delta)
+	if err != nil {
+		return 0, err
 	}
-
-	switch v := value.(type) {
-	case int:
-		value = v + 1
-	case int32:
-		value = v + 1
-	case int64:
-		value = v + 1
-	case uint:
-		value = v + 1
-	case uint32:
-		value = v + 1
-	case uint64:
-		value = v + 1
-	default:
-		return errors.New("item val is not (u)int (u)int32 (u)int64")
-	}
-	m.data[key] = value
-	return nil
+	m.set(key, value)
+	return n, nil
 }
 
-func (m *memoryMap) Decr(key string) error {
+func (m *memoryMap) IncrByFloat(key string, delta float64) (float64, error) {
 	if m.c.lget(m.name) == nil {
-		return errors.New("cache: map(" + m.name + ")." + key + " is expired")
+		return 0, errors.New("cache: map(" + m.name + ")." + key + " is expired")
 	}
+	defer m.c.shrink()
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	value, ok := m.data[key]
-	if !ok {
-		value = 0
-	} else {
-		if value == nil {
-			value = 0
-		}
-	}
-
-	switch v := value.(type) {
-	case int:
-		value = v - 1
-	case int64:
-		value = v - 1
-	case int32:
-		value = v - 1
-	case uint:
-		value = v - 1
-	case uint32:
-		value = v - 1
-	case uint64:
-		value = v - 1
-	default:
-		return errors.New("item val is not int int64 int32")
+	value, n, err := incrFloatValue(m.data[key], delta)
+	if err != nil {
+		return 0, err
 	}
-	m.data[key] = value
-	return nil
+	m.set(key, value)
+	return n, nil
 }
 
 func (m *memoryMap) Exists(key string) bool {
@@ -535,172 +1050,211 @@ func (m *memoryMap) Clear() error {
 	}
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	for _, n := range m.nodes {
+		m.c.ev.remove(n)
+	}
+	if m.nodes != nil {
+		m.nodes = make(map[string]*evictNode)
+	}
 	m.data = make(map[string]interface{})
 	return nil
 }
 
+func (m *memoryMap) PutMulti(vals map[string]string) error {
+	if m.c.lget(m.name) == nil {
+		return errors.New("cache: map(" + m.name + ") is expired")
+	}
+	defer m.c.shrink()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, val := range vals {
+		m.set(key, val)
+	}
+	return nil
+}
+
+func (m *memoryMap) GetAll() (map[string]string, error) {
+	if m.c.lget(m.name) == nil {
+		return nil, errors.New("cache: map(" + m.name + ") is expired")
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	vals := make(map[string]string, len(m.data))
+	for key, value := range m.data {
+		if value != nil {
+			vals[key] = valueString(value)
+		}
+	}
+	return vals, nil
+}
+
+func (m *memoryMap) Keys() ([]string, error) {
+	if m.c.lget(m.name) == nil {
+		return nil, errors.New("cache: map(" + m.name + ") is expired")
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+//Range 遍历调用时的数据快照, 调用fn时不持有锁
+func (m *memoryMap) Range(fn func(key, val string) bool) error {
+	vals, err := m.GetAll()
+	if err != nil {
+		return err
+	}
+	for key, val := range vals {
+		if !fn(key, val) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryMap) DeleteMulti(keys []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, key := range keys {
+		if _, ok := m.data[key]; ok {
+			m.unset(key)
+		}
+	}
+	return nil
+}
+
+//memory适配器的操作不会阻塞, context版本只在调用前检查ctx是否已结束
+func (c *memoryCache) PutCtx(ctx context.Context, key string, val string, expire ...time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Put(key, val, expire...)
+}
+func (c *memoryCache) GetCtx(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.Get(key)
+}
+func (c *memoryCache) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.GetMulti(keys)
+}
+func (c *memoryCache) PutObjectCtx(ctx context.Context, key string, val interface{}, expire ...time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.PutObject(key, val, expire...)
+}
+func (c *memoryCache) GetObjectCtx(ctx context.Context, key string, valptr interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.GetObject(key, valptr)
+}
+func (c *memoryCache) DeleteCtx(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Delete(key)
+}
+func (c *memoryCache) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.IncrBy(key, delta)
+}
+func (c *memoryCache) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.IncrByFloat(key, delta)
+}
+func (c *memoryCache) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return c.Exists(key), nil
+}
+func (c *memoryCache) SetExpireCtx(ctx context.Context, key string, expire ...time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.SetExpire(key, expire...)
+}
+
+func (m *memoryMap) PutCtx(ctx context.Context, key string, val string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.Put(key, val)
+}
+func (m *memoryMap) GetCtx(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return m.Get(key)
+}
+func (m *memoryMap) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.GetMulti(keys)
+}
+func (m *memoryMap) PutObjectCtx(ctx context.Context, key string, val interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.PutObject(key, val)
+}
+func (m *memoryMap) GetObjectCtx(ctx context.Context, key string, valptr interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.GetObject(key, valptr)
+}
+func (m *memoryMap) DeleteCtx(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.Delete(key)
+}
+func (m *memoryMap) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return m.IncrBy(key, delta)
+}
+func (m *memoryMap) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return m.IncrByFloat(key, delta)
+}
+func (m *memoryMap) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return m.Exists(key), nil
+}
+func (m *memoryMap) SizeCtx(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return m.Size()
+}
+func (m *memoryMap) ClearCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.Clear()
+}
+
 func init() {
 	Register("memory", NewMemoryCache)
 }
-
-//-------------------------------------------------------------------------------
-//type MemoryCache struct {
-//	maxEntries int
-//	ll         *list.List
-//	cache      map[string]*list.Element
-//	duration   time.Duration
-//	lock       sync.RWMutex
-//}
-
-//type entry struct {
-//	key     string
-//	value   interface{}
-//	timeout time.Time
-//}
-
-//func New(maxEntries int, duration time.Duration) *MemoryCache {
-//	var c = &MemoryCache{
-//		maxEntries: maxEntries,
-//		ll:         list.New(),
-//		cache:      make(map[string]*list.Element),
-//		duration:   duration,
-//	}
-//	if duration > 0 {
-//		go func() {
-//			for {
-//				var d = c.check()
-//				time.Sleep(d)
-//			}
-//		}()
-//	}
-//	return c
-//}
-
-//func (c *MemoryCache) check() time.Duration {
-//	var now = time.Now()
-//	c.lock.Lock()
-//	defer c.lock.Unlock()
-//	for {
-//		var ele = c.ll.Back()
-//		if ele == nil {
-//			return c.duration + 10*time.Second
-//		}
-//		var timeout = ele.Value.(*entry).timeout
-//		if now.Before(timeout) {
-//			var dur = timeout.Sub(now)
-//			if dur < 10*time.Second {
-//				dur = 10 * time.Second
-//			}
-//			return dur
-//		}
-//		c.removeElement(ele)
-//	}
-//}
-
-//func (c *MemoryCache) Put(key string, value interface{}, expire ...time.Duration) {
-//	c.lock.Lock()
-//	defer c.lock.Unlock()
-//	c.put(key, value)
-//}
-
-//func (c *MemoryCache) put(key string, value interface{}, expire ...time.Duration) {
-
-//	timeout := func() time.Time {
-//		if len(expire) > 0 {
-//			return time.Now().Add(expire[0])
-//		} else {
-//			return time.Now().Add(c.duration)
-//		}
-//	}
-
-//	if ee, ok := c.cache[key]; ok {
-//		c.ll.MoveToFront(ee)
-//		var v = ee.Value.(*entry)
-//		v.value = value
-//		v.timeout = timeout() //time.Now().Add(c.duration)
-//		return
-//	}
-//	//var ele = c.ll.PushFront(&entry{key, value, time.Now().Add(c.duration)})
-//	var ele = c.ll.PushFront(&entry{key, value, timeout()})
-//	c.cache[key] = ele
-
-//	if c.maxEntries == 0 {
-//		return
-//	}
-//	for c.ll.Len() > c.maxEntries {
-//		c.removeOldest()
-//	}
-//}
-
-//func (c *MemoryCache) isExpire(ele *list.Element) bool {
-//	return time.Now().After(ele.Value.(*entry).timeout)
-//}
-
-//func (c *MemoryCache) get(key string) interface{} {
-//	if ele, hit := c.cache[key]; hit && !c.isExpire(ele) {
-//		c.ll.MoveToFront(ele)
-//		return ele.Value.(*entry).value
-//	}
-//	return nil
-//}
-
-////func (c *MemoryCache) GetIfPresent(key string) interface{} {
-////	c.lock.Lock()
-////	defer c.lock.Unlock()
-
-////	return c.get(key)
-////}
-
-//func (c *MemoryCache) Exists(key string) bool {
-//	c.lock.RLock()
-//	defer c.lock.RUnlock()
-//	_, ok := c.cache[key]
-//	return ok
-//}
-
-//func (c *MemoryCache) Get(key string, setter ...func() interface{}) interface{} {
-//	c.lock.Lock()
-//	defer c.lock.Unlock()
-
-//	var v = c.get(key)
-//	if v != nil {
-//		return v
-//	}
-
-//	if len(setter) > 0 {
-//		v = setter[0]()
-//		if v != nil {
-//			c.put(key, v)
-//		}
-//	}
-//	return v
-//}
-
-//func (c *MemoryCache) Remove(key string) {
-//	c.lock.Lock()
-//	defer c.lock.Unlock()
-
-//	if ele, hit := c.cache[key]; hit {
-//		c.removeElement(ele)
-//	}
-//}
-
-//func (c *MemoryCache) removeOldest() {
-//	var ele = c.ll.Back()
-//	if ele != nil {
-//		c.removeElement(ele)
-//	}
-//}
-
-//func (c *MemoryCache) removeElement(e *list.Element) {
-//	c.ll.Remove(e)
-//	var kv = e.Value.(*entry)
-//	delete(c.cache, kv.key)
-//}
-
-//func (c *MemoryCache) Len() int {
-//	c.lock.Lock()
-//	defer c.lock.Unlock()
-
-//	return c.ll.Len()
-//}
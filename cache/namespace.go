@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+//WithNamespace 为c中的key和Map名称加上前缀prefix, 如"svc:", 用于多个服务共用同一个redis库
+//Keys, Scan返回的key不包含前缀
+func WithNamespace(c Cache, prefix string) Cache {
+	return &nsCache{c: c, prefix: prefix}
+}
+
+type nsCache struct {
+	c      Cache
+	prefix string
+}
+
+func (n *nsCache) key(key string) string {
+	return n.prefix + key
+}
+
+func (n *nsCache) keys(keys []string) []string {
+	nkeys := make([]string, len(keys))
+	for i, key := range keys {
+		nkeys[i] = n.prefix + key
+	}
+	return nkeys
+}
+
+func (n *nsCache) pattern(pattern string) string {
+	if pattern == "" {
+		pattern = "*"
+	}
+	return escapePattern(n.prefix) + pattern
+}
+
+func (n *nsCache) trim(keys []string) []string {
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, n.prefix)
+	}
+	return keys
+}
+
+func (n *nsCache) Put(key string, val string, expire ...time.Duration) error {
+	return n.c.Put(n.key(key), val, expire...)
+}
+func (n *nsCache) Get(key string) (string, error) {
+	return n.c.Get(n.key(key))
+}
+func (n *nsCache) GetMulti(keys []string) ([]string, error) {
+	return n.c.GetMulti(n.keys(keys))
+}
+func (n *nsCache) GetMultiHits(keys []string) ([]string, []bool, error) {
+	return GetMultiHits(n.c, n.keys(keys))
+}
+func (n *nsCache) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	return GetObjects(n.c, n.keys(keys), valptr)
+}
+func (n *nsCache) PutObject(key string, val interface{}, expire ...time.Duration) error {
+	return n.c.PutObject(n.key(key), val, expire...)
+}
+func (n *nsCache) GetObject(key string, valptr interface{}) error {
+	return n.c.GetObject(n.key(key), valptr)
+}
+func (n *nsCache) Delete(key string) error {
+	return n.c.Delete(n.key(key))
+}
+func (n *nsCache) Incr(key string) error {
+	return n.c.Incr(n.key(key))
+}
+func (n *nsCache) Decr(key string) error {
+	return n.c.Decr(n.key(key))
+}
+func (n *nsCache) IncrBy(key string, delta int64) (int64, error) {
+	return n.c.IncrBy(n.key(key), delta)
+}
+func (n *nsCache) IncrByFloat(key string, delta float64) (float64, error) {
+	return n.c.IncrByFloat(n.key(key), delta)
+}
+func (n *nsCache) Exists(key string) bool {
+	return n.c.Exists(n.key(key))
+}
+func (n *nsCache) SetExpire(key string, expire ...time.Duration) error {
+	return n.c.SetExpire(n.key(key), expire...)
+}
+func (n *nsCache) TTL(key string) (time.Duration, error) {
+	return TTL(n.c, n.key(key))
+}
+func (n *nsCache) NewMap(name string, expire ...time.Duration) (Map, error) {
+	return n.c.NewMap(n.key(name), expire...)
+}
+
+func (n *nsCache) Keys(pattern string) ([]string, error) {
+	keys, err := n.c.Keys(n.pattern(pattern))
+	return n.trim(keys), err
+}
+func (n *nsCache) Scan(cursor uint64, pattern string, count int) (uint64, []string, error) {
+	next, keys, err := n.c.Scan(cursor, n.pattern(pattern), count)
+	return next, n.trim(keys), err
+}
+func (n *nsCache) DeleteByPrefix(prefix string) (int, error) {
+	return n.c.DeleteByPrefix(n.key(prefix))
+}
+
+func (n *nsCache) PutIfAbsent(key string, val string, expire ...time.Duration) (bool, error) {
+	return PutIfAbsent(n.c, n.key(key), val, expire...)
+}
+func (n *nsCache) CompareAndSwap(key string, old, new string, expire ...time.Duration) (bool, error) {
+	return CompareAndSwap(n.c, n.key(key), old, new, expire...)
+}
+func (n *nsCache) GetAndDelete(key string) (string, error) {
+	return GetAndDelete(n.c, n.key(key))
+}
+
+func (n *nsCache) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	return Eval(n.c, script, n.keys(keys), args...)
+}
+
+func (n *nsCache) PutCtx(ctx context.Context, key string, val string, expire ...time.Duration) error {
+	return ContextOf(n.c).PutCtx(ctx, n.key(key), val, expire...)
+}
+func (n *nsCache) GetCtx(ctx context.Context, key string) (string, error) {
+	return ContextOf(n.c).GetCtx(ctx, n.key(key))
+}
+func (n *nsCache) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
+	return ContextOf(n.c).GetMultiCtx(ctx, n.keys(keys))
+}
+func (n *nsCache) PutObjectCtx(ctx context.Context, key string, val interface{}, expire ...time.Duration) error {
+	return ContextOf(n.c).PutObjectCtx(ctx, n.key(key), val, expire...)
+}
+func (n *nsCache) GetObjectCtx(ctx context.Context, key string, valptr interface{}) error {
+	return ContextOf(n.c).GetObjectCtx(ctx, n.key(key), valptr)
+}
+func (n *nsCache) DeleteCtx(ctx context.Context, key string) error {
+	return ContextOf(n.c).DeleteCtx(ctx, n.key(key))
+}
+func (n *nsCache) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	return ContextOf(n.c).IncrByCtx(ctx, n.key(key), delta)
+}
+func (n *nsCache) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	return ContextOf(n.c).IncrByFloatCtx(ctx, n.key(key), delta)
+}
+func (n *nsCache) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	return ContextOf(n.c).ExistsCtx(ctx, n.key(key))
+}
+func (n *nsCache) SetExpireCtx(ctx context.Context, key string, expire ...time.Duration) error {
+	return ContextOf(n.c).SetExpireCtx(ctx, n.key(key), expire...)
+}
+
+//nsLockBackend 为锁名称加上前缀
+type nsLockBackend struct {
+	b      lockBackend
+	prefix string
+}
+
+func (n *nsLockBackend) acquireLock(name, token string, ttl time.Duration) (bool, error) {
+	return n.b.acquireLock(n.prefix+name, token, ttl)
+}
+func (n *nsLockBackend) releaseLock(name, token string) (bool, error) {
+	return n.b.releaseLock(n.prefix+name, token)
+}
+func (n *nsLockBackend) refreshLock(name, token string, ttl time.Duration) (bool, error) {
+	return n.b.refreshLock(n.prefix+name, token, ttl)
+}
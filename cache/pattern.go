@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"strings"
+)
+
+//MatchPattern 按redis的glob语法匹配key, 语法同Cache.Keys, 供其它包中的适配器使用
+func MatchPattern(pattern, s string) bool {
+	return matchPattern(pattern, s)
+}
+
+//matchPattern 按redis的glob语法匹配key
+//*不匹配时只回到最后一个*多匹配一个字符, 其它语法都只匹配一个字符, 所以不需要回到更前面的*, 耗时与len(pattern)*len(s)成正比
+func matchPattern(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0 //最后一个*在pattern中的位置及其匹配到的s的位置
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			star, mark = p, i
+			p++
+			continue
+		}
+		if p < len(pattern) {
+			if next, ok := matchOne(pattern, p, s[i]); ok {
+				p, i = next, i+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		mark++
+		p, i = star+1, mark
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+//matchOne 匹配pattern[p]开始的一个字符的语法, 返回下一个语法的位置
+func matchOne(pattern string, p int, c byte) (int, bool) {
+	switch pattern[p] {
+	case '?':
+		return p + 1, true
+	case '[':
+		if end := classEnd(pattern, p+1); end >= 0 {
+			return end + 1, matchClass(pattern[p+1:end], c)
+		}
+		//没有结束的], 按普通字符处理
+		return p + 1, c == '['
+	case '\\':
+		if p+1 < len(pattern) {
+			p++
+		}
+	}
+	return p + 1, pattern[p] == c
+}
+
+//classEnd 返回from开始的第一个未转义的]的位置, 没有时返回-1
+func classEnd(pattern string, from int) int {
+	for j := from; j < len(pattern); j++ {
+		switch pattern[j] {
+		case '\\':
+			j++
+		case ']':
+			return j
+		}
+	}
+	return -1
+}
+
+//matchClass 匹配[]中的字符集合
+func matchClass(class string, c byte) bool {
+	not := false
+	if len(class) > 0 && class[0] == '^' {
+		not, class = true, class[1:]
+	}
+	match := false
+	for i := 0; i < len(class); i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			match = match || class[i] == c
+		case i+2 < len(class) && class[i+1] == '-':
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (c >= lo && c <= hi)
+			i += 2
+		default:
+			match = match || class[i] == c
+		}
+	}
+	return match != not
+}
+
+//escapePattern 转义s中的glob特殊字符, 用于按前缀匹配
+func escapePattern(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -1,56 +1,60 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/redisutil"
 )
 
 type redisCache struct {
-	p               *redis.Pool
-	addr            string
-	dbNum           int
-	password        string
-	maxIdleConns    int
-	connIdleTimeout int //秒, 连接空闲超时时间
-	//获取连接时，免ping时间，即此连接connIdleTimeout时间如果小于noTesttime，将不ping
-	noTesttime    time.Duration
+	p             *redisutil.Pool
 	defaultExpire int64 //秒，数据默认过期时间
+	codec         Codec //PutObject/GetObject的序列化方式
+	st            *statsCounter
 }
 
 func NewRedisCache() Cache {
-	return &redisCache{}
+	return &redisCache{codec: JSONCodec, st: newStatsCounter("redis")}
 }
 
 func (rc *redisCache) NewMap(name string, expire ...time.Duration) (Map, error) {
-	var timeout int64
-	if len(expire) > 0 {
-		timeout = int64(expire[0] / time.Second)
-	} else if rc.defaultExpire > 0 {
-		timeout = rc.defaultExpire
-	}
-
-	return &redisMap{rc: rc, name: name, expire: timeout}, nil
+	return &redisMap{rc: rc, name: name, expire: rc.expireMillis(expire...)}, nil
 }
 
 func (rc *redisCache) SetExpire(key string, expire ...time.Duration) error {
-	if len(expire) > 0 {
-		return rc.send("EXPIRE", key, int64(expire[0]/time.Second))
+	return rc.SetExpireCtx(context.Background(), key, expire...)
+}
+
+//SetExpireCtx expire<=0时与memory适配器相同, 不再过期
+func (rc *redisCache) SetExpireCtx(ctx context.Context, key string, expire ...time.Duration) error {
+	if len(expire) == 0 {
+		return nil
+	}
+	if px := rc.expireMillis(expire...); px > 0 {
+		return rc.sendCtx(ctx, "PEXPIRE", key, px)
 	}
-	return nil
+	return rc.sendCtx(ctx, "PERSIST", key)
 }
 
 func (rc *redisCache) Put(key string, val string, expire ...time.Duration) error {
-	if len(expire) > 0 {
-		return rc.send("SETEX", key, int64(expire[0]/time.Second), val)
-	} else if rc.defaultExpire > 0 {
-		return rc.send("SETEX", key, rc.defaultExpire, val)
-	} else {
-		return rc.send("SET", key, val)
+	return rc.PutCtx(context.Background(), key, val, expire...)
+}
+
+func (rc *redisCache) PutCtx(ctx context.Context, key string, val string, expire ...time.Duration) (err error) {
+	defer rc.st.write("Put", "", key, rc.st.start(), &err)
+	if px := rc.expireMillis(expire...); px > 0 {
+		return rc.sendCtx(ctx, "SET", key, val, "PX", px)
 	}
+	return rc.sendCtx(ctx, "SET", key, val)
 }
 
 func redisString(data interface{}, err error) (ret string, rerr error) {
@@ -62,15 +66,21 @@ func redisString(data interface{}, err error) (ret string, rerr error) {
 }
 
 func (rc *redisCache) Get(key string) (str string, err error) {
-	str, err = redisString(rc.do("GET", key))
+	return rc.GetCtx(context.Background(), key)
+}
+
+func (rc *redisCache) GetCtx(ctx context.Context, key string) (str string, err error) {
+	defer rc.st.read("Get", "", key, rc.st.start(), &err)
+	str, err = redisString(rc.doCtx(ctx, "GET", key))
 	return
 }
+
 func (rc *redisCache) GetMulti(keys []string) ([]string, error) {
-	args := []interface{}{}
-	for _, v := range keys {
-		args = append(args, v)
-	}
-	reply, err := redis.MultiBulk(rc.do("MGET", args...))
+	return rc.GetMultiCtx(context.Background(), keys)
+}
+
+func (rc *redisCache) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
+	reply, err := rc.mget(ctx, keys)
 	if err != nil {
 		return nil, err
 	}
@@ -82,21 +92,94 @@ func (rc *redisCache) GetMulti(keys []string) ([]string, error) {
 		}
 		list = append(list, s)
 	}
+	rc.st.countReply(reply)
 	return list, err
 }
 
+func (rc *redisCache) GetMultiHits(keys []string) ([]string, []bool, error) {
+	return rc.getMultiHitsCtx(context.Background(), keys)
+}
+
+func (rc *redisCache) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	return DecodeObjects(rc, rc.codec, keys, valptr)
+}
+
+func (rc *redisCache) getMultiHitsCtx(ctx context.Context, keys []string) ([]string, []bool, error) {
+	vals, hits, err := redisHits(rc.mget(ctx, keys))
+	rc.st.countHits(hits)
+	return vals, hits, err
+}
+
+//mget cluster模式按slot分组执行MGET, 结果按keys的顺序返回
+func (rc *redisCache) mget(ctx context.Context, keys []string) ([]interface{}, error) {
+	reply := make([]interface{}, len(keys))
+	for _, group := range rc.groups(keys) {
+		args := make([]interface{}, len(group))
+		for i, k := range group {
+			args[i] = keys[k]
+		}
+		vals, err := redis.Values(rc.doCtx(ctx, "MGET", args...))
+		if err != nil {
+			return nil, err
+		}
+		if len(vals) != len(group) {
+			return nil, errors.New("cache: invalid MGET reply")
+		}
+		for i, k := range group {
+			reply[k] = vals[i]
+		}
+	}
+	return reply, nil
+}
+
+//groups 多个key的命令在cluster模式按slot分组, 返回每组key的下标, 其它模式只有一组
+func (rc *redisCache) groups(keys []string) [][]int {
+	if rc.p.IsCluster() {
+		return redisutil.GroupBySlot(keys)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	group := make([]int, len(keys))
+	for i := range group {
+		group[i] = i
+	}
+	return [][]int{group}
+}
+
+//redisHits MGET/HMGET结果, nil表示不存在
+func redisHits(reply []interface{}, err error) ([]string, []bool, error) {
+	if err != nil {
+		return nil, nil, err
+	}
+	list := make([]string, len(reply))
+	hits := make([]bool, len(reply))
+	for i, v := range reply {
+		if v == nil {
+			continue
+		}
+		if list[i], err = redis.String(v, nil); err != nil {
+			return nil, nil, err
+		}
+		hits[i] = true
+	}
+	return list, hits, nil
+}
+
 func (rc *redisCache) PutObject(key string, val interface{}, expire ...time.Duration) error {
-	b, err := json.Marshal(val)
+	return rc.PutObjectCtx(context.Background(), key, val, expire...)
+}
+
+func (rc *redisCache) PutObjectCtx(ctx context.Context, key string, val interface{}, expire ...time.Duration) (err error) {
+	defer rc.st.write("PutObject", "", key, rc.st.start(), &err)
+	b, err := rc.codec.Marshal(val)
 	if err != nil {
 		return err
 	}
-	if len(expire) > 0 {
-		return rc.send("SETEX", key, int64(expire[0]/time.Second), b)
-	} else if rc.defaultExpire > 0 {
-		return rc.send("SETEX", key, rc.defaultExpire, b)
-	} else {
-		return rc.send("SET", key, b)
+	if px := rc.expireMillis(expire...); px > 0 {
+		return rc.sendCtx(ctx, "SET", key, b, "PX", px)
 	}
+	return rc.sendCtx(ctx, "SET", key, b)
 }
 
 func redisBytes(data interface{}, err error) (ret []byte, rerr error) {
@@ -108,15 +191,25 @@ func redisBytes(data interface{}, err error) (ret []byte, rerr error) {
 }
 
 func (rc *redisCache) GetObject(key string, objptr interface{}) error {
-	b, err := redisBytes(rc.do("GET", key))
+	return rc.GetObjectCtx(context.Background(), key, objptr)
+}
+
+func (rc *redisCache) GetObjectCtx(ctx context.Context, key string, objptr interface{}) (err error) {
+	defer rc.st.read("GetObject", "", key, rc.st.start(), &err)
+	b, err := redisBytes(rc.doCtx(ctx, "GET", key))
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(b, objptr)
+	return rc.codec.Unmarshal(b, objptr)
 }
 
 func (rc *redisCache) Delete(key string) error {
-	return rc.send("DEL", key)
+	return rc.DeleteCtx(context.Background(), key)
+}
+
+func (rc *redisCache) DeleteCtx(ctx context.Context, key string) (err error) {
+	defer rc.st.remove("Delete", "", key, rc.st.start(), &err)
+	return rc.sendCtx(ctx, "DEL", key)
 }
 
 func redisBool(data interface{}, err error) (ret bool, rerr error) {
@@ -128,144 +221,432 @@ func redisBool(data interface{}, err error) (ret bool, rerr error) {
 }
 
 func (rc *redisCache) Incr(key string) error {
-	_, err := redisBool(rc.do("INCRBY", key, 1))
+	_, err := rc.IncrBy(key, 1)
 	return err
 }
 func (rc *redisCache) Decr(key string) error {
-	_, err := redisBool(rc.do("INCRBY", key, -1))
+	_, err := rc.IncrBy(key, -1)
 	return err
 }
+func (rc *redisCache) IncrBy(key string, delta int64) (int64, error) {
+	return rc.IncrByCtx(context.Background(), key, delta)
+}
+func (rc *redisCache) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	return redis.Int64(rc.doCtx(ctx, "INCRBY", key, delta))
+}
+func (rc *redisCache) IncrByFloat(key string, delta float64) (float64, error) {
+	return rc.IncrByFloatCtx(context.Background(), key, delta)
+}
+func (rc *redisCache) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	return redis.Float64(rc.doCtx(ctx, "INCRBYFLOAT", key, delta))
+}
 func (rc *redisCache) Exists(key string) bool {
-	v, err := redis.Bool(rc.do("EXISTS", key))
+	v, _ := rc.ExistsCtx(context.Background(), key)
+	return v
+}
+func (rc *redisCache) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	return redis.Bool(rc.doCtx(ctx, "EXISTS", key))
+}
+
+//TTL 使用PTTL, 精度为毫秒
+func (rc *redisCache) TTL(key string) (time.Duration, error) {
+	ms, err := redis.Int64(rc.do("PTTL", key))
 	if err != nil {
-		return false
+		return 0, err
 	}
-	return v
+	switch {
+	case ms == -2:
+		return 0, ErrNil
+	case ms < 0:
+		return 0, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
 }
 
-func (rc *redisCache) send(cmd string, args ...interface{}) error {
-	red := rc.p.Get()
-	defer red.Close()
-	err := red.Send(cmd, args...)
+//expireMillis 取过期时间(毫秒), 未指定时使用默认过期时间, 不足1毫秒的过期时间为1毫秒
+func (rc *redisCache) expireMillis(expire ...time.Duration) int64 {
+	if len(expire) > 0 {
+		if expire[0] <= 0 {
+			return 0
+		}
+		return lockMillis(expire[0])
+	}
+	return rc.defaultExpire * 1000
+}
+
+func (rc *redisCache) PutIfAbsent(key string, val string, expire ...time.Duration) (bool, error) {
+	var reply interface{}
+	var err error
+	if px := rc.expireMillis(expire...); px > 0 {
+		reply, err = rc.do("SET", key, val, "PX", px, "NX")
+	} else {
+		reply, err = rc.do("SET", key, val, "NX")
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
-	return red.Flush()
+	return reply != nil, nil
 }
 
-func (rc *redisCache) do(cmd string, args ...interface{}) (interface{}, error) {
-	red := rc.p.Get()
-	defer red.Close()
-	return red.Do(cmd, args...)
+var casScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	if tonumber(ARGV[3]) > 0 then
+		redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+	else
+		redis.call('SET', KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0`)
+
+func (rc *redisCache) CompareAndSwap(key string, old, new string, expire ...time.Duration) (bool, error) {
+	return redis.Bool(rc.eval(key, func(red redis.Conn) (interface{}, error) {
+		return casScript.Do(red, key, old, new, rc.expireMillis(expire...))
+	}))
 }
 
-//config - {"addr":"", "password":"", "dbNum":"0", "maxIdleConns":"10", "connIdleTimeout":"300", "noTesttime":"60", "defaultExpire":""}
-//defaultExpire - 默认过期时间，秒
-func (rc *redisCache) Init(config string) error {
-	var cf map[string]string
-	json.Unmarshal([]byte(config), &cf)
+var getDelScript = redis.NewScript(1, `
+local v = redis.call('GET', KEYS[1])
+if v then
+	redis.call('DEL', KEYS[1])
+end
+return v`)
 
-	if _, ok := cf["addr"]; !ok {
-		return errors.New("config has no addr key")
+func (rc *redisCache) GetAndDelete(key string) (string, error) {
+	return redisString(rc.eval(key, func(red redis.Conn) (interface{}, error) {
+		return getDelScript.Do(red, key)
+	}))
+}
+
+//scripts Eval使用的脚本, key为脚本内容
+var scripts sync.Map
+
+func (rc *redisCache) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	s, ok := scripts.Load(script)
+	if !ok {
+		//keyCount为-1时key的数量作为第一个参数, 同一脚本可以使用不同数量的key
+		s, _ = scripts.LoadOrStore(script, redis.NewScript(-1, script))
 	}
-	if _, ok := cf["dbNum"]; !ok {
-		cf["dbNum"] = "0"
+	keysAndArgs := make([]interface{}, 0, len(keys)+len(args)+1)
+	keysAndArgs = append(keysAndArgs, len(keys))
+	for _, key := range keys {
+		keysAndArgs = append(keysAndArgs, key)
 	}
-	if _, ok := cf["password"]; !ok {
-		cf["password"] = ""
-	}
-
-	if _, ok := cf["maxIdleConns"]; !ok {
-		cf["maxIdleConns"] = "3"
+	keysAndArgs = append(keysAndArgs, args...)
+	var key string
+	if len(keys) > 0 {
+		key = keys[0]
 	}
+	return rc.eval(key, func(red redis.Conn) (interface{}, error) {
+		return s.(*redis.Script).Do(red, keysAndArgs...)
+	})
+}
 
-	if _, ok := cf["connIdleTimeout"]; !ok {
-		cf["connIdleTimeout"] = "300"
-	}
+//eval 在key所在节点上执行脚本
+func (rc *redisCache) eval(key string, fn func(red redis.Conn) (interface{}, error)) (interface{}, error) {
+	return rc.p.Exec(context.Background(), key, fn)
+}
 
-	if _, ok := cf["noTesttime"]; !ok {
-		cf["noTesttime"] = "60"
+func lockMillis(ttl time.Duration) int64 {
+	if ms := int64(ttl / time.Millisecond); ms > 0 {
+		return ms
 	}
+	return 1
+}
 
-	if _, ok := cf["defaultExpire"]; !ok {
-		cf["defaultExpire"] = "0"
+func (rc *redisCache) acquireLock(name, token string, ttl time.Duration) (bool, error) {
+	reply, err := rc.do("SET", name, token, "PX", lockMillis(ttl), "NX")
+	if err != nil {
+		return false, err
 	}
+	return reply != nil, nil
+}
 
-	rc.addr = cf["addr"]
-	rc.dbNum, _ = strconv.Atoi(cf["dbNum"])
-	rc.password = cf["password"]
-	rc.maxIdleConns, _ = strconv.Atoi(cf["maxIdleConns"])
-	rc.connIdleTimeout, _ = strconv.Atoi(cf["connIdleTimeout"])
-	noTesttime, _ := strconv.Atoi(cf["noTesttime"])
-	rc.noTesttime = time.Duration(noTesttime) * time.Second
-	rc.defaultExpire, _ = strconv.ParseInt(cf["defaultExpire"], 10, 0)
+var unlockScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
 
-	rc.connectInit()
+func (rc *redisCache) releaseLock(name, token string) (bool, error) {
+	return redis.Bool(rc.eval(name, func(red redis.Conn) (interface{}, error) {
+		return unlockScript.Do(red, name, token)
+	}))
+}
 
-	c := rc.p.Get()
-	defer c.Close()
+var refreshLockScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
 
-	return c.Err()
+func (rc *redisCache) refreshLock(name, token string, ttl time.Duration) (bool, error) {
+	return redis.Bool(rc.eval(name, func(red redis.Conn) (interface{}, error) {
+		return refreshLockScript.Do(red, name, token, lockMillis(ttl))
+	}))
 }
 
-func (rc *redisCache) connectInit() {
-	dialFunc := func() (c redis.Conn, err error) {
-		c, err = redis.Dial("tcp", rc.addr)
+func (rc *redisCache) Keys(pattern string) ([]string, error) {
+	var keys []string
+	var cursor uint64
+	for {
+		next, batch, err := rc.Scan(cursor, pattern, 1000)
 		if err != nil {
 			return nil, err
 		}
+		keys = append(keys, batch...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
 
-		if rc.password != "" {
-			if _, err := c.Do("AUTH", rc.password); err != nil {
-				c.Close()
-				return nil, err
-			}
+//scanNodeShift cluster模式cursor的高16位为节点序号, 低48位为该节点的cursor
+const scanNodeShift = 48
+
+//Scan cluster模式依次遍历各个master节点
+func (rc *redisCache) Scan(cursor uint64, pattern string, count int) (uint64, []string, error) {
+	nodes, err := rc.p.Nodes()
+	if err != nil {
+		return 0, nil, err
+	}
+	node := int(cursor >> scanNodeShift)
+	if node >= len(nodes) {
+		return 0, nil, nil
+	}
+	args := []interface{}{cursor & (1<<scanNodeShift - 1)}
+	if pattern != "" {
+		args = append(args, "MATCH", pattern)
+	}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	reply, err := redis.Values(rc.doNode(nodes[node], "SCAN", args...))
+	if err != nil {
+		return 0, nil, err
+	}
+	var next uint64
+	var keys []string
+	if _, err = redis.Scan(reply, &next, &keys); err != nil {
+		return 0, nil, err
+	}
+	if next == 0 {
+		if node++; node == len(nodes) {
+			return 0, keys, nil
 		}
+	}
+	return uint64(node)<<scanNodeShift | next, keys, nil
+}
 
-		_, selecterr := c.Do("SELECT", rc.dbNum)
-		if selecterr != nil {
-			c.Close()
-			return nil, selecterr
+//doNode 在addr节点上执行命令
+func (rc *redisCache) doNode(addr string, cmd string, args ...interface{}) (interface{}, error) {
+	red, err := rc.p.GetNode(context.Background(), addr)
+	if err != nil {
+		return nil, err
+	}
+	defer red.Close()
+	return red.Do(cmd, args...)
+}
+
+//DeleteByPrefix 通过SCAN分批查找并删除
+func (rc *redisCache) DeleteByPrefix(prefix string) (int, error) {
+	pattern := escapePattern(prefix) + "*"
+	var n int
+	var cursor uint64
+	for {
+		next, keys, err := rc.Scan(cursor, pattern, 1000)
+		if err != nil {
+			return n, err
 		}
-		return
+		for _, group := range rc.groups(keys) {
+			args := make([]interface{}, len(group))
+			for i, k := range group {
+				args[i] = keys[k]
+			}
+			deleted, err := redis.Int(rc.do("DEL", args...))
+			if err != nil {
+				return n, err
+			}
+			n += deleted
+		}
+		if next == 0 {
+			atomic.AddUint64(&rc.st.deletes, uint64(n))
+			return n, nil
+		}
+		cursor = next
 	}
+}
 
-	testOnBorrow := func(c redis.Conn, t time.Time) error {
-		if time.Now().Sub(t) < rc.noTesttime {
-			return nil
+func (rc *redisCache) SetObserver(o Observer) {
+	rc.st.setObserver(o)
+}
+
+//Stats Hits, Misses, Puts, Deletes为本客户端的统计数据
+//Evictions, Expirations, Bytes为redis服务器的统计数据(INFO命令), Items为当前库的数据条数(DBSIZE命令)
+//cluster模式为所有master节点的合计
+func (rc *redisCache) Stats() (Stats, error) {
+	st := rc.st.stats()
+	nodes, err := rc.p.Nodes()
+	if err != nil {
+		return st, err
+	}
+	for _, addr := range nodes {
+		info, err := redis.String(rc.doNode(addr, "INFO"))
+		if err != nil {
+			return st, err
 		}
-		_, err := c.Do("PING")
+		for _, line := range strings.Split(info, "\n") {
+			kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "evicted_keys":
+				n, _ := strconv.ParseUint(kv[1], 10, 64)
+				st.Evictions += n
+			case "expired_keys":
+				n, _ := strconv.ParseUint(kv[1], 10, 64)
+				st.Expirations += n
+			case "used_memory":
+				n, _ := strconv.ParseInt(kv[1], 10, 64)
+				st.Bytes += n
+			}
+		}
+		items, err := redis.Int64(rc.doNode(addr, "DBSIZE"))
 		if err != nil {
-			return err
+			return st, err
 		}
-		return nil
+		st.Items += items
+	}
+	return st, nil
+}
+
+func (rc *redisCache) send(cmd string, args ...interface{}) error {
+	return rc.sendCtx(context.Background(), cmd, args...)
+}
+
+func (rc *redisCache) do(cmd string, args ...interface{}) (interface{}, error) {
+	return rc.doCtx(context.Background(), cmd, args...)
+}
+
+//sendCtx ctx没有deadline时只发送命令不读取结果, 否则等待结果以便超时返回
+//sentinel和cluster模式等待结果, 以便处理重定向和故障切换
+func (rc *redisCache) sendCtx(ctx context.Context, cmd string, args ...interface{}) error {
+	if _, ok := ctx.Deadline(); ok || !rc.p.IsStandalone() {
+		_, err := rc.doCtx(ctx, cmd, args...)
+		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	red, err := rc.p.Get(ctx, "")
+	if err != nil {
+		return err
+	}
+	defer red.Close()
+	err = red.Send(cmd, args...)
+	if err != nil {
+		return err
+	}
+	return red.Flush()
+}
 
-	// initialize a new pool
-	rc.p = &redis.Pool{
-		MaxIdle:      rc.maxIdleConns,
-		IdleTimeout:  time.Duration(rc.connIdleTimeout) * time.Second,
-		Dial:         dialFunc,
-		TestOnBorrow: testOnBorrow,
+//doCtx ctx的deadline作为本条命令的读超时时间, 超时后返回ctx.Err()
+//args的第一个参数为key, 用于cluster模式选择节点
+func (rc *redisCache) doCtx(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	var key string
+	if len(args) > 0 {
+		key, _ = args[0].(string)
+	}
+	deadline, ok := ctx.Deadline()
+	reply, err := rc.p.Exec(ctx, key, func(red redis.Conn) (interface{}, error) {
+		if !ok {
+			return red.Do(cmd, args...)
+		}
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		return redis.DoWithTimeout(red, timeout, cmd, args...)
+	})
+	if err != nil && ok {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		//读超时时间即为ctx的deadline
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	return reply, err
+}
+
+//config - {"addr":"", "password":"", "dbNum":"0", "maxIdleConns":"10", "connIdleTimeout":"300", "noTesttime":"60", "defaultExpire":""}
+//连接相关的配置(mode, addr, username, password, TLS, 超时, 连接数等)见redisutil.ParseOptions
+//defaultExpire - 默认过期时间，秒
+//codec - PutObject的序列化方式, json, gob, msgpack, protobuf或RegisterCodec注册的名称, 默认：json
+//compress - 序列化后的压缩方式, gzip或snappy, 默认：不压缩
+//compressThreshold - 序列化后达到此字节数才压缩, 默认：0
+func (rc *redisCache) Init(config string) error {
+	var cf map[string]string
+	json.Unmarshal([]byte(config), &cf)
+
+	if _, ok := cf["addr"]; !ok {
+		return errors.New("config has no addr key")
+	}
+	if _, ok := cf["defaultExpire"]; !ok {
+		cf["defaultExpire"] = "0"
+	}
+
+	rc.defaultExpire, _ = strconv.ParseInt(cf["defaultExpire"], 10, 0)
+	compressThreshold, _ := strconv.Atoi(cf["compressThreshold"])
+	codec, err := NewCodec(cf["codec"], JSONCodec, cf["compress"], compressThreshold)
+	if err != nil {
+		return err
+	}
+	rc.codec = codec
+
+	if rc.p, err = redisutil.NewPoolConfig(cf); err != nil {
+		return err
+	}
+
+	c, err := rc.p.Get(context.Background(), "")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Err()
 }
 
 type redisMap struct {
 	rc     *redisCache
 	name   string
-	expire int64
+	expire int64 //毫秒, 0为不过期
+}
+
+func (m *redisMap) put(ctx context.Context, key string, val interface{}) error {
+	return m.hset(ctx, "HSET", m.name, key, val)
 }
 
-func (m *redisMap) put(key string, val interface{}) error {
+//hset 执行HSET或HMSET, Map新建时设置过期时间
+func (m *redisMap) hset(ctx context.Context, cmd string, args ...interface{}) error {
 	var err error
 	if m.expire == 0 {
-		err = m.rc.send("HSET", m.name, key, val)
+		err = m.rc.sendCtx(ctx, cmd, args...)
 	} else {
-		mapexist := m.rc.Exists(m.name)
-		err = m.rc.send("HSET", m.name, key, val)
+		var mapexist bool
+		if mapexist, err = m.rc.ExistsCtx(ctx, m.name); err != nil {
+			return err
+		}
+		err = m.rc.sendCtx(ctx, cmd, args...)
 		if err == nil {
 			if !mapexist {
-				err = m.rc.send("EXPIRE", m.name, m.expire)
+				err = m.rc.sendCtx(ctx, "PEXPIRE", m.name, m.expire)
 			}
 		}
 	}
@@ -273,19 +654,34 @@ func (m *redisMap) put(key string, val interface{}) error {
 }
 
 func (m *redisMap) Put(key string, val string) error {
-	return m.put(key, val)
+	return m.PutCtx(context.Background(), key, val)
+}
+
+func (m *redisMap) PutCtx(ctx context.Context, key string, val string) (err error) {
+	defer m.rc.st.write("Put", m.name, key, m.rc.st.start(), &err)
+	return m.put(ctx, key, val)
 }
 
 func (m *redisMap) Get(key string) (string, error) {
-	return redisString(m.rc.do("HGET", m.name, key))
+	return m.GetCtx(context.Background(), key)
+}
+
+func (m *redisMap) GetCtx(ctx context.Context, key string) (str string, err error) {
+	defer m.rc.st.read("Get", m.name, key, m.rc.st.start(), &err)
+	return redisString(m.rc.doCtx(ctx, "HGET", m.name, key))
 }
+
 func (m *redisMap) GetMulti(keys []string) ([]string, error) {
+	return m.GetMultiCtx(context.Background(), keys)
+}
+
+func (m *redisMap) GetMultiCtx(ctx context.Context, keys []string) ([]string, error) {
 	args := []interface{}{}
 	args = append(args, m.name)
 	for _, v := range keys {
 		args = append(args, v)
 	}
-	reply, err := redis.MultiBulk(m.rc.do("HMGET", args...))
+	reply, err := redis.MultiBulk(m.rc.doCtx(ctx, "HMGET", args...))
 	if err != nil {
 		return nil, err
 	}
@@ -298,75 +694,186 @@ func (m *redisMap) GetMulti(keys []string) ([]string, error) {
 		//s = strings.Trim(s, "\"")
 		list = append(list, s)
 	}
+	m.rc.st.countReply(reply)
 	return list, err
 }
 
+func (m *redisMap) GetMultiHits(keys []string) ([]string, []bool, error) {
+	return m.getMultiHitsCtx(context.Background(), keys)
+}
+
+func (m *redisMap) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	return DecodeObjects(m, m.rc.codec, keys, valptr)
+}
+
+func (m *redisMap) getMultiHitsCtx(ctx context.Context, keys []string) ([]string, []bool, error) {
+	args := []interface{}{}
+	args = append(args, m.name)
+	for _, v := range keys {
+		args = append(args, v)
+	}
+	vals, hits, err := redisHits(redis.Values(m.rc.doCtx(ctx, "HMGET", args...)))
+	m.rc.st.countHits(hits)
+	return vals, hits, err
+}
+
 func (m *redisMap) PutObject(key string, val interface{}) error {
-	b, err := json.Marshal(val)
+	return m.PutObjectCtx(context.Background(), key, val)
+}
+
+func (m *redisMap) PutObjectCtx(ctx context.Context, key string, val interface{}) (err error) {
+	defer m.rc.st.write("PutObject", m.name, key, m.rc.st.start(), &err)
+	b, err := m.rc.codec.Marshal(val)
 	if err != nil {
 		return err
 	}
-	return m.put(key, b)
+	return m.put(ctx, key, b)
 }
+
 func (m *redisMap) GetObject(key string, valptr interface{}) error {
-	b, err := redisBytes(m.rc.do("HGET", m.name, key))
+	return m.GetObjectCtx(context.Background(), key, valptr)
+}
+
+func (m *redisMap) GetObjectCtx(ctx context.Context, key string, valptr interface{}) (err error) {
+	defer m.rc.st.read("GetObject", m.name, key, m.rc.st.start(), &err)
+	b, err := redisBytes(m.rc.doCtx(ctx, "HGET", m.name, key))
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(b, valptr)
-
+	return m.rc.codec.Unmarshal(b, valptr)
 }
 
 func (m *redisMap) Delete(key string) error {
-	return m.rc.send("HDEL", m.name, key)
+	return m.DeleteCtx(context.Background(), key)
+}
+
+func (m *redisMap) DeleteCtx(ctx context.Context, key string) (err error) {
+	defer m.rc.st.remove("Delete", m.name, key, m.rc.st.start(), &err)
+	return m.rc.sendCtx(ctx, "HDEL", m.name, key)
 }
 
 func (m *redisMap) Incr(key string) error {
-	var err error
-	if m.expire == 0 {
-		_, err = redisBool(m.rc.do("HINCRBY", m.name, key, 1))
-	} else {
-		mapexist := m.rc.Exists(m.name)
-		_, err = redisBool(m.rc.do("HINCRBY", m.name, key, 1))
-		if err == nil {
-			if !mapexist {
-				err = m.rc.send("EXPIRE", m.name, m.expire)
-			}
-		}
-	}
+	_, err := m.IncrBy(key, 1)
 	return err
 }
 
 func (m *redisMap) Decr(key string) error {
-	var err error
+	_, err := m.IncrBy(key, -1)
+	return err
+}
+
+//incr 执行HINCRBY或HINCRBYFLOAT, Map新建时设置过期时间
+func (m *redisMap) incr(ctx context.Context, cmd string, key string, delta interface{}) (interface{}, error) {
 	if m.expire == 0 {
-		_, err = redisBool(m.rc.do("HINCRBY", m.name, key, -1))
-	} else {
-		mapexist := m.rc.Exists(m.name)
-		_, err = redisBool(m.rc.do("HINCRBY", m.name, key, -1))
-		if err == nil {
-			if !mapexist {
-				err = m.rc.send("EXPIRE", m.name, m.expire)
-			}
+		return m.rc.doCtx(ctx, cmd, m.name, key, delta)
+	}
+	mapexist, err := m.rc.ExistsCtx(ctx, m.name)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := m.rc.doCtx(ctx, cmd, m.name, key, delta)
+	if err == nil {
+		if !mapexist {
+			err = m.rc.sendCtx(ctx, "PEXPIRE", m.name, m.expire)
 		}
 	}
-	return err
+	return reply, err
+}
+
+func (m *redisMap) IncrBy(key string, delta int64) (int64, error) {
+	return m.IncrByCtx(context.Background(), key, delta)
+}
+
+func (m *redisMap) IncrByCtx(ctx context.Context, key string, delta int64) (int64, error) {
+	return redis.Int64(m.incr(ctx, "HINCRBY", key, delta))
+}
+
+func (m *redisMap) IncrByFloat(key string, delta float64) (float64, error) {
+	return m.IncrByFloatCtx(context.Background(), key, delta)
+}
+
+func (m *redisMap) IncrByFloatCtx(ctx context.Context, key string, delta float64) (float64, error) {
+	return redis.Float64(m.incr(ctx, "HINCRBYFLOAT", key, delta))
 }
 
 func (m *redisMap) Exists(key string) bool {
-	v, err := redis.Bool(m.rc.do("HEXISTS", m.name, key))
-	if err != nil {
-		return false
-	}
+	v, _ := m.ExistsCtx(context.Background(), key)
 	return v
 }
 
+func (m *redisMap) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	return redis.Bool(m.rc.doCtx(ctx, "HEXISTS", m.name, key))
+}
+
+func (m *redisMap) PutMulti(vals map[string]string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(vals)*2+1)
+	args = append(args, m.name)
+	for key, val := range vals {
+		args = append(args, key, val)
+	}
+	return m.hset(context.Background(), "HMSET", args...)
+}
+
+func (m *redisMap) GetAll() (map[string]string, error) {
+	return redis.StringMap(m.rc.do("HGETALL", m.name))
+}
+
+func (m *redisMap) Keys() ([]string, error) {
+	return redis.Strings(m.rc.do("HKEYS", m.name))
+}
+
+//Range 通过HSCAN分批遍历, 遍历期间修改的数据可能被跳过或重复返回
+func (m *redisMap) Range(fn func(key, val string) bool) error {
+	var cursor uint64
+	for {
+		reply, err := redis.Values(m.rc.do("HSCAN", m.name, cursor, "COUNT", 100))
+		if err != nil {
+			return err
+		}
+		var kvs []string
+		if _, err = redis.Scan(reply, &cursor, &kvs); err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(kvs); i += 2 {
+			if !fn(kvs[i], kvs[i+1]) {
+				return nil
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (m *redisMap) DeleteMulti(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, m.name)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return m.rc.send("HDEL", args...)
+}
+
 func (m *redisMap) Size() (int, error) {
-	return redis.Int(m.rc.do("HLEN", m.name))
+	return m.SizeCtx(context.Background())
+}
+
+func (m *redisMap) SizeCtx(ctx context.Context) (int, error) {
+	return redis.Int(m.rc.doCtx(ctx, "HLEN", m.name))
 }
 
 func (m *redisMap) Clear() error {
-	return m.rc.send("DEL", m.name)
+	return m.ClearCtx(context.Background())
+}
+
+func (m *redisMap) ClearCtx(ctx context.Context) error {
+	return m.rc.sendCtx(ctx, "DEL", m.name)
 }
 
 func init() {
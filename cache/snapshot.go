@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//Snapshotter 将缓存数据保存到快照文件, memory适配器配置snapshotPath后实现
+type Snapshotter interface {
+	Snapshot() error
+}
+
+const snapshotVersion = 1
+
+type snapshotHeader struct {
+	Version int
+	Created int64
+}
+
+//snapshotEntry 快照中的一条数据, Value为string, 数值或codecValue
+type snapshotEntry struct {
+	Key      string
+	Value    interface{}
+	IsMap    bool
+	Map      map[string]interface{} //gob不编码空map, 需通过IsMap区分
+	Deadline int64                  //过期时间, UnixNano, 0表示不过期
+}
+
+func init() {
+	gob.RegisterName("cache.codecValue", codecValue(nil))
+}
+
+//snapshotValue 返回可保存到快照的值, 未经codec序列化的对象不能保存
+func snapshotValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string, codecValue, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v, true
+	case *string:
+		return *v, true
+	default:
+		return nil, false
+	}
+}
+
+//Snapshot 保存快照, 先写入临时文件再改名, 保存过程中崩溃不会损坏已有的快照
+func (c *memoryCache) Snapshot() error {
+	if c.snapshotPath == "" {
+		return errors.New("cache: snapshotPath is not configured")
+	}
+	c.snapshotLock.Lock()
+	defer c.snapshotLock.Unlock()
+
+	f, err := ioutil.TempFile(filepath.Dir(c.snapshotPath), filepath.Base(c.snapshotPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err = c.writeSnapshot(f); err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp, c.snapshotPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (c *memoryCache) writeSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := gob.NewEncoder(bw)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Created: time.Now().UnixNano()}); err != nil {
+		return err
+	}
+	for _, s := range c.shards {
+		for _, e := range s.snapshot() {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+//snapshot 复制分片中未过期的数据, 编码在释放锁之后进行
+func (s *memoryShard) snapshot() []*snapshotEntry {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	entries := make([]*snapshotEntry, 0, len(s.items))
+	for key, itm := range s.items {
+		if itm.isExpire() {
+			continue
+		}
+		e := &snapshotEntry{Key: key}
+		if itm.expire > 0 {
+			e.Deadline = itm.deadline().UnixNano()
+		}
+		if m, ok := itm.value.(*memoryMap); ok {
+			e.IsMap, e.Map = true, m.snapshot()
+		} else if e.Value, ok = snapshotValue(itm.value); !ok {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func (m *memoryMap) snapshot() map[string]interface{} {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	data := make(map[string]interface{}, len(m.data))
+	for key, value := range m.data {
+		if v, ok := snapshotValue(value); ok {
+			data[key] = v
+		}
+	}
+	return data
+}
+
+//loadSnapshot 加载快照, 跳过已过期的数据
+//快照不存在时直接返回, 快照损坏时保留已加载的数据
+func (c *memoryCache) loadSnapshot() error {
+	f, err := os.Open(c.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer c.shrink()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	var h snapshotHeader
+	if err = dec.Decode(&h); err != nil {
+		return err
+	}
+	if h.Version != snapshotVersion {
+		return errors.New("cache: unknown snapshot version")
+	}
+	for {
+		var e snapshotEntry
+		if err = dec.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var expire time.Duration
+		if e.Deadline > 0 {
+			if expire = time.Until(time.Unix(0, e.Deadline)); expire <= 0 {
+				continue
+			}
+		}
+		if e.IsMap {
+			mp, _ := c.NewMap(e.Key, expire)
+			m := mp.(*memoryMap)
+			m.lock.Lock()
+			for key, v := range e.Map {
+				m.set(key, v)
+			}
+			m.lock.Unlock()
+			continue
+		}
+		s := c.shard(e.Key)
+		s.lock.Lock()
+		s.put(e.Key, e.Value, expire)
+		s.lock.Unlock()
+	}
+}
+
+//snapshotLoop 定期保存快照
+func (c *memoryCache) snapshotLoop(interval time.Duration) {
+	for {
+		<-time.After(interval)
+		if err := c.Snapshot(); err != nil {
+			log.Printf("cache: memory snapshot error, %v\n", err)
+		}
+	}
+}
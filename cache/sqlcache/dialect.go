@@ -0,0 +1,127 @@
+package sqlcache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//dialect 各数据库的语法差异
+type dialect struct {
+	quote     string //标识符引号
+	ddl       []string
+	forUpdate string //锁定读取的行, sqlite没有行锁, 由事务中第一条写入语句获得数据库的写锁
+	dollar    bool   //占位符为$1, $2...
+	//upsert 插入, 主键或唯一键冲突时更新cols, conflict为冲突的列
+	upsert func(d *dialect, table string, conflict, cols []string) string
+	//ignore 插入, 主键或唯一键冲突时不修改, 但仍锁定冲突的行
+	ignore func(d *dialect, table string, conflict, cols []string) string
+}
+
+var dialects = map[string]*dialect{
+	"mysql": {
+		quote: "`",
+		ddl: []string{
+			"CREATE TABLE IF NOT EXISTS %[1]s (`id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY, `key` VARCHAR(255) NOT NULL UNIQUE, `value` LONGBLOB, `is_map` TINYINT NOT NULL DEFAULT 0, `expires_at` BIGINT NOT NULL DEFAULT 0, KEY `%[3]s` (`expires_at`))",
+			"CREATE TABLE IF NOT EXISTS %[2]s (`map` VARCHAR(255) NOT NULL, `field` VARCHAR(255) NOT NULL, `value` LONGBLOB, PRIMARY KEY (`map`, `field`))",
+		},
+		forUpdate: " FOR UPDATE",
+		upsert: func(d *dialect, table string, conflict, cols []string) string {
+			sets := make([]string, len(cols))
+			for i, col := range cols {
+				sets[i] = fmt.Sprintf("%[1]s=VALUES(%[1]s)", d.q(col))
+			}
+			return d.insert(table, conflict, cols) + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+		},
+		ignore: func(d *dialect, table string, conflict, cols []string) string {
+			return d.insert(table, conflict, cols) + fmt.Sprintf(" ON DUPLICATE KEY UPDATE %[1]s=%[1]s", d.q(conflict[0]))
+		},
+	},
+	"sqlite": {
+		quote: `"`,
+		ddl: []string{
+			`CREATE TABLE IF NOT EXISTS %[1]s ("id" INTEGER PRIMARY KEY AUTOINCREMENT, "key" TEXT NOT NULL UNIQUE, "value" BLOB, "is_map" INTEGER NOT NULL DEFAULT 0, "expires_at" INTEGER NOT NULL DEFAULT 0)`,
+			`CREATE TABLE IF NOT EXISTS %[2]s ("map" TEXT NOT NULL, "field" TEXT NOT NULL, "value" BLOB, PRIMARY KEY ("map", "field"))`,
+			`CREATE INDEX IF NOT EXISTS "%[3]s" ON %[1]s ("expires_at")`,
+		},
+		upsert: onConflict,
+		ignore: onConflictIgnore,
+	},
+	"postgres": {
+		quote: `"`,
+		ddl: []string{
+			`CREATE TABLE IF NOT EXISTS %[1]s ("id" BIGSERIAL PRIMARY KEY, "key" VARCHAR(255) NOT NULL UNIQUE, "value" BYTEA, "is_map" SMALLINT NOT NULL DEFAULT 0, "expires_at" BIGINT NOT NULL DEFAULT 0)`,
+			`CREATE TABLE IF NOT EXISTS %[2]s ("map" VARCHAR(255) NOT NULL, "field" VARCHAR(255) NOT NULL, "value" BYTEA, PRIMARY KEY ("map", "field"))`,
+			`CREATE INDEX IF NOT EXISTS "%[3]s" ON %[1]s ("expires_at")`,
+		},
+		forUpdate: " FOR UPDATE",
+		dollar:    true,
+		upsert:    onConflict,
+		ignore:    onConflictIgnore,
+	},
+}
+
+//driverDialects 驱动名称对应的方言
+var driverDialects = map[string]string{
+	"mysql":    "mysql",
+	"mymysql":  "mysql",
+	"sqlite3":  "sqlite",
+	"sqlite":   "sqlite",
+	"postgres": "postgres",
+	"pgx":      "postgres",
+}
+
+//onConflict sqlite(3.24及以上)和postgres的upsert语法
+func onConflict(d *dialect, table string, conflict, cols []string) string {
+	sets := make([]string, len(cols))
+	for i, col := range cols {
+		sets[i] = fmt.Sprintf("%[1]s=excluded.%[1]s", d.q(col))
+	}
+	return d.insert(table, conflict, cols) + " ON CONFLICT (" + d.qs(conflict) + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+func onConflictIgnore(d *dialect, table string, conflict, cols []string) string {
+	return d.insert(table, conflict, cols) + " ON CONFLICT (" + d.qs(conflict) + ") DO NOTHING"
+}
+
+func (d *dialect) q(name string) string {
+	return d.quote + name + d.quote
+}
+
+func (d *dialect) qs(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = d.q(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (d *dialect) insert(table string, conflict, cols []string) string {
+	all := append(append([]string{}, conflict...), cols...)
+	return "INSERT INTO " + table + " (" + d.qs(all) + ") VALUES (" + placeholders(len(all)) + ")"
+}
+
+//rebind 将?替换为方言的占位符
+func (d *dialect) rebind(query string) string {
+	if !d.dollar {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteByte(query[i])
+		}
+	}
+	return b.String()
+}
+
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
@@ -0,0 +1,36 @@
+package sqlcache
+
+import (
+	"testing"
+)
+
+func Test_Dialect(t *testing.T) {
+	cols := []string{"value", "is_map", "expires_at"}
+	cases := map[string]string{
+		"mysql":    "INSERT INTO `cache` (`key`, `value`, `is_map`, `expires_at`) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE `value`=VALUES(`value`), `is_map`=VALUES(`is_map`), `expires_at`=VALUES(`expires_at`)",
+		"sqlite":   `INSERT INTO "cache" ("key", "value", "is_map", "expires_at") VALUES (?, ?, ?, ?) ON CONFLICT ("key") DO UPDATE SET "value"=excluded."value", "is_map"=excluded."is_map", "expires_at"=excluded."expires_at"`,
+		"postgres": `INSERT INTO "cache" ("key", "value", "is_map", "expires_at") VALUES ($1, $2, $3, $4) ON CONFLICT ("key") DO UPDATE SET "value"=excluded."value", "is_map"=excluded."is_map", "expires_at"=excluded."expires_at"`,
+	}
+	for name, want := range cases {
+		d := dialects[name]
+		if got := d.rebind(d.upsert(d, d.q("cache"), []string{"key"}, cols)); got != want {
+			t.Fatalf("%s upsert:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+
+	ignores := map[string]string{
+		"mysql":    "INSERT INTO `cache` (`key`, `value`, `is_map`, `expires_at`) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE `key`=`key`",
+		"sqlite":   `INSERT INTO "cache" ("key", "value", "is_map", "expires_at") VALUES (?, ?, ?, ?) ON CONFLICT ("key") DO NOTHING`,
+		"postgres": `INSERT INTO "cache" ("key", "value", "is_map", "expires_at") VALUES ($1, $2, $3, $4) ON CONFLICT ("key") DO NOTHING`,
+	}
+	for name, want := range ignores {
+		d := dialects[name]
+		if got := d.rebind(d.ignore(d, d.q("cache"), []string{"key"}, cols)); got != want {
+			t.Fatalf("%s ignore:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+
+	if got := likePrefix("a_b%c!"); got != "a!_b!%c!!%" {
+		t.Fatal("likePrefix", got)
+	}
+}
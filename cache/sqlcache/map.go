@@ -0,0 +1,280 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tryor/commons/cache"
+	"github.com/tryor/commons/dbutil"
+)
+
+//sqlMap 读取时关联table, 只返回未过期的Map中的数据
+type sqlMap struct {
+	c      *sqlCache
+	name   string
+	expire []time.Duration
+}
+
+//ensure 在事务中锁定Map, Map不存在或已过期时删除旧数据并重新创建
+//先锁定再判断是否需要删除, 并发的第一次写入依次执行, 不会删除先提交的事务写入的数据
+func (m *sqlMap) ensure(tx *sql.Tx) error {
+	c := m.c
+	_, isMap, _, err := c.lock(tx, m.name)
+	if err == nil && !isMap {
+		return cache.ErrWrongType
+	}
+	if err != cache.ErrNil {
+		return err
+	}
+	if _, err = c.exec(tx, fmt.Sprintf("DELETE FROM %s WHERE %s=?", c.mapTable, c.d.q("map")), m.name); err != nil {
+		return err
+	}
+	return c.upsert(tx, m.name, nil, 1, c.expiresAt(m.expire...))
+}
+
+func (m *sqlMap) put(tx *sql.Tx, key string, val []byte) error {
+	c := m.c
+	_, err := c.exec(tx, c.d.upsert(c.d, c.mapTable, []string{"map", "field"}, []string{"value"}), m.name, key, val)
+	return err
+}
+
+//write 在事务中创建Map并写入
+func (m *sqlMap) write(fn func(tx *sql.Tx) error) error {
+	return dbutil.Transaction(m.c.db, func(tx *sql.Tx) error {
+		if err := m.ensure(tx); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+//selectFields 返回读取Map数据的SQL, cond为附加条件
+func (m *sqlMap) selectFields(cols, cond string) string {
+	c := m.c
+	return fmt.Sprintf("SELECT %[1]s FROM %[2]s f JOIN %[3]s t ON t.%[4]s=f.%[5]s WHERE f.%[5]s=? AND t.%[6]s=1 AND %[7]s%[8]s",
+		cols, c.mapTable, c.table, c.d.q("key"), c.d.q("map"), c.d.q("is_map"), c.live("t"), cond)
+}
+
+//fields 读取Map数据, keys为空时读取全部
+func (m *sqlMap) fields(keys []string) (map[string][]byte, error) {
+	c := m.c
+	args := []interface{}{m.name, now()}
+	cond := ""
+	if len(keys) > 0 {
+		cond = fmt.Sprintf(" AND f.%s IN (%s)", c.d.q("field"), placeholders(len(keys)))
+		for _, key := range keys {
+			args = append(args, key)
+		}
+	}
+	rows, err := c.query(c.db, m.selectFields(fmt.Sprintf("f.%s, f.%s", c.d.q("field"), c.d.q("value")), cond), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	data := make(map[string][]byte)
+	for rows.Next() {
+		var key string
+		var val []byte
+		if err = rows.Scan(&key, &val); err != nil {
+			return nil, err
+		}
+		data[key] = val
+	}
+	return data, rows.Err()
+}
+
+func (m *sqlMap) get(key string) ([]byte, error) {
+	c := m.c
+	var val []byte
+	err := c.queryRow(c.db, m.selectFields("f."+c.d.q("value"), fmt.Sprintf(" AND f.%s=?", c.d.q("field"))), m.name, now(), key).Scan(&val)
+	if err == sql.ErrNoRows {
+		return nil, cache.ErrNil
+	}
+	return val, err
+}
+
+func (m *sqlMap) Put(key string, val string) error {
+	return m.write(func(tx *sql.Tx) error {
+		return m.put(tx, key, []byte(val))
+	})
+}
+
+func (m *sqlMap) Get(key string) (string, error) {
+	b, err := m.get(key)
+	return string(b), err
+}
+
+func (m *sqlMap) GetMulti(keys []string) ([]string, error) {
+	vals, _, err := m.GetMultiHits(keys)
+	return vals, err
+}
+
+func (m *sqlMap) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	return cache.DecodeObjects(m, m.c.codec, keys, valptr)
+}
+
+func (m *sqlMap) GetMultiHits(keys []string) ([]string, []bool, error) {
+	vals := make([]string, len(keys))
+	hits := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return vals, hits, nil
+	}
+	data, err := m.fields(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, key := range keys {
+		if val, ok := data[key]; ok {
+			vals[i], hits[i] = string(val), true
+		}
+	}
+	return vals, hits, nil
+}
+
+func (m *sqlMap) PutObject(key string, val interface{}) error {
+	b, err := m.c.codec.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return m.write(func(tx *sql.Tx) error {
+		return m.put(tx, key, b)
+	})
+}
+
+func (m *sqlMap) GetObject(key string, valptr interface{}) error {
+	b, err := m.get(key)
+	if err != nil {
+		return err
+	}
+	return m.c.codec.Unmarshal(b, valptr)
+}
+
+func (m *sqlMap) Delete(key string) error {
+	return m.DeleteMulti([]string{key})
+}
+
+func (m *sqlMap) Incr(key string) error {
+	_, err := m.IncrBy(key, 1)
+	return err
+}
+
+func (m *sqlMap) Decr(key string) error {
+	_, err := m.IncrBy(key, -1)
+	return err
+}
+
+//update 在事务中修改Map中key的值, ensure已锁定Map, 同一个Map的修改依次执行
+func (m *sqlMap) update(key string, fn func(val []byte) ([]byte, error)) error {
+	c := m.c
+	return m.write(func(tx *sql.Tx) error {
+		var val []byte
+		err := c.queryRow(tx, fmt.Sprintf("SELECT %s FROM %s WHERE %s=? AND %s=?%s", c.d.q("value"), c.mapTable, c.d.q("map"), c.d.q("field"), c.d.forUpdate),
+			m.name, key).Scan(&val)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if val, err = fn(val); err != nil {
+			return err
+		}
+		return m.put(tx, key, val)
+	})
+}
+
+func (m *sqlMap) IncrBy(key string, delta int64) (n int64, err error) {
+	err = m.update(key, func(val []byte) ([]byte, error) {
+		n, err = cache.IncrBytes(val, delta)
+		return []byte(strconv.FormatInt(n, 10)), err
+	})
+	return
+}
+
+func (m *sqlMap) IncrByFloat(key string, delta float64) (f float64, err error) {
+	err = m.update(key, func(val []byte) ([]byte, error) {
+		f, err = cache.IncrFloatBytes(val, delta)
+		return []byte(strconv.FormatFloat(f, 'f', -1, 64)), err
+	})
+	return
+}
+
+func (m *sqlMap) Exists(key string) bool {
+	_, err := m.get(key)
+	return err == nil
+}
+
+func (m *sqlMap) Size() (int, error) {
+	var n int
+	err := m.c.queryRow(m.c.db, m.selectFields("COUNT(*)", ""), m.name, now()).Scan(&n)
+	return n, err
+}
+
+//Clear 删除Map
+func (m *sqlMap) Clear() error {
+	return m.c.Delete(m.name)
+}
+
+func (m *sqlMap) PutMulti(vals map[string]string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	return m.write(func(tx *sql.Tx) error {
+		for key, val := range vals {
+			if err := m.put(tx, key, []byte(val)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *sqlMap) GetAll() (map[string]string, error) {
+	data, err := m.fields(nil)
+	if err != nil {
+		return nil, err
+	}
+	vals := make(map[string]string, len(data))
+	for key, val := range data {
+		vals[key] = string(val)
+	}
+	return vals, nil
+}
+
+func (m *sqlMap) Keys() ([]string, error) {
+	data, err := m.fields(nil)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+//Range 遍历读取时的数据, fn中可以修改Map
+func (m *sqlMap) Range(fn func(key, val string) bool) error {
+	data, err := m.fields(nil)
+	if err != nil {
+		return err
+	}
+	for key, val := range data {
+		if !fn(key, string(val)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *sqlMap) DeleteMulti(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	c := m.c
+	args := []interface{}{m.name}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	_, err := c.exec(c.db, fmt.Sprintf("DELETE FROM %s WHERE %s=? AND %s IN (%s)", c.mapTable, c.d.q("map"), c.d.q("field"), placeholders(len(keys))), args...)
+	return err
+}
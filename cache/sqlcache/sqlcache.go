@@ -0,0 +1,456 @@
+/*
+sqlcache 数据保存在数据库表中的缓存适配器, 适用于没有redis的部署
+使用前导入本包及数据库驱动:
+import (
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/tryor/commons/cache/sqlcache"
+)
+c, err := cache.NewCache("sql", `{"driver":"mysql", "dsn":"user:pwd@/db"}`)
+*/
+package sqlcache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tryor/commons/cache"
+	"github.com/tryor/commons/dbutil"
+)
+
+//querier *sql.DB和*sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//sqlCache 数据保存在table中, Map的名称也保存在table中(is_map=1), Map的数据保存在mapTable中
+//过期时间expires_at为UnixNano/1e6, 0表示不过期, 过期数据在读取时删除, 并由gc定期删除
+type sqlCache struct {
+	db            *sql.DB
+	d             *dialect
+	table         string //已加引号
+	mapTable      string //已加引号
+	gccyc         time.Duration
+	defaultExpire time.Duration //数据默认过期时间
+	codec         cache.Codec   //PutObject/GetObject的序列化方式
+}
+
+func NewSQLCache() cache.Cache {
+	return &sqlCache{codec: cache.JSONCodec}
+}
+
+type sqlConfig struct {
+	Driver        string `json:"driver"`
+	DSN           string `json:"dsn"`
+	Dialect       string `json:"dialect"`
+	Table         string `json:"table"`
+	MapTable      string `json:"mapTable"`
+	Gccyc         int    `json:"gccyc"`
+	DefaultExpire int    `json:"defaultExpire"`
+	Codec         string `json:"codec"`
+	Compress      string `json:"compress"`
+	CompressLimit int    `json:"compressThreshold"`
+}
+
+//config - {"driver":"mysql", "dsn":"user:pwd@/db", "table":"cache", "mapTable":"cache_map", "gccyc":600, "defaultExpire":0}
+//driver, dsn - 数据库驱动及连接串, 默认：dbutil.Driver, dbutil.DSN
+//dialect - mysql, sqlite或postgres, 默认：根据driver确定
+//table - 数据表, 不存在时自动创建, 默认：cache
+//mapTable - Map数据表, 不存在时自动创建, 默认：table_map
+//gccyc - 删除过期数据的周期, 秒, 默认：600, 0时不定期删除
+//defaultExpire - 默认过期时间，秒， 默认：0, 数据将不会过期
+//codec - PutObject的序列化方式, json, gob, msgpack, protobuf或RegisterCodec注册的名称, 默认：json
+//compress - 序列化后的压缩方式, gzip或snappy, 默认：不压缩
+//compressThreshold - 序列化后达到此字节数才压缩, 默认：0
+func (c *sqlCache) Init(config string) error {
+	cf := sqlConfig{Table: "cache", Gccyc: 600}
+	json.Unmarshal([]byte(config), &cf)
+	if cf.Driver == "" {
+		cf.Driver, cf.DSN = dbutil.Driver, dbutil.DSN
+	}
+	if cf.Dialect == "" {
+		cf.Dialect = driverDialects[cf.Driver]
+	}
+	d, ok := dialects[cf.Dialect]
+	if !ok {
+		return fmt.Errorf("cache: unknown sql dialect %q, driver is %q", cf.Dialect, cf.Driver)
+	}
+	if cf.MapTable == "" {
+		cf.MapTable = cf.Table + "_map"
+	}
+	codec, err := cache.NewCodec(cf.Codec, cache.JSONCodec, cf.Compress, cf.CompressLimit)
+	if err != nil {
+		return err
+	}
+
+	db, err := dbutil.OpenDB(cf.Driver, cf.DSN)
+	if err != nil {
+		return err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+	c.db = db
+	c.d = d
+	c.table = d.q(cf.Table)
+	c.mapTable = d.q(cf.MapTable)
+	c.codec = codec
+	c.gccyc = time.Duration(cf.Gccyc) * time.Second
+	c.defaultExpire = time.Duration(cf.DefaultExpire) * time.Second
+
+	for _, ddl := range d.ddl {
+		if _, err = db.Exec(fmt.Sprintf(ddl, c.table, c.mapTable, cf.Table+"_expires_at")); err != nil {
+			db.Close()
+			return err
+		}
+	}
+
+	go c.gc()
+
+	return nil
+}
+
+func (c *sqlCache) exec(q querier, query string, args ...interface{}) (sql.Result, error) {
+	return q.Exec(c.d.rebind(query), args...)
+}
+
+func (c *sqlCache) query(q querier, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.Query(c.d.rebind(query), args...)
+}
+
+func (c *sqlCache) queryRow(q querier, query string, args ...interface{}) *sql.Row {
+	return q.QueryRow(c.d.rebind(query), args...)
+}
+
+func now() int64 {
+	return time.Now().UnixNano() / 1e6
+}
+
+func (c *sqlCache) expiresAt(expire ...time.Duration) int64 {
+	var timeout time.Duration
+	if len(expire) > 0 {
+		timeout = expire[0]
+	} else if c.defaultExpire > 0 {
+		timeout = c.defaultExpire
+	}
+	if timeout <= 0 {
+		return 0
+	}
+	return time.Now().Add(timeout).UnixNano() / 1e6
+}
+
+//live 未过期的条件, 参数为now()
+func (c *sqlCache) live(table string) string {
+	return fmt.Sprintf("(%[1]s.%[2]s=0 OR %[1]s.%[2]s>?)", table, c.d.q("expires_at"))
+}
+
+func (c *sqlCache) upsert(q querier, key string, val []byte, isMap int, expiresAt int64) error {
+	_, err := c.exec(q, c.d.upsert(c.d, c.table, []string{"key"}, []string{"value", "is_map", "expires_at"}), key, val, isMap, expiresAt)
+	return err
+}
+
+//lock 在事务中锁定并读取key, 不存在或已过期时返回ErrNil, 返回后其它事务对key的写入等待本事务结束
+//key不存在时先插入一个已过期的行再锁定: sqlite的事务先读取再写入时需要升级锁, 不会等待busy_timeout而返回database is locked,
+//postgres及mysql的FOR UPDATE不能锁定不存在的行, 并发创建同一个key时会丢失更新
+func (c *sqlCache) lock(tx *sql.Tx, key string) (val []byte, isMap bool, expiresAt int64, err error) {
+	if _, err = c.exec(tx, c.d.ignore(c.d, c.table, []string{"key"}, []string{"value", "is_map", "expires_at"}), key, nil, 0, 1); err != nil {
+		return nil, false, 0, err
+	}
+	return c.entry(tx, key, true)
+}
+
+//entry 读取key, 不存在或已过期时返回ErrNil, 已过期的数据被删除
+func (c *sqlCache) entry(q querier, key string, lock bool) (val []byte, isMap bool, expiresAt int64, err error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s=?", c.d.qs([]string{"value", "is_map", "expires_at"}), c.table, c.d.q("key"))
+	if lock {
+		query += c.d.forUpdate
+	}
+	var m int
+	err = c.queryRow(q, query, key).Scan(&val, &m, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, false, 0, cache.ErrNil
+	}
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if expiresAt > 0 && expiresAt <= now() {
+		c.purge(q, key, expiresAt)
+		return nil, false, 0, cache.ErrNil
+	}
+	return val, m == 1, expiresAt, nil
+}
+
+//purge 删除已过期的key, Map的数据由gc删除
+func (c *sqlCache) purge(q querier, key string, expiresAt int64) {
+	c.exec(q, fmt.Sprintf("DELETE FROM %s WHERE %s=? AND %s=?", c.table, c.d.q("key"), c.d.q("expires_at")), key, expiresAt)
+}
+
+func (c *sqlCache) get(key string) ([]byte, error) {
+	val, isMap, _, err := c.entry(c.db, key, false)
+	if err != nil {
+		return nil, err
+	}
+	if isMap {
+		return nil, cache.ErrWrongType
+	}
+	return val, nil
+}
+
+func (c *sqlCache) Put(key string, val string, expire ...time.Duration) error {
+	return c.upsert(c.db, key, []byte(val), 0, c.expiresAt(expire...))
+}
+
+func (c *sqlCache) Get(key string) (string, error) {
+	b, err := c.get(key)
+	return string(b), err
+}
+
+func (c *sqlCache) GetMulti(keys []string) ([]string, error) {
+	vals, _, err := c.GetMultiHits(keys)
+	return vals, err
+}
+
+func (c *sqlCache) GetObjects(keys []string, valptr func(i int) interface{}) ([]bool, error) {
+	return cache.DecodeObjects(c, c.codec, keys, valptr)
+}
+
+func (c *sqlCache) GetMultiHits(keys []string) ([]string, []bool, error) {
+	vals := make([]string, len(keys))
+	hits := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return vals, hits, nil
+	}
+	args := make([]interface{}, 0, len(keys)+1)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	args = append(args, now())
+	rows, err := c.query(c.db, fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IN (%s) AND %s=0 AND %s",
+		c.d.q("key"), c.d.q("value"), c.table, c.d.q("key"), placeholders(len(keys)), c.d.q("is_map"), c.live(c.table)), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	found := make(map[string]string, len(keys))
+	for rows.Next() {
+		var key string
+		var val []byte
+		if err = rows.Scan(&key, &val); err != nil {
+			return nil, nil, err
+		}
+		found[key] = string(val)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for i, key := range keys {
+		vals[i], hits[i] = found[key]
+	}
+	return vals, hits, nil
+}
+
+func (c *sqlCache) PutObject(key string, val interface{}, expire ...time.Duration) error {
+	b, err := c.codec.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.upsert(c.db, key, b, 0, c.expiresAt(expire...))
+}
+
+func (c *sqlCache) GetObject(key string, valptr interface{}) error {
+	b, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(b, valptr)
+}
+
+func (c *sqlCache) Delete(key string) error {
+	return dbutil.Transaction(c.db, func(tx *sql.Tx) error {
+		if _, err := c.exec(tx, fmt.Sprintf("DELETE FROM %s WHERE %s=?", c.table, c.d.q("key")), key); err != nil {
+			return err
+		}
+		_, err := c.exec(tx, fmt.Sprintf("DELETE FROM %s WHERE %s=?", c.mapTable, c.d.q("map")), key)
+		return err
+	})
+}
+
+func (c *sqlCache) Incr(key string) error {
+	_, err := c.IncrBy(key, 1)
+	return err
+}
+
+func (c *sqlCache) Decr(key string) error {
+	_, err := c.IncrBy(key, -1)
+	return err
+}
+
+//update 在事务中锁定并修改key的值, key不存在时以nil调用fn并创建, 过期时间保持不变
+func (c *sqlCache) update(key string, fn func(val []byte) ([]byte, error)) error {
+	return dbutil.Transaction(c.db, func(tx *sql.Tx) error {
+		val, isMap, expiresAt, err := c.lock(tx, key)
+		if err != nil && err != cache.ErrNil {
+			return err
+		}
+		if isMap {
+			return cache.ErrWrongType
+		}
+		if val, err = fn(val); err != nil {
+			return err
+		}
+		return c.upsert(tx, key, val, 0, expiresAt)
+	})
+}
+
+func (c *sqlCache) IncrBy(key string, delta int64) (n int64, err error) {
+	err = c.update(key, func(val []byte) ([]byte, error) {
+		n, err = cache.IncrBytes(val, delta)
+		return []byte(strconv.FormatInt(n, 10)), err
+	})
+	return
+}
+
+func (c *sqlCache) IncrByFloat(key string, delta float64) (f float64, err error) {
+	err = c.update(key, func(val []byte) ([]byte, error) {
+		f, err = cache.IncrFloatBytes(val, delta)
+		return []byte(strconv.FormatFloat(f, 'f', -1, 64)), err
+	})
+	return
+}
+
+func (c *sqlCache) Exists(key string) bool {
+	_, _, _, err := c.entry(c.db, key, false)
+	return err == nil
+}
+
+func (c *sqlCache) TTL(key string) (time.Duration, error) {
+	_, _, expiresAt, err := c.entry(c.db, key, false)
+	if err != nil || expiresAt == 0 {
+		return 0, err
+	}
+	if ttl := time.Duration(expiresAt-now()) * time.Millisecond; ttl > 0 {
+		return ttl, nil
+	}
+	return 0, cache.ErrNil
+}
+
+//SetExpire 重新设置过期时间, key不存在时忽略
+func (c *sqlCache) SetExpire(key string, expire ...time.Duration) error {
+	if len(expire) == 0 {
+		return nil
+	}
+	_, err := c.exec(c.db, fmt.Sprintf("UPDATE %s SET %s=? WHERE %s=? AND %s", c.table, c.d.q("expires_at"), c.d.q("key"), c.live(c.table)),
+		c.expiresAt(expire...), key, now())
+	return err
+}
+
+//NewMap Map在第一次写入时创建, 并设置过期时间
+func (c *sqlCache) NewMap(name string, expire ...time.Duration) (cache.Map, error) {
+	return &sqlMap{c: c, name: name, expire: expire}, nil
+}
+
+func (c *sqlCache) Keys(pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	rows, err := c.query(c.db, fmt.Sprintf("SELECT %s FROM %s WHERE %s", c.d.q("key"), c.table, c.live(c.table)), now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if cache.MatchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, rows.Err()
+}
+
+//Scan cursor为上次返回的最大id, 按id顺序遍历, 每次读取count行
+func (c *sqlCache) Scan(cursor uint64, pattern string, count int) (uint64, []string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	if count <= 0 {
+		count = 10
+	}
+	rows, err := c.query(c.db, fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s>? AND %s ORDER BY %s LIMIT %d",
+		c.d.q("id"), c.d.q("key"), c.table, c.d.q("id"), c.live(c.table), c.d.q("id"), count), int64(cursor), now())
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	n := 0
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&cursor, &key); err != nil {
+			return 0, nil, err
+		}
+		if n++; cache.MatchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	if n < count {
+		cursor = 0
+	}
+	return cursor, keys, nil
+}
+
+//likePrefix 返回匹配prefix开头的LIKE参数, 转义字符为!
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return r.Replace(prefix) + "%"
+}
+
+func (c *sqlCache) DeleteByPrefix(prefix string) (n int, err error) {
+	like := likePrefix(prefix)
+	err = dbutil.Transaction(c.db, func(tx *sql.Tx) error {
+		res, err := c.exec(tx, fmt.Sprintf("DELETE FROM %s WHERE %s LIKE ? ESCAPE '!' AND %s", c.table, c.d.q("key"), c.live(c.table)), like, now())
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		n = int(rows)
+		_, err = c.exec(tx, fmt.Sprintf("DELETE FROM %s WHERE %s LIKE ? ESCAPE '!'", c.mapTable, c.d.q("map")), like)
+		return err
+	})
+	if err != nil {
+		n = 0
+	}
+	return
+}
+
+//gc 删除过期数据及没有对应Map的数据
+func (c *sqlCache) gc() {
+	if (c.gccyc / time.Second) < 1 {
+		return
+	}
+	for {
+		<-time.After(c.gccyc)
+		c.exec(c.db, fmt.Sprintf("DELETE FROM %s WHERE %s>0 AND %s<=?", c.table, c.d.q("expires_at"), c.d.q("expires_at")), now())
+		c.exec(c.db, fmt.Sprintf("DELETE FROM %[1]s WHERE NOT EXISTS (SELECT 1 FROM %[2]s WHERE %[2]s.%[3]s=%[1]s.%[4]s AND %[2]s.%[5]s=1)",
+			c.mapTable, c.table, c.d.q("key"), c.d.q("map"), c.d.q("is_map")))
+	}
+}
+
+func init() {
+	cache.Register("sql", NewSQLCache)
+}
@@ -0,0 +1,203 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tryor/commons/cache"
+)
+
+//newTestCache 使用已注册的sqlite驱动在临时目录中创建缓存, 没有注册sqlite驱动时跳过
+func newTestCache(t *testing.T) cache.Cache {
+	var driver string
+	for _, name := range sql.Drivers() {
+		if driverDialects[name] == "sqlite" {
+			driver = name
+		}
+	}
+	if driver == "" {
+		t.Skip("no sqlite driver registered")
+	}
+	dir, err := ioutil.TempDir("", "sqlcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	dsn := "file:" + filepath.Join(dir, "cache.db")
+	if driver == "sqlite3" {
+		dsn += "?_busy_timeout=5000"
+	} else {
+		dsn += "?_pragma=busy_timeout(5000)"
+	}
+	c, err := cache.NewCache("sql", `{"driver":"`+driver+`", "dsn":"`+dsn+`", "gccyc":0}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Test_PutGet(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get("k1"); err != nil || v != "v1" {
+		t.Fatal(v, err)
+	}
+	if _, err := c.Get("k2"); err != cache.ErrNil {
+		t.Fatal("k2", err)
+	}
+	c.Put("k1", "v2")
+	vals, hits, err := cache.GetMultiHits(c, []string{"k1", "k2"})
+	if err != nil || vals[0] != "v2" || !hits[0] || hits[1] {
+		t.Fatal(vals, hits, err)
+	}
+
+	type V struct{ A, B int }
+	if err = c.PutObject("o1", &V{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	var v V
+	if err = c.GetObject("o1", &v); err != nil || v.B != 2 {
+		t.Fatal(v, err)
+	}
+
+	if err = c.Delete("k1"); err != nil || c.Exists("k1") {
+		t.Fatal("Delete", err)
+	}
+	keys, err := c.Keys("o*")
+	if err != nil || len(keys) != 1 || keys[0] != "o1" {
+		t.Fatal(keys, err)
+	}
+}
+
+func Test_Expire(t *testing.T) {
+	c := newTestCache(t)
+	c.Put("e1", "v1", time.Millisecond*50)
+	c.Put("e2", "v2")
+	c.SetExpire("e2", time.Millisecond*50)
+	if !c.Exists("e1") || !c.Exists("e2") {
+		t.Fatal("e1, e2 not exist")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if _, err := c.Get("e1"); err != cache.ErrNil {
+		t.Fatal("e1", err)
+	}
+	if c.Exists("e2") {
+		t.Fatal("e2 not expired")
+	}
+	//过期后IncrBy从0开始, 且不再过期
+	if n, err := c.IncrBy("e1", 2); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+}
+
+func Test_IncrBy(t *testing.T) {
+	c := newTestCache(t)
+	if n, err := c.IncrBy("n", 5); err != nil || n != 5 {
+		t.Fatal(n, err)
+	}
+	if n, err := c.IncrBy("n", -2); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	if f, err := c.IncrByFloat("n", 0.5); err != nil || f != 3.5 {
+		t.Fatal(f, err)
+	}
+	if _, err := c.IncrBy("n", 1); err == nil {
+		t.Fatal("3.5 is not an integer")
+	}
+	m, _ := c.NewMap("m")
+	m.Put("f", "v")
+	if _, err := c.IncrBy("m", 1); err != cache.ErrWrongType {
+		t.Fatal("IncrBy map", err)
+	}
+}
+
+func Test_Map(t *testing.T) {
+	c := newTestCache(t)
+	m, err := c.NewMap("m1", time.Millisecond*100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.PutMulti(map[string]string{"f1": "v1", "f2": "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.IncrBy("f3", 2); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	if v, err := m.Get("f1"); err != nil || v != "v1" {
+		t.Fatal(v, err)
+	}
+	if n, err := m.Size(); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	m.DeleteMulti([]string{"f2"})
+	all, err := m.GetAll()
+	if err != nil || len(all) != 2 || all["f3"] != "2" {
+		t.Fatal(all, err)
+	}
+	if _, err = c.Get("m1"); err != cache.ErrWrongType {
+		t.Fatal("Get map", err)
+	}
+
+	//过期后重新创建, 旧数据被删除
+	time.Sleep(time.Millisecond * 150)
+	if m.Exists("f1") {
+		t.Fatal("f1 not expired")
+	}
+	m.Put("f4", "v4")
+	keys, err := m.Keys()
+	if err != nil || len(keys) != 1 || keys[0] != "f4" {
+		t.Fatal(keys, err)
+	}
+
+	if err = m.Clear(); err != nil || m.Exists("f4") {
+		t.Fatal("Clear", err)
+	}
+}
+
+func Test_ConcurrentWriters(t *testing.T) {
+	c := newTestCache(t)
+	m, _ := c.NewMap("pm")
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n*3)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := c.IncrBy("par", 1); err != nil {
+				errs <- err
+			}
+			if err := m.Put("f"+strconv.Itoa(i), "v"); err != nil {
+				errs <- err
+			}
+			if _, err := m.IncrBy("count", 1); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get("par"); v != strconv.Itoa(n) {
+		t.Fatal("par", v)
+	}
+	keys, _ := m.Keys()
+	sort.Strings(keys)
+	if len(keys) != n+1 {
+		t.Fatal("keys", keys)
+	}
+	if v, _ := m.Get("count"); v != strconv.Itoa(n) {
+		t.Fatal("count", v)
+	}
+}
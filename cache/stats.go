@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//Stats 缓存统计数据
+type Stats struct {
+	Hits        uint64 //读取命中次数
+	Misses      uint64 //读取未命中次数
+	Puts        uint64 //写入次数
+	Deletes     uint64 //删除次数
+	Evictions   uint64 //因超出容量限制被淘汰的数据条数
+	Expirations uint64 //因过期被删除的数据条数
+	Items       int64  //当前数据条数, Map计为一条
+	Bytes       int64  //当前数据占用字节数(估算值)
+	//多级缓存各级的统计数据, 从第一级开始
+	Levels []Stats `json:",omitempty"`
+}
+
+//StatsGetter 适配器可选实现
+type StatsGetter interface {
+	Stats() (Stats, error)
+}
+
+//GetStats 返回c的统计数据, c未实现StatsGetter时返回ErrNotSupported
+func GetStats(c Cache) (Stats, error) {
+	if sg, ok := c.(StatsGetter); ok {
+		return sg.Stats()
+	}
+	return Stats{}, ErrNotSupported
+}
+
+//Operation 一次缓存操作, 操作完成后通过Observer回调
+type Operation struct {
+	Adapter  string        //memory, redis或l2
+	Level    int           //在多级缓存中的级别, 从1开始, 0表示单独使用的缓存或多级缓存本身
+	Name     string        //操作名称, 如Get, PutObject, Delete
+	Map      string        //Map操作时为Map名称
+	Key      string        //操作的key
+	Hit      bool          //读操作是否命中
+	Duration time.Duration //操作耗时
+	Err      error         //操作错误, 未命中不作为错误
+}
+
+//Observer 观察缓存操作, 用于导出耗时等指标, Observe在操作的goroutine中同步调用
+type Observer interface {
+	Observe(op *Operation)
+}
+
+type ObserverFunc func(op *Operation)
+
+func (f ObserverFunc) Observe(op *Operation) {
+	f(op)
+}
+
+//Observable 适配器可选实现
+type Observable interface {
+	//设置观察者, o为nil时取消
+	SetObserver(o Observer)
+}
+
+//SetObserver 为c设置观察者, 多级缓存同时为各级设置, 并通过Operation.Level区分
+//c未实现Observable时返回ErrNotSupported
+func SetObserver(c Cache, o Observer) error {
+	if oc, ok := c.(Observable); ok {
+		oc.SetObserver(o)
+		return nil
+	}
+	return ErrNotSupported
+}
+
+//levelObserver 为多级缓存中各级的操作设置Level
+type levelObserver struct {
+	o     Observer
+	level int
+}
+
+func (lo *levelObserver) Observe(op *Operation) {
+	op.Level = lo.level
+	lo.o.Observe(op)
+}
+
+type observerBox struct {
+	o Observer
+}
+
+//statsCounter 适配器共用的操作计数及观察者
+type statsCounter struct {
+	hits     uint64
+	misses   uint64
+	puts     uint64
+	deletes  uint64
+	adapter  string
+	observer atomic.Value //observerBox
+}
+
+func newStatsCounter(adapter string) *statsCounter {
+	st := &statsCounter{adapter: adapter}
+	st.observer.Store(observerBox{})
+	return st
+}
+
+func (st *statsCounter) setObserver(o Observer) {
+	st.observer.Store(observerBox{o})
+}
+
+func (st *statsCounter) observerOf() Observer {
+	return st.observer.Load().(observerBox).o
+}
+
+//start 返回操作开始时间, 没有观察者时不取当前时间
+func (st *statsCounter) start() time.Time {
+	if st.observerOf() == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+//read, write, remove 在操作结束时调用, 一般通过defer调用:
+//	defer c.st.read("Get", "", key, c.st.start(), &err)
+func (st *statsCounter) read(name, mapName, key string, start time.Time, err *error) {
+	hit := *err == nil
+	if hit {
+		atomic.AddUint64(&st.hits, 1)
+	} else if *err == ErrNil {
+		atomic.AddUint64(&st.misses, 1)
+	}
+	st.notify(name, mapName, key, hit, start, *err)
+}
+
+func (st *statsCounter) write(name, mapName, key string, start time.Time, err *error) {
+	if *err == nil {
+		atomic.AddUint64(&st.puts, 1)
+	}
+	st.notify(name, mapName, key, false, start, *err)
+}
+
+func (st *statsCounter) remove(name, mapName, key string, start time.Time, err *error) {
+	if *err == nil {
+		atomic.AddUint64(&st.deletes, 1)
+	}
+	st.notify(name, mapName, key, false, start, *err)
+}
+
+//countHits 批量读取时记录命中及未命中次数
+func (st *statsCounter) countHits(hits []bool) {
+	for _, hit := range hits {
+		if hit {
+			atomic.AddUint64(&st.hits, 1)
+		} else {
+			atomic.AddUint64(&st.misses, 1)
+		}
+	}
+}
+
+//countReply MGET/HMGET结果, nil表示未命中
+func (st *statsCounter) countReply(reply []interface{}) {
+	for _, v := range reply {
+		if v != nil {
+			atomic.AddUint64(&st.hits, 1)
+		} else {
+			atomic.AddUint64(&st.misses, 1)
+		}
+	}
+}
+
+func (st *statsCounter) notify(name, mapName, key string, hit bool, start time.Time, err error) {
+	o := st.observerOf()
+	if o == nil {
+		return
+	}
+	if err == ErrNil {
+		err = nil
+	}
+	var d time.Duration
+	if !start.IsZero() {
+		d = time.Since(start)
+	}
+	o.Observe(&Operation{Adapter: st.adapter, Name: name, Map: mapName, Key: key, Hit: hit, Duration: d, Err: err})
+}
+
+func (st *statsCounter) stats() Stats {
+	return Stats{
+		Hits:    atomic.LoadUint64(&st.hits),
+		Misses:  atomic.LoadUint64(&st.misses),
+		Puts:    atomic.LoadUint64(&st.puts),
+		Deletes: atomic.LoadUint64(&st.deletes),
+	}
+}
@@ -0,0 +1,120 @@
+//go:build go1.18
+
+package cache
+
+import (
+	"time"
+)
+
+//Typed 类型安全的缓存, 数据通过PutObject/GetObject读写, 可用于任意适配器
+//
+//	users := cache.NewTyped[User](c)
+//	users.Set("u1", User{Name: "tom"}, time.Minute)
+//	u, ok, err := users.Get("u1")
+type Typed[T any] struct {
+	c Cache
+}
+
+func NewTyped[T any](c Cache) *Typed[T] {
+	return &Typed[T]{c: c}
+}
+
+//Cache 返回底层缓存
+func (t *Typed[T]) Cache() Cache {
+	return t.c
+}
+
+//Get 数据不存在时返回T的零值及false, err为nil
+func (t *Typed[T]) Get(key string) (T, bool, error) {
+	return getTyped[T](t.c.GetObject, key)
+}
+
+func (t *Typed[T]) Set(key string, val T, expire ...time.Duration) error {
+	return t.c.PutObject(key, val, expire...)
+}
+
+//GetMulti 返回存在的数据, 不存在的key不在结果中
+func (t *Typed[T]) GetMulti(keys []string) (map[string]T, error) {
+	return getTypedMulti[T](t.c, keys)
+}
+
+func (t *Typed[T]) Delete(key string) error {
+	return t.c.Delete(key)
+}
+
+func (t *Typed[T]) Exists(key string) bool {
+	return t.c.Exists(key)
+}
+
+//NewMap 创建或获取类型安全的Map
+func (t *Typed[T]) NewMap(name string, expire ...time.Duration) (*TypedMap[T], error) {
+	m, err := t.c.NewMap(name, expire...)
+	if err != nil {
+		return nil, err
+	}
+	return NewTypedMap[T](m), nil
+}
+
+//TypedMap 类型安全的Map
+type TypedMap[T any] struct {
+	m Map
+}
+
+func NewTypedMap[T any](m Map) *TypedMap[T] {
+	return &TypedMap[T]{m: m}
+}
+
+//Map 返回底层Map
+func (t *TypedMap[T]) Map() Map {
+	return t.m
+}
+
+//Get 数据不存在时返回T的零值及false, err为nil
+func (t *TypedMap[T]) Get(key string) (T, bool, error) {
+	return getTyped[T](t.m.GetObject, key)
+}
+
+func (t *TypedMap[T]) Set(key string, val T) error {
+	return t.m.PutObject(key, val)
+}
+
+//GetMulti 返回存在的数据, 不存在的key不在结果中
+func (t *TypedMap[T]) GetMulti(keys []string) (map[string]T, error) {
+	return getTypedMulti[T](t.m, keys)
+}
+
+func (t *TypedMap[T]) Delete(key string) error {
+	return t.m.Delete(key)
+}
+
+func (t *TypedMap[T]) Exists(key string) bool {
+	return t.m.Exists(key)
+}
+
+func getTyped[T any](get func(key string, valptr interface{}) error, key string) (T, bool, error) {
+	var val T
+	err := get(key, &val)
+	if err == ErrNil {
+		return val, false, nil
+	}
+	if err != nil {
+		return val, false, err
+	}
+	return val, true, nil
+}
+
+//getTypedMulti 通过GetObjects批量获取, 实现了ObjectsGetter的适配器只需一次请求
+func getTypedMulti[T any](g ObjectGetter, keys []string) (map[string]T, error) {
+	list := make([]T, len(keys))
+	hits, err := GetObjects(g, keys, func(i int) interface{} { return &list[i] })
+	if err != nil {
+		return nil, err
+	}
+	vals := make(map[string]T, len(keys))
+	for i, hit := range hits {
+		if hit {
+			vals[keys[i]] = list[i]
+		}
+	}
+	return vals, nil
+}
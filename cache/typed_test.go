@@ -0,0 +1,126 @@
+//go:build go1.18
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/tryor/commons/redisutil/redistest"
+)
+
+func Test_Typed(t *testing.T) {
+	memory, err := NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := NewTyped[V](memory)
+	if err = vs.Set("t1", V{V1: "v1", V2: 1}); err != nil {
+		t.Fatal(err)
+	}
+	vs.Set("t2", V{V1: "v2", V2: 2})
+
+	v, ok, err := vs.Get("t1")
+	if err != nil || !ok || v.V1 != "v1" {
+		t.Fatalf("t1:%v, ok:%v, err:%v", v, ok, err)
+	}
+	v, ok, err = vs.Get("t3")
+	if err != nil || ok {
+		t.Fatalf("t3:%v, ok:%v, err:%v", v, ok, err)
+	}
+
+	all, err := vs.GetMulti([]string{"t1", "t2", "t3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all["t2"].V2 != 2 {
+		t.Fatalf("all:%v", all)
+	}
+
+	//类型不匹配时返回错误
+	memory.Put("s1", "str")
+	if _, _, err = vs.Get("s1"); err == nil {
+		t.Fatal("s1: type mismatch not detected")
+	}
+
+	ps := NewTyped[*V](memory)
+	ps.Set("p1", &V{V1: "p1"})
+	p, ok, err := ps.Get("p1")
+	if err != nil || !ok || p.V1 != "p1" {
+		t.Fatalf("p1:%v, ok:%v, err:%v", p, ok, err)
+	}
+
+	m, err := NewTyped[int](memory).NewMap("tmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set("a", 1)
+	m.Set("b", 2)
+	if n, ok, err := m.Get("b"); err != nil || !ok || n != 2 {
+		t.Fatalf("b:%v, ok:%v, err:%v", n, ok, err)
+	}
+	ns, err := m.GetMulti([]string{"a", "b", "c"})
+	if err != nil || len(ns) != 2 || ns["a"] != 1 {
+		t.Fatalf("ns:%v, err:%v", ns, err)
+	}
+	m.Delete("a")
+	if m.Exists("a") {
+		t.Fatal("tmap: a is exist!")
+	}
+}
+
+func Test_TypedGetMulti(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	redis, err := NewCache("redis", `{"addr":"`+s.Addr+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory, _ := NewCache("memory", `{"gccyc":60}`)
+	vs := NewTyped[V](NewL2Cache(memory, redis))
+	redis.PutObject("g1", V{V1: "g1", V2: 1})
+	redis.PutObject("g2", V{V1: "g2", V2: 2})
+	memory.PutObject("g3", V{V1: "g3", V2: 3})
+
+	//只有c1中不存在的key通过一次MGET获取
+	all, err := vs.GetMulti([]string{"g1", "g2", "g3", "g4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all["g1"].V1 != "g1" || all["g2"].V2 != 2 || all["g3"].V2 != 3 {
+		t.Fatalf("all:%v", all)
+	}
+	if s.CommandCount("MGET") != 1 || s.CommandCount("GET") != 0 {
+		t.Fatal(s.Commands)
+	}
+	if v, ok, err := NewTyped[V](memory).Get("g2"); err != nil || !ok || v.V2 != 2 {
+		t.Fatalf("memory g2:%v, ok:%v, err:%v", v, ok, err)
+	}
+
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	redis, err = NewCache("redis", `{"addr":"`+m.Addr()+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, err := NewTyped[V](redis).NewMap("gmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.Set("a", V{V1: "a", V2: 1})
+	tm.Set("b", V{V1: "b", V2: 2})
+	ms, err := tm.GetMulti([]string{"a", "b", "c"})
+	if err != nil || len(ms) != 2 || ms["a"].V1 != "a" || ms["b"].V2 != 2 {
+		t.Fatalf("ms:%v, err:%v", ms, err)
+	}
+	redis.Put("s1", "str")
+	if _, err = NewTyped[V](redis).GetMulti([]string{"s1"}); err == nil {
+		t.Fatal("s1: type mismatch not detected")
+	}
+}
@@ -0,0 +1,193 @@
+/*
+codec 对象的序列化方式, 由cache适配器及redis包共用
+*/
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/vmihailenco/msgpack"
+)
+
+//Codec 对象的序列化方式
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Gob      Codec = gobCodec{}
+	Msgpack  Codec = msgpackCodec{}
+	Protobuf Codec = protobufCodec{} //对象必须实现proto.Message
+)
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[string]Codec{
+		"json":     JSON,
+		"gob":      Gob,
+		"msgpack":  Msgpack,
+		"protobuf": Protobuf,
+	}
+)
+
+//Register 注册序列化方式, 注册后可在cache适配器配置中通过名称使用
+func Register(name string, codec Codec) {
+	if codec == nil {
+		panic("codec: register codec is nil")
+	}
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	if _, ok := codecs[name]; ok {
+		panic("codec: register called twice for codec " + name)
+	}
+	codecs[name] = codec
+}
+
+func Get(name string) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown codec %q", name)
+	}
+	return codec, nil
+}
+
+//New 根据适配器配置创建Codec
+//name - 序列化方式, 为空时使用def
+//compress - 压缩方式, gzip或snappy, 为空时不压缩
+//threshold - 序列化后数据达到此字节数时才压缩
+func New(name string, def Codec, compress string, threshold int) (Codec, error) {
+	codec := def
+	if name != "" {
+		var err error
+		if codec, err = Get(name); err != nil {
+			return nil, err
+		}
+	}
+	if compress == "" {
+		return codec, nil
+	}
+	return NewCompress(codec, compress, threshold)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+//压缩数据前缀, 0xff开头不是合法的json, gob及msgpack单个值的开头
+var (
+	gzipMagic   = []byte{0xff, 'g'}
+	snappyMagic = []byte{0xff, 's'}
+)
+
+//NewCompress 序列化后数据达到threshold字节时进行压缩
+//method - gzip或snappy
+//未压缩的数据与codec的格式相同, 因此可以读取启用压缩前写入的数据
+func NewCompress(codec Codec, method string, threshold int) (Codec, error) {
+	var magic []byte
+	switch strings.ToLower(method) {
+	case "gzip":
+		magic = gzipMagic
+	case "snappy":
+		magic = snappyMagic
+	default:
+		return nil, errors.New("codec: unknown compress method " + method)
+	}
+	return &compressCodec{codec: codec, magic: magic, threshold: threshold}, nil
+}
+
+type compressCodec struct {
+	codec     Codec
+	magic     []byte
+	threshold int
+}
+
+func (c *compressCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := c.codec.Marshal(v)
+	if err != nil || len(b) < c.threshold {
+		return b, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(b)/2))
+	buf.Write(c.magic)
+	if bytes.Equal(c.magic, snappyMagic) {
+		buf.Write(snappy.Encode(nil, b))
+		return buf.Bytes(), nil
+	}
+	w := gzip.NewWriter(buf)
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *compressCodec) Unmarshal(data []byte, v interface{}) error {
+	var err error
+	switch {
+	case bytes.HasPrefix(data, snappyMagic):
+		data, err = snappy.Decode(nil, data[len(snappyMagic):])
+	case bytes.HasPrefix(data, gzipMagic):
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(data[len(gzipMagic):])); err == nil {
+			data, err = ioutil.ReadAll(r)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(data, v)
+}
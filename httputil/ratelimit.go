@@ -0,0 +1,39 @@
+package httputil
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tryor/commons/ratelimit"
+)
+
+//RateLimit 限流中间件, key为nil时按GetRemoteIp返回的ip限流
+//响应头X-RateLimit-Limit及X-RateLimit-Remaining为限流数及剩余次数, 超过限流时返回429及Retry-After(秒)
+//限流器出错时记录日志并放行请求
+func RateLimit(l *ratelimit.Limiter, key func(req *http.Request) string, next http.Handler) http.Handler {
+	if key == nil {
+		key = func(req *http.Request) string {
+			ip, _ := GetRemoteIp(req)
+			return ip
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r, err := l.AllowN(key(req), 1)
+		if err != nil {
+			log.Printf("httputil: rate limit error, %v\n", err)
+			next.ServeHTTP(w, req)
+			return
+		}
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(r.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(r.Remaining))
+		if !r.Allowed {
+			h.Set("Retry-After", strconv.Itoa(int((r.RetryAfter+time.Second-1)/time.Second)))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,48 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tryor/commons/cache"
+	"github.com/tryor/commons/ratelimit"
+)
+
+func Test_RateLimit(t *testing.T) {
+	c, err := cache.NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ratelimit.New(c, ratelimit.FixedWindow, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := RateLimit(l, nil, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	do := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	for i, remaining := range []string{"1", "0"} {
+		if w := do("1.1.1.1"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Fatal(i, w.Code, w.Header())
+		}
+	}
+	w := do("1.1.1.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if s := w.Header().Get("Retry-After"); s != "60" {
+		t.Fatal("Retry-After", s)
+	}
+	if w = do("2.2.2.2"); w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+}
@@ -0,0 +1,218 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/tryor/commons/cache"
+)
+
+//sweepInterval 清理过期状态的间隔, 毫秒
+const sweepInterval = 60 * 1000
+
+//state 一个key的限流状态, 时间均为毫秒
+type state struct {
+	start  int64   //当前窗口的开始时间, TokenBucket为最后补充令牌的时间
+	count  int     //当前窗口已计入的次数, 预约的次数可能超过limit, 超过的部分计入之后的窗口
+	prev   int     //上一个窗口的次数, SlidingWindowCounter使用
+	tokens float64 //剩余令牌数, 预约时可能为负数
+	log    []int64 //已计入的请求时间, 预约的请求为预约的执行时间, SlidingWindowLog使用
+	expire int64   //过期时间, 过期后状态与新建的相同
+}
+
+//localStore 缓存不支持脚本时在进程内保存限流状态
+type localStore struct {
+	lock      sync.Mutex
+	states    map[string]*state
+	lastSweep int64
+}
+
+func newLocalStore() *localStore {
+	return &localStore{states: make(map[string]*state)}
+}
+
+//stores 不支持脚本的缓存对应的进程内限流状态, 缓存在进程结束前不会被释放
+var stores = struct {
+	sync.Mutex
+	m map[cache.Cache]*localStore
+}{m: make(map[cache.Cache]*localStore)}
+
+//localStoreOf 返回c对应的进程内限流状态, 使用c的Limiter共享, 与限流状态保存在c中时相同
+//c不能作为map的key(如包含map或slice的结构体值)时返回错误
+func localStoreOf(c cache.Cache) (s *localStore, err error) {
+	stores.Lock()
+	defer stores.Unlock()
+	defer func() {
+		if recover() != nil {
+			s, err = nil, errors.New("ratelimit: cache without script support must be comparable")
+		}
+	}()
+	if s = stores.m[c]; s == nil {
+		s = newLocalStore()
+		stores.m[c] = s
+	}
+	return s, nil
+}
+
+func (s *localStore) take(l *Limiter, key string, now int64, n int, reserve bool) result {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if now-s.lastSweep >= sweepInterval {
+		for k, st := range s.states {
+			if st.expire <= now {
+				delete(s.states, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	st, ok := s.states[key]
+	if !ok || st.expire <= now {
+		st = &state{start: now, tokens: float64(l.limit)}
+	}
+	r := algorithms[l.alg](st, l.window, l.limit, now, n, reserve)
+	if r.delay == 0 || (reserve && r.delay > 0) {
+		s.states[key] = st
+	}
+	return r
+}
+
+//algorithms 各算法在进程内的实现, 与redis.go中的lua脚本一致, tokenBucket与redis.TokenBucketScript一致
+//st已过期时为新建的状态, 只有允许或预约时修改st的计数
+var algorithms = [...]func(st *state, w int64, limit int, now int64, n int, reserve bool) result{
+	FixedWindow:          fixedWindow,
+	SlidingWindowLog:     slidingWindowLog,
+	SlidingWindowCounter: slidingWindowCounter,
+	TokenBucket:          tokenBucket,
+}
+
+func fixedWindow(st *state, w int64, limit int, now int64, n int, reserve bool) result {
+	if k := (now - st.start) / w; k > 0 {
+		st.start += k * w
+		st.count = maxInt(0, st.count-int(k)*limit)
+		//计数已清空时与新建的状态相同, 脚本的key可能因调用者的时钟不一致尚未过期
+		if st.count == 0 {
+			st.start = now
+		}
+	}
+	if n > limit {
+		return result{remaining: maxInt(0, limit-st.count), delay: -1}
+	}
+	//第idx个窗口可以容纳这n次
+	idx := int64((st.count + n - 1) / limit)
+	var delay int64
+	if idx > 0 {
+		delay = st.start + idx*w - now
+	}
+	if delay == 0 || reserve {
+		st.count += n
+		st.expire = st.start + (idx+1)*w
+	}
+	return result{remaining: maxInt(0, limit-st.count), delay: delay}
+}
+
+func slidingWindowLog(st *state, w int64, limit int, now int64, n int, reserve bool) result {
+	i := 0
+	for i < len(st.log) && st.log[i] <= now-w {
+		i++
+	}
+	st.log = st.log[i:]
+	if n > limit {
+		return result{remaining: maxInt(0, limit-len(st.log)), delay: -1}
+	}
+	//每次请求在其前面第limit个请求的window之后
+	log := append(make([]int64, 0, len(st.log)+n), st.log...)
+	for j := 0; j < n; j++ {
+		t := now
+		if m := len(log); m >= limit && log[m-limit]+w > t {
+			t = log[m-limit] + w
+		}
+		log = append(log, t)
+	}
+	last := log[len(log)-1]
+	delay := last - now
+	if delay == 0 || reserve {
+		st.log = log
+		st.expire = last + w
+	}
+	return result{remaining: maxInt(0, limit-len(st.log)), delay: delay}
+}
+
+func slidingWindowCounter(st *state, w int64, limit int, now int64, n int, reserve bool) result {
+	//超过limit的部分依次移到之后的窗口
+	for k := (now - st.start) / w; k > 0; k-- {
+		st.start += w
+		st.prev = minInt(st.count, limit)
+		st.count -= st.prev
+		if st.prev == 0 && st.count == 0 {
+			st.start = now
+			break
+		}
+	}
+	if n > limit {
+		return result{remaining: slidingRemaining(st, w, limit, now), delay: -1}
+	}
+	//按上一个窗口的次数线性递减估算, 计算估算值加n不超过limit的时间
+	start, prev, count, from := st.start, st.prev, st.count+n, now
+	for count > limit {
+		start, prev, count, from = start+w, limit, count-limit, start+w
+	}
+	t := from
+	if prev > 0 {
+		t = maxInt64(from, start+w-int64(math.Floor(float64(limit-count)*float64(w)/float64(prev))))
+	}
+	delay := t - now
+	if delay == 0 || reserve {
+		st.count += n
+		st.expire = start + 2*w
+	}
+	return result{remaining: slidingRemaining(st, w, limit, now), delay: delay}
+}
+
+func slidingRemaining(st *state, w int64, limit int, now int64) int {
+	x := float64(now-st.start) / float64(w)
+	used := int(math.Ceil(float64(st.prev)*(1-x))) + st.count
+	return maxInt(0, limit-used)
+}
+
+func tokenBucket(st *state, w int64, limit int, now int64, n int, reserve bool) result {
+	rate := float64(limit) / float64(w)
+	if now > st.start {
+		st.tokens = math.Min(float64(limit), st.tokens+float64(now-st.start)*rate)
+		st.start = now
+	}
+	if n > limit {
+		return result{remaining: maxInt(0, int(math.Floor(st.tokens))), delay: -1}
+	}
+	tokens := st.tokens - float64(n)
+	var delay int64
+	if tokens < 0 {
+		delay = int64(math.Ceil(-tokens / rate))
+	}
+	if delay == 0 || reserve {
+		st.tokens = tokens
+		st.expire = now + int64(math.Ceil((float64(limit)-tokens)/rate))
+	}
+	return result{remaining: maxInt(0, int(math.Floor(st.tokens))), delay: delay}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
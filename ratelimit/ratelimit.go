@@ -0,0 +1,188 @@
+/*
+ratelimit 基于缓存的限流器, 支持固定窗口、滑动窗口日志、滑动窗口计数及令牌桶算法
+支持脚本的缓存(redis适配器, 见cache.ScriptCache)使用lua脚本原子执行, 可以在多个进程间共享
+其它缓存(如memory, file, sql适配器)不保存限流状态, 使用同一个缓存的Limiter共享进程内加锁的map
+*/
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/cache"
+)
+
+//Algorithm 限流算法
+type Algorithm int
+
+const (
+	//FixedWindow 固定窗口, 每个窗口最多limit次, 窗口边界附近可能出现2倍limit的突发
+	FixedWindow Algorithm = iota
+	//SlidingWindowLog 滑动窗口日志, 记录每次请求的时间, 任意window时间内最多limit次, 精确但占用空间与limit成正比
+	SlidingWindowLog
+	//SlidingWindowCounter 滑动窗口计数, 按上一个窗口的数量加权估算, 占用空间小
+	SlidingWindowCounter
+	//TokenBucket 令牌桶, 容量为limit, 每window补充limit个令牌, 允许limit大小的突发
+	TokenBucket
+)
+
+//keyPrefix 缓存中限流状态的key前缀
+const keyPrefix = "ratelimit:"
+
+//Result AllowN的结果
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int           //剩余可用次数
+	RetryAfter time.Duration //未允许时需要等待的时间, n大于limit时为-1, 表示永远不会允许
+}
+
+//Reservation Reserve的结果
+type Reservation struct {
+	OK    bool          //n大于limit时为false, 没有预约
+	Delay time.Duration //预约成功后需要等待的时间
+}
+
+//Wait 等待Delay, ctx结束时返回ctx.Err()
+func (r *Reservation) Wait(ctx context.Context) error {
+	if !r.OK {
+		return errors.New("ratelimit: reservation exceeds limit")
+	}
+	if r.Delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(r.Delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+//Limiter 限流器, 每个key单独计数
+type Limiter struct {
+	c      cache.Cache
+	alg    Algorithm
+	limit  int
+	window int64 //毫秒
+	now    func() time.Time
+}
+
+//New 创建限流器, 每个key在window时间内最多允许limit次, window最小为1毫秒
+//c不支持脚本(cache.Eval返回cache.ErrNotSupported)时限流状态保存在进程内, 不写入c,
+//同一进程中使用c的Limiter与使用脚本时相同, 共享相同key的状态, 多个进程之间不共享, 见localStoreOf
+func New(c cache.Cache, alg Algorithm, limit int, window time.Duration) (*Limiter, error) {
+	if alg < FixedWindow || alg > TokenBucket {
+		return nil, errors.New("ratelimit: unknown algorithm")
+	}
+	if limit <= 0 || window < time.Millisecond {
+		return nil, errors.New("ratelimit: limit and window must be positive")
+	}
+	return &Limiter{c: c, alg: alg, limit: limit, window: int64(window / time.Millisecond), now: time.Now}, nil
+}
+
+//Allow 同AllowN(key, 1)
+func (l *Limiter) Allow(key string) (bool, error) {
+	r, err := l.AllowN(key, 1)
+	if err != nil {
+		return false, err
+	}
+	return r.Allowed, nil
+}
+
+//AllowN key是否允许n次, 允许时计入n次, 否则不计入
+func (l *Limiter) AllowN(key string, n int) (*Result, error) {
+	r, err := l.take(key, n, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Allowed: r.delay == 0, Limit: l.limit, Remaining: r.remaining, RetryAfter: millis(r.delay)}, nil
+}
+
+//Reserve 同ReserveN(key, 1)
+func (l *Limiter) Reserve(key string) (*Reservation, error) {
+	return l.ReserveN(key, 1)
+}
+
+//ReserveN 预约n次, n不大于limit时总是计入, 调用者需要等待Delay后再执行
+func (l *Limiter) ReserveN(key string, n int) (*Reservation, error) {
+	r, err := l.take(key, n, true)
+	if err != nil {
+		return nil, err
+	}
+	if r.delay < 0 {
+		return &Reservation{}, nil
+	}
+	return &Reservation{OK: true, Delay: millis(r.delay)}, nil
+}
+
+func millis(ms int64) time.Duration {
+	if ms < 0 {
+		return -1
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+//result 算法的执行结果, delay为0时允许, 小于0时n大于limit
+type result struct {
+	remaining int
+	delay     int64 //毫秒
+}
+
+func (l *Limiter) take(key string, n int, reserve bool) (result, error) {
+	if n <= 0 {
+		return result{}, errors.New("ratelimit: n must be positive")
+	}
+	now := l.now().UnixNano() / int64(time.Millisecond)
+	r, err := l.eval(keyPrefix+key, now, n, reserve)
+	if err != cache.ErrNotSupported {
+		return r, err
+	}
+	s, err := localStoreOf(l.c)
+	if err != nil {
+		return result{}, err
+	}
+	return s.take(l, keyPrefix+key, now, n, reserve), nil
+}
+
+//eval 使用lua脚本执行, 返回{是否允许, 剩余次数, 需要等待的毫秒数}
+func (l *Limiter) eval(key string, now int64, n int, reserve bool) (result, error) {
+	reserveArg := 0
+	if reserve {
+		reserveArg = 1
+	}
+	var id string
+	if l.alg == SlidingWindowLog {
+		var err error
+		if id, err = randomID(); err != nil {
+			return result{}, err
+		}
+	}
+	args := []interface{}{l.window, l.limit, now, n, reserveArg, id}
+	if l.alg == TokenBucket {
+		args = tokenBucketArgs(l.window, l.limit, now, n, reserveArg)
+	}
+	reply, err := redis.Int64s(cache.Eval(l.c, scripts[l.alg], []string{key}, args...))
+	if err != nil {
+		return result{}, err
+	}
+	if len(reply) != 3 {
+		return result{}, errors.New("ratelimit: invalid script reply")
+	}
+	return result{remaining: int(reply[1]), delay: reply[2]}, nil
+}
+
+//randomID SlidingWindowLog中记录的唯一标识
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
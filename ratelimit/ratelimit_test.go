@@ -0,0 +1,308 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/tryor/commons/cache"
+)
+
+//clock 测试使用的时钟
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) add(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newLimiter(t *testing.T, c cache.Cache, alg Algorithm, limit int, window time.Duration) (*Limiter, *clock) {
+	l, err := New(c, alg, limit, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := &clock{t: time.Unix(1500000000, 0)}
+	l.now = clk.now
+	return l, clk
+}
+
+func memoryCache(t *testing.T) cache.Cache {
+	c, err := cache.NewCache("memory", `{"gccyc":60}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func allowN(t *testing.T, l *Limiter, key string, n int, allowed bool, remaining int, retryAfter time.Duration) {
+	t.Helper()
+	r, err := l.AllowN(key, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Allowed != allowed || r.Remaining != remaining || r.RetryAfter != retryAfter || r.Limit != l.limit {
+		t.Fatalf("AllowN(%s, %d) = %+v, want %v %d %v", key, n, r, allowed, remaining, retryAfter)
+	}
+}
+
+func Test_New(t *testing.T) {
+	c := memoryCache(t)
+	if _, err := New(c, TokenBucket+1, 1, time.Second); err == nil {
+		t.Fatal("unknown algorithm")
+	}
+	if _, err := New(c, FixedWindow, 0, time.Second); err == nil {
+		t.Fatal("limit 0")
+	}
+	if _, err := New(c, FixedWindow, 1, time.Microsecond); err == nil {
+		t.Fatal("window < 1ms")
+	}
+	l, _ := newLimiter(t, c, FixedWindow, 1, time.Second)
+	if _, err := l.AllowN("k", 0); err == nil {
+		t.Fatal("n 0")
+	}
+}
+
+func Test_FixedWindow(t *testing.T) {
+	testFixedWindow(t, memoryCache(t))
+}
+
+func testFixedWindow(t *testing.T, c cache.Cache) {
+	l, clk := newLimiter(t, c, FixedWindow, 3, time.Second)
+	allowN(t, l, "k", 2, true, 1, 0)
+	allowN(t, l, "k", 1, true, 0, 0)
+	allowN(t, l, "k", 1, false, 0, time.Second)
+	allowN(t, l, "k2", 1, true, 2, 0)
+	allowN(t, l, "k", 4, false, 0, -1)
+
+	clk.add(time.Millisecond * 400)
+	allowN(t, l, "k", 1, false, 0, time.Millisecond*600)
+	clk.add(time.Millisecond * 600)
+	allowN(t, l, "k", 3, true, 0, 0)
+
+	//预约时计入之后的窗口
+	r, err := l.ReserveN("k", 2)
+	if err != nil || !r.OK || r.Delay != time.Second {
+		t.Fatal(r, err)
+	}
+	r, _ = l.ReserveN("k", 2)
+	if !r.OK || r.Delay != time.Second*2 {
+		t.Fatal(r)
+	}
+	clk.add(time.Second)
+	allowN(t, l, "k", 1, false, 0, time.Second)
+	clk.add(time.Second * 2)
+	allowN(t, l, "k", 3, true, 0, 0)
+}
+
+func Test_SlidingWindowLog(t *testing.T) {
+	testSlidingWindowLog(t, memoryCache(t))
+}
+
+func testSlidingWindowLog(t *testing.T, c cache.Cache) {
+	l, clk := newLimiter(t, c, SlidingWindowLog, 3, time.Second)
+	allowN(t, l, "k", 1, true, 2, 0)
+	clk.add(time.Millisecond * 300)
+	allowN(t, l, "k", 2, true, 0, 0)
+	clk.add(time.Millisecond * 300)
+	allowN(t, l, "k", 1, false, 0, time.Millisecond*400)
+	allowN(t, l, "k", 2, false, 0, time.Millisecond*700)
+	clk.add(time.Millisecond * 400)
+	allowN(t, l, "k", 1, true, 0, 0)
+	allowN(t, l, "k", 4, false, 0, -1)
+
+	ok, err := l.Allow("k")
+	if ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	r, _ := l.Reserve("k")
+	if !r.OK || r.Delay != time.Millisecond*300 {
+		t.Fatal(r)
+	}
+	//前面的两次已过期, 预约的一次计入
+	clk.add(time.Millisecond * 300)
+	allowN(t, l, "k", 1, true, 0, 0)
+	allowN(t, l, "k", 1, false, 0, time.Millisecond*700)
+}
+
+func Test_SlidingWindowCounter(t *testing.T) {
+	testSlidingWindowCounter(t, memoryCache(t))
+}
+
+func testSlidingWindowCounter(t *testing.T, c cache.Cache) {
+	l, clk := newLimiter(t, c, SlidingWindowCounter, 4, time.Second)
+	allowN(t, l, "k", 4, true, 0, 0)
+	allowN(t, l, "k", 1, false, 0, time.Millisecond*1250)
+	//下一个窗口的开始, 上一个窗口的4次全部计入
+	clk.add(time.Second)
+	allowN(t, l, "k", 1, false, 0, time.Millisecond*250)
+	clk.add(time.Millisecond * 500)
+	allowN(t, l, "k", 2, true, 0, 0)
+	clk.add(time.Millisecond * 250)
+	allowN(t, l, "k", 1, true, 0, 0)
+	allowN(t, l, "k", 5, false, 0, -1)
+
+	//两个窗口之后状态清空
+	clk.add(time.Second * 2)
+	allowN(t, l, "k", 3, true, 1, 0)
+
+	r, err := l.ReserveN("k", 3)
+	if err != nil || !r.OK || r.Delay != time.Millisecond*1500 {
+		t.Fatal(r, err)
+	}
+	r, _ = l.ReserveN("k", 5)
+	if r.OK {
+		t.Fatal(r)
+	}
+	if err = r.Wait(context.Background()); err == nil {
+		t.Fatal("Wait not OK reservation")
+	}
+}
+
+func Test_TokenBucket(t *testing.T) {
+	testTokenBucket(t, memoryCache(t))
+}
+
+func testTokenBucket(t *testing.T, c cache.Cache) {
+	l, clk := newLimiter(t, c, TokenBucket, 10, time.Second)
+	allowN(t, l, "k", 10, true, 0, 0)
+	allowN(t, l, "k", 1, false, 0, time.Millisecond*100)
+	clk.add(time.Millisecond * 250)
+	allowN(t, l, "k", 2, true, 0, 0)
+	allowN(t, l, "k", 11, false, 0, -1)
+	clk.add(time.Second * 5)
+	allowN(t, l, "k", 1, true, 9, 0)
+
+	//预约后令牌为负数, 之后的请求需要等待补充
+	r, err := l.ReserveN("k", 10)
+	if err != nil || !r.OK || r.Delay != time.Millisecond*100 {
+		t.Fatal(r, err)
+	}
+	allowN(t, l, "k", 1, false, 0, time.Millisecond*200)
+
+	clk.add(time.Millisecond * 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, _ = l.Reserve("k")
+	if !r.OK || r.Delay != time.Millisecond*100 {
+		t.Fatal(r)
+	}
+	if err = r.Wait(ctx); err != context.Canceled {
+		t.Fatal(err)
+	}
+	r = &Reservation{OK: true, Delay: time.Millisecond}
+	if err = r.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_LocalSweep(t *testing.T) {
+	c := memoryCache(t)
+	l, clk := newLimiter(t, c, FixedWindow, 1, time.Second)
+	l.Allow("k1")
+	clk.add(time.Second * 30)
+	l.Allow("k2")
+	clk.add(time.Second * 60)
+	l.Allow("k3")
+	s, _ := localStoreOf(c)
+	if len(s.states) != 1 || s.states["ratelimit:k3"] == nil {
+		t.Fatal(s.states)
+	}
+}
+
+//unhashableCache 不能作为map的key的缓存
+type unhashableCache struct {
+	cache.Cache
+	tags []string
+}
+
+//Test_LocalShared 不支持脚本时使用同一个缓存的Limiter共享限流状态, 与使用脚本时相同
+func Test_LocalShared(t *testing.T) {
+	c := memoryCache(t)
+	l1, _ := newLimiter(t, c, FixedWindow, 2, time.Second)
+	l2, _ := newLimiter(t, c, FixedWindow, 2, time.Second)
+	l3, _ := newLimiter(t, memoryCache(t), FixedWindow, 2, time.Second)
+	allowN(t, l1, "k", 2, true, 0, 0)
+	allowN(t, l2, "k", 1, false, 0, time.Second)
+	allowN(t, l3, "k", 1, true, 1, 0)
+
+	l, _ := newLimiter(t, unhashableCache{Cache: c}, FixedWindow, 2, time.Second)
+	if _, err := l.Allow("k"); err == nil {
+		t.Fatal("unhashable cache")
+	}
+}
+
+func Test_Redis(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	c, err := cache.NewCache("redis", `{"addr":"`+m.Addr()+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, clk := newLimiter(t, cache.WithNamespace(c, "api:"), SlidingWindowLog, 2, time.Second)
+	allowN(t, l, "k", 2, true, 0, 0)
+	allowN(t, l, "k", 1, false, 0, time.Second)
+	clk.add(time.Second)
+	r, err := l.Reserve("k")
+	if err != nil || !r.OK || r.Delay != 0 {
+		t.Fatal(r, err)
+	}
+	//过期的请求已删除, 成员为本次请求的唯一标识及序号
+	if members, err := m.ZMembers("api:ratelimit:k"); err != nil || len(members) != 1 || len(members[0]) != len("0123456789abcdef:1") {
+		t.Fatal(members, err)
+	}
+
+	l, _ = newLimiter(t, c, TokenBucket, 2, time.Second*4)
+	allowN(t, l, "k", 3, false, 2, -1)
+	allowN(t, l, "k", 2, true, 0, 0)
+	allowN(t, l, "k", 1, false, 0, time.Second*2)
+	if tokens := m.HGet("ratelimit:k", "tokens"); tokens != "0" {
+		t.Fatal("tokens", tokens)
+	}
+
+	stores.Lock()
+	_, local := stores.m[c]
+	stores.Unlock()
+	if local {
+		t.Fatal("local store used")
+	}
+}
+
+//luaCache 使用miniredis执行真实的lua脚本
+func luaCache(t *testing.T) cache.Cache {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	c, err := cache.NewCache("redis", `{"addr":"`+m.Addr()+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+//Test_Lua 执行各算法的lua脚本, 结果与进程内的实现相同
+func Test_Lua(t *testing.T) {
+	c := luaCache(t)
+	for name, fn := range map[string]func(t *testing.T, c cache.Cache){
+		"FixedWindow":          testFixedWindow,
+		"SlidingWindowLog":     testSlidingWindowLog,
+		"SlidingWindowCounter": testSlidingWindowCounter,
+		"TokenBucket":          testTokenBucket,
+	} {
+		fn := fn
+		t.Run(name, func(t *testing.T) {
+			fn(t, cache.WithNamespace(c, name+":"))
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	commonsredis "github.com/tryor/commons/redis"
+)
+
+//scripts 各算法的lua脚本, 与local.go中的实现一致, 当前时间由调用者传入
+//KEYS[1]为限流状态, ARGV为窗口毫秒数, limit, 当前毫秒数, n, 是否预约(1/0), 本次请求的唯一标识
+//返回{是否允许, 剩余次数, 需要等待的毫秒数}, n大于limit时等待时间为-1
+//TokenBucket使用redis包的令牌桶脚本, 参数见tokenBucketArgs
+var scripts = [...]string{
+	FixedWindow:          fixedWindowScript,
+	SlidingWindowLog:     slidingWindowLogScript,
+	SlidingWindowCounter: slidingWindowCounterScript,
+	TokenBucket:          commonsredis.TokenBucketScript.Source(),
+}
+
+//tokenBucketArgs redis.TokenBucketScript的参数: 容量, 每秒补充的令牌数, 当前毫秒数, n, 是否预约
+func tokenBucketArgs(w int64, limit int, now int64, n int, reserve int) []interface{} {
+	return []interface{}{limit, float64(limit) * 1000 / float64(w), now, n, reserve}
+}
+
+const scriptArgs = `
+local w = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = ARGV[5] == '1'
+`
+
+const fixedWindowScript = scriptArgs + `
+local st = redis.call('HMGET', KEYS[1], 'start', 'count')
+local start = tonumber(st[1]) or now
+local count = tonumber(st[2]) or 0
+local k = math.floor((now - start) / w)
+if k > 0 then
+	start = start + k * w
+	count = math.max(0, count - k * limit)
+	if count == 0 then
+		start = now
+	end
+end
+if n > limit then
+	return {0, math.max(0, limit - count), -1}
+end
+local idx = math.floor((count + n - 1) / limit)
+local delay = 0
+if idx > 0 then
+	delay = start + idx * w - now
+end
+if delay == 0 or reserve then
+	count = count + n
+	redis.call('HMSET', KEYS[1], 'start', start, 'count', count)
+	redis.call('PEXPIRE', KEYS[1], start + (idx + 1) * w - now)
+end
+local allowed = 0
+if delay == 0 then
+	allowed = 1
+end
+return {allowed, math.max(0, limit - count), delay}`
+
+const slidingWindowLogScript = scriptArgs + `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - w)
+local m = redis.call('ZCARD', KEYS[1])
+if n > limit then
+	return {0, math.max(0, limit - m), -1}
+end
+local added = {}
+local last = now
+for j = 1, n do
+	local t = now
+	local i = m + j - 1 - limit
+	if i >= 0 then
+		local before
+		if i < m then
+			before = tonumber(redis.call('ZRANGE', KEYS[1], i, i, 'WITHSCORES')[2])
+		else
+			before = added[i - m + 1]
+		end
+		t = math.max(t, before + w)
+	end
+	added[j] = t
+	last = t
+end
+local delay = last - now
+if delay == 0 or reserve then
+	for j = 1, n do
+		redis.call('ZADD', KEYS[1], added[j], ARGV[6] .. ':' .. j)
+	end
+	m = m + n
+	redis.call('PEXPIRE', KEYS[1], last + w - now)
+end
+local allowed = 0
+if delay == 0 then
+	allowed = 1
+end
+return {allowed, math.max(0, limit - m), delay}`
+
+const slidingWindowCounterScript = scriptArgs + `
+local st = redis.call('HMGET', KEYS[1], 'start', 'prev', 'count')
+local start = tonumber(st[1]) or now
+local prev = tonumber(st[2]) or 0
+local count = tonumber(st[3]) or 0
+local k = math.floor((now - start) / w)
+while k > 0 do
+	start = start + w
+	prev = math.min(count, limit)
+	count = count - prev
+	k = k - 1
+	if prev == 0 and count == 0 then
+		start = now
+		break
+	end
+end
+local function remaining()
+	local used = math.ceil(prev * (1 - (now - start) / w)) + count
+	return math.max(0, limit - used)
+end
+if n > limit then
+	return {0, remaining(), -1}
+end
+local s, p, c, from = start, prev, count + n, now
+while c > limit do
+	s, p, c, from = s + w, limit, c - limit, s + w
+end
+local t = from
+if p > 0 then
+	t = math.max(from, s + w - math.floor((limit - c) * w / p))
+end
+local delay = t - now
+if delay == 0 or reserve then
+	count = count + n
+	redis.call('HMSET', KEYS[1], 'start', start, 'prev', prev, 'count', count)
+	redis.call('PEXPIRE', KEYS[1], s + 2 * w - now)
+end
+local allowed = 0
+if delay == 0 then
+	allowed = 1
+end
+return {allowed, remaining(), delay}`
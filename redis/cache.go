@@ -2,14 +2,30 @@ package redis
 
 import (
 	"encoding/json"
-	"log"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/codec"
+	"github.com/tryor/commons/redisutil"
 )
 
-var pool *redis.Pool
+//batchSize HashMap.SetObjects, SortedSet.AddMany等批量方法每条命令的最大元素数
+const batchSize = 1000
+
+//std 包级函数使用的默认客户端, 由CacheInit等初始化
+var std = &Client{codec: codec.JSON}
+
+//DefaultClient 返回包级函数使用的默认客户端
+func DefaultClient() *Client {
+	return std
+}
+
+//SetCodec 设置默认客户端SetObject/GetObject及HashMap.SetObject/GetObject的序列化方式, 默认：codec.JSON
+//SortedSet成员始终使用json, 以保证相同对象对应相同成员
+func SetCodec(c codec.Codec) {
+	std.SetCodec(c)
+}
 
 //server redis服务器，如:127.0.0.1:6726
 //password 服务密码
@@ -17,118 +33,104 @@ var pool *redis.Pool
 //args[1] 空闲连接超时时间， 秒
 //args[2] 连接TestOnBorrow测试时，指定空闲多少时间后的连接进行ping操作， 秒
 func CacheInit(server, password string, args ...int) {
-	maxIdleConns := 10
-	idleTimeout := 600 * time.Second
-	idlePing := 60 * time.Second
-	if len(args) > 0 {
-		maxIdleConns = args[0]
+	std.pool, _ = newInitPool(redisutil.ModeStandalone, []string{server}, "", password, args)
+}
+
+//CacheInitCluster 使用redis cluster, 命令按key所属的slot发送到对应的节点
+//addrs 部分或全部节点地址, 其余节点通过CLUSTER SLOTS获取
+//args 同CacheInit
+func CacheInitCluster(addrs []string, password string, args ...int) error {
+	p, err := newInitPool(redisutil.ModeCluster, addrs, "", password, args)
+	if err != nil {
+		return err
 	}
-	if len(args) > 1 {
-		idleTimeout = time.Duration(args[1]) * time.Second
+	std.pool = p
+	return nil
+}
+
+//CacheInitSentinel 通过redis sentinel查找master, 故障切换后自动连接新的master
+//masterName sentinel中配置的master名称
+//sentinels sentinel地址
+//password master的密码
+//args 同CacheInit
+func CacheInitSentinel(masterName string, sentinels []string, password string, args ...int) error {
+	p, err := newInitPool(redisutil.ModeSentinel, sentinels, masterName, password, args)
+	if err != nil {
+		return err
+	}
+	std.pool = p
+	return nil
+}
+
+//CacheInitConfig 使用与cache的redis适配器相同的配置初始化, 支持TLS, ACL, 超时及连接数限制等
+//config - {"mode":"", "addr":"127.0.0.1:6379", "username":"", "password":"", "maxActiveConns":"100", "wait":"true", "readTimeout":"3", "tls":"true"}
+//配置项见redisutil.ParseOptions
+func CacheInitConfig(config string) error {
+	var cf map[string]string
+	if err := json.Unmarshal([]byte(config), &cf); err != nil {
+		return err
 	}
-	if len(args) > 2 {
-		idlePing = time.Duration(args[2]) * time.Second
+	p, err := newPoolConfig(cf)
+	if err != nil {
+		return err
 	}
+	std.pool = p
+	return nil
+}
 
-	pool = &redis.Pool{
-		MaxIdle:     maxIdleConns,
-		IdleTimeout: idleTimeout,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", server)
-			if err != nil {
-				return nil, err
-			}
-			if password != "" {
-				if _, err := c.Do("AUTH", password); err != nil {
-					c.Close()
-					return nil, err
-				}
-			}
-			return c, err
-		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			if time.Now().Sub(t) < idlePing {
-				return nil
-			}
-			_, err := c.Do("PING")
-			if err != nil {
-				log.Printf("ping error, %v\n", err)
-				return err
-			}
-			return nil
-		},
+//newInitPool 将CacheInit等的参数转为配置, 默认值与之前保持一致
+func newInitPool(mode string, addrs []string, masterName, password string, args []int) (*redisutil.Pool, error) {
+	cf := map[string]string{
+		"mode":            mode,
+		"addr":            strings.Join(addrs, ","),
+		"masterName":      masterName,
+		"password":        password,
+		"maxIdleConns":    "10",
+		"connIdleTimeout": "600",
+		"noTesttime":      "60",
+	}
+	keys := []string{"maxIdleConns", "connIdleTimeout", "noTesttime"}
+	for i := 0; i < len(args) && i < len(keys); i++ {
+		cf[keys[i]] = strconv.Itoa(args[i])
 	}
+	return newPoolConfig(cf)
 }
 
+//GetRedis 返回默认客户端的连接, 见Client.Conn
 func GetRedis() redis.Conn {
-	return pool.Get()
+	return std.Conn()
 }
 
 func Send(cmd string, args ...interface{}) error {
-	red := GetRedis()
-	defer red.Close()
-	err := red.Send(cmd, args...)
-	//log.Debugf("Send %v %v %v", cmd, args, err)
-	if err != nil {
-		return err
-	}
-	return red.Flush()
+	return std.Send(cmd, args...)
 }
 
 func Do(cmd string, args ...interface{}) (interface{}, error) {
-	red := GetRedis()
-	defer red.Close()
-	//log.Debugf("Do %v %v", cmd, args)
-	return red.Do(cmd, args...)
+	return std.Do(cmd, args...)
 }
 
 func SetString(k string, v string, expire ...int) error {
-	//SET key value [EX seconds]
-	if len(expire) > 0 {
-		return Send("SET", k, v, "EX", expire[0]) //return Send("SET", k, v, "EX "+strconv.Itoa(expire[0]))
-	} else {
-		return Send("SET", k, v)
-	}
+	return std.SetString(k, v, expire...)
 }
 
 func GetString(k string) (string, error) {
-	str, err := redis.String(Do("GET", k))
-	if err == nil {
-		str = strings.Trim(str, "\"")
-	}
-	return str, err
+	return std.GetString(k)
 }
 
 func SetObject(k string, v interface{}, expire ...int) error {
-	b, err := json.Marshal(v)
-	if err != nil {
-		return err
-	}
-	if len(expire) > 0 {
-		return Send("SET", k, b, "EX", expire[0])
-	} else {
-		return Send("SET", k, b)
-	}
+	return std.SetObject(k, v, expire...)
 }
 
 func GetObject(k string, clazz interface{}) error {
-	b, err := redis.Bytes(Do("GET", k))
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(b, clazz)
+	return std.GetObject(k, clazz)
 }
 
 func Del(k string) error {
-	return Send("DEL", k)
+	return std.Del(k)
 }
 
 func TTL(k string) (int, error) {
-	b, err := redis.Int(Do("TTL", k))
-	if err != nil {
-		return b, err
-	}
-	return b, nil
+	return std.TTL(k)
 }
 
 func titleCasedName(name string) string {
@@ -153,31 +155,61 @@ func titleCasedName(name string) string {
 
 type HashMap struct {
 	Name string
+	c    *Client
 }
 
+//NewHashMap 使用默认客户端, 见Client.NewHashMap
 func NewHashMap(name string) *HashMap {
-	Do("PING")
-	return &HashMap{name}
+	return std.NewHashMap(name)
+}
+
+//client HashMap{Name: name}直接创建时使用默认客户端
+func (this *HashMap) client() *Client {
+	if this.c == nil {
+		return std
+	}
+	return this.c
 }
 
 func (this *HashMap) SetExpire(second int) error {
-	return Send("EXPIRE", this.Name, second)
+	return this.client().Send("EXPIRE", this.Name, second)
 }
 
 func (this *HashMap) SetObject(k string, v interface{}) error {
-	b, err := json.Marshal(v)
+	b, err := this.client().codec.Marshal(v)
 	if err != nil {
 		return err
 	}
-	return Send("HSET", this.Name, k, b)
+	return this.client().Send("HSET", this.Name, k, b)
+}
+
+//SetObjects 批量设置, 每batchSize个字段一条HMSET, 通过Pipeline一次发送
+func (this *HashMap) SetObjects(objs map[string]interface{}) error {
+	p := this.client().Pipeline()
+	args := []interface{}{this.Name}
+	for k, v := range objs {
+		b, err := this.client().codec.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if args = append(args, k, b); len(args) > batchSize*2 {
+			p.Queue("HMSET", args...)
+			args = []interface{}{this.Name}
+		}
+	}
+	if len(args) > 1 {
+		p.Queue("HMSET", args...)
+	}
+	_, err := p.Exec()
+	return err
 }
 
 func (this *HashMap) GetObject(k string, clazz interface{}) error {
-	b, err := redis.Bytes(Do("HGET", this.Name, k))
+	b, err := redis.Bytes(this.client().Do("HGET", this.Name, k))
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(b, clazz)
+	return this.client().codec.Unmarshal(b, clazz)
 }
 
 //func (orm *HashMap) ScanPK(output interface{}) *Model {
@@ -227,11 +259,11 @@ func (this *HashMap) GetObject(k string, clazz interface{}) error {
 //}
 
 func (this *HashMap) SetString(k string, v string) error {
-	return Send("HSET", this.Name, k, v)
+	return this.client().Send("HSET", this.Name, k, v)
 }
 
 func (this *HashMap) GetString(k string) (string, error) {
-	str, err := redis.String(Do("HGET", this.Name, k))
+	str, err := redis.String(this.client().Do("HGET", this.Name, k))
 	if err == nil {
 		str = strings.Trim(str, "\"")
 	}
@@ -244,7 +276,7 @@ func (this *HashMap) GetStringList(k []string) ([]string, error) {
 	for _, v := range k {
 		args = append(args, v)
 	}
-	reply, err := redis.MultiBulk(Do("HMGET", args...))
+	reply, err := redis.MultiBulk(this.client().Do("HMGET", args...))
 	if err != nil {
 		return nil, err
 	}
@@ -261,15 +293,15 @@ func (this *HashMap) GetStringList(k []string) ([]string, error) {
 }
 
 func (this *HashMap) Size() (int, error) {
-	return redis.Int(Do("HLEN", this.Name))
+	return redis.Int(this.client().Do("HLEN", this.Name))
 }
 
 func (this *HashMap) Del(k string) error {
-	return Send("HDEL", this.Name, k)
+	return this.client().Send("HDEL", this.Name, k)
 }
 
 func (this *HashMap) Exists(k string) bool {
-	v, err := redis.Bool(Do("HEXISTS", this.Name, k))
+	v, err := redis.Bool(this.client().Do("HEXISTS", this.Name, k))
 	if err != nil {
 		return false
 	}
@@ -277,169 +309,5 @@ func (this *HashMap) Exists(k string) bool {
 }
 
 func (this *HashMap) Clear() error {
-	return Send("DEL", this.Name)
-}
-
-type SortedSet struct {
-	Name string
-}
-
-func NewSortedSet(name string) *SortedSet {
-	Do("PING")
-	return &SortedSet{name}
-}
-
-func (this *SortedSet) SetExpire(second int) error {
-	return Send("EXPIRE", this.Name, second)
-}
-
-func (this *SortedSet) AddObject(score float64, v interface{}) error {
-	b, err := json.Marshal(v)
-	if err != nil {
-		return err
-	}
-	return Send("ZADD", this.Name, score, b)
-}
-
-func (this *SortedSet) AddString(score float64, v string) error {
-	return Send("ZADD", this.Name, score, v)
-}
-
-func (this *SortedSet) Size() int {
-	b, err := redis.Int(Do("ZCARD", this.Name))
-	if err != nil {
-		return -1
-	}
-	return b
-}
-
-func (this *SortedSet) SizeByScore(min, max float64) int {
-	b, err := redis.Int(Do("ZCOUNT", this.Name, min, max))
-	if err != nil {
-		return -1
-	}
-	return b
-}
-
-func (this *SortedSet) GetObject(index int, clazz interface{}) error {
-	b, err := redis.Bytes(Do("ZRANGE", this.Name, index, index+1))
-	if err != nil {
-		return err
-	}
-	err = json.Unmarshal(b, clazz)
-	return err
-}
-
-//func (this *SortedSet) GetObjects(clazz []interface{}, start, limit int) error {
-//	b, err := redis.MultiBulk(Do("ZRANGE", this.Name, start, start+limit))
-//	if err != nil {
-//		return err
-//	}
-//	for i, v := range b {
-//		bb, err := redis.Bytes(v, nil)
-//		if err != nil {
-//			break
-//		}
-//		err = json.Unmarshal(bb, &clazz[i])
-//		if err != nil {
-//			break
-//		}
-//	}
-//	return err
-//}
-
-func (this *SortedSet) RemoveObject(v interface{}) error {
-	b, err := json.Marshal(v)
-	if err != nil {
-		return err
-	}
-	return Send("ZREM", this.Name, b)
-}
-
-func (this *SortedSet) GetString(index int) (string, error) {
-	str, err := redis.String(Do("ZRANGE", this.Name, index, index+1))
-	if err == nil {
-		str = strings.Trim(str, "\"")
-	}
-	return str, err
-}
-
-func (this *SortedSet) GetStrings(start, limit int) ([]string, error) {
-	a, err := Do("ZRANGE", this.Name, start, start+limit)
-	if err != nil {
-		return nil, err
-	}
-	b, err := redis.MultiBulk(a, err)
-	if err != nil {
-		return nil, err
-	}
-
-	var list = make([]string, 0)
-	for _, v := range b {
-		s, err := redis.String(v, nil)
-		if err != nil {
-			break
-		}
-		s = strings.Trim(s, "\"")
-		list = append(list, s)
-	}
-	return list, err
-}
-
-func (this *SortedSet) GetStringsRev(start, limit int) ([]string, error) {
-	a, err := Do("ZREVRANGE", this.Name, start, start+limit)
-	if err != nil {
-		return nil, err
-	}
-	b, err := redis.MultiBulk(a, err)
-	if err != nil {
-		return nil, err
-	}
-
-	var list = make([]string, 0)
-	for _, v := range b {
-		s, err := redis.String(v, nil)
-		if err != nil {
-			break
-		}
-		s = strings.Trim(s, "\"")
-		list = append(list, s)
-	}
-	return list, err
-}
-
-func (this *SortedSet) RemoveString(v string) error {
-	return Send("ZREM", this.Name, v)
-}
-
-func (this *SortedSet) Remove(start, limit int) error {
-	return Send("ZREMRANGEBYRANK", this.Name, start, start+limit-1)
-}
-
-func (this *SortedSet) RemoveByIndex(index int) error {
-	return Send("ZREMRANGEBYRANK", this.Name, index, index)
-}
-
-func (this *SortedSet) ObjectScore(v interface{}) int {
-	b, err := json.Marshal(v)
-	if err != nil {
-		return -1
-	}
-	r, err := redis.Int(Do("ZINCRBY", this.Name, b))
-	if err != nil {
-		return -1
-	}
-	return r
-}
-
-func (this *SortedSet) StringScore(v string) int {
-	r, err := redis.Int(Do("ZINCRBY", this.Name, v))
-	if err != nil {
-		return -1
-	}
-	return r
-}
-
-func (this *SortedSet) Clear() error {
-	return Send("DEL", this.Name)
+	return this.client().Send("DEL", this.Name)
 }
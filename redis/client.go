@@ -0,0 +1,235 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/codec"
+	"github.com/tryor/commons/redisutil"
+)
+
+//ErrNotInitialized 客户端没有连接池, 如调用包级函数前没有调用CacheInit
+var ErrNotInitialized = errors.New("redis: client not initialized, call CacheInit first")
+
+//Client redis客户端, 拥有自己的连接池及序列化方式, 可以同时连接多个redis或数据库
+//包级函数使用DefaultClient
+type Client struct {
+	pool  *redisutil.Pool
+	codec codec.Codec //SetObject/GetObject及HashMap.SetObject/GetObject的序列化方式
+}
+
+//NewClient 使用与cache的redis适配器相同的配置创建客户端
+//config - {"mode":"", "addr":"127.0.0.1:6379", "password":"", "dbNum":"1"}
+//配置项见redisutil.ParseOptions
+func NewClient(config string) (*Client, error) {
+	var cf map[string]string
+	if err := json.Unmarshal([]byte(config), &cf); err != nil {
+		return nil, err
+	}
+	p, err := newPoolConfig(cf)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{pool: p, codec: codec.JSON}, nil
+}
+
+//NewClientOptions 使用连接池选项创建客户端
+func NewClientOptions(opts redisutil.Options) (*Client, error) {
+	p, err := newPool(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{pool: p, codec: codec.JSON}, nil
+}
+
+//NewClientPool 使用已有的连接池创建客户端, 如cache的redis适配器的连接池
+//客户端与连接池的其它使用者共享连接, 不应调用Close
+func NewClientPool(p *redisutil.Pool) *Client {
+	return &Client{pool: p, codec: codec.JSON}
+}
+
+//newPool 创建连接池, 新连接上预先加载已注册的脚本
+func newPool(opts redisutil.Options) (*redisutil.Pool, error) {
+	onConnect := opts.OnConnect
+	opts.OnConnect = func(c redis.Conn) error {
+		if onConnect != nil {
+			if err := onConnect(c); err != nil {
+				return err
+			}
+		}
+		loadScripts(c)
+		return nil
+	}
+	return redisutil.NewPool(opts)
+}
+
+//newPoolConfig 同redisutil.NewPoolConfig, 见newPool
+func newPoolConfig(cf map[string]string) (*redisutil.Pool, error) {
+	opts, err := redisutil.ParseOptions(cf)
+	if err != nil {
+		return nil, err
+	}
+	return newPool(opts)
+}
+
+//SetCodec 设置SetObject/GetObject及HashMap.SetObject/GetObject的序列化方式, 默认：codec.JSON
+//SortedSet成员始终使用json, 以保证相同对象对应相同成员
+func (c *Client) SetCodec(cd codec.Codec) {
+	c.codec = cd
+}
+
+//Close 关闭连接池
+func (c *Client) Close() error {
+	if c.pool == nil {
+		return nil
+	}
+	return c.pool.Close()
+}
+
+//Conn 返回连接池中的连接, cluster模式为第一个slot所在节点的连接, 使用后调用Close
+func (c *Client) Conn() redis.Conn {
+	if c.pool == nil {
+		return redisutil.ErrorConn(ErrNotInitialized)
+	}
+	return c.pool.Conn("")
+}
+
+//Send 只发送命令不读取结果, sentinel和cluster模式同Do, 以便处理重定向和故障切换
+func (c *Client) Send(cmd string, args ...interface{}) error {
+	if c.pool == nil {
+		return ErrNotInitialized
+	}
+	if !c.pool.IsStandalone() {
+		_, err := c.Do(cmd, args...)
+		return err
+	}
+	red := c.Conn()
+	defer red.Close()
+	err := red.Send(cmd, args...)
+	//log.Debugf("Send %v %v %v", cmd, args, err)
+	if err != nil {
+		return err
+	}
+	return red.Flush()
+}
+
+//Do 执行命令, args的第一个参数为key, cluster模式按key选择节点
+func (c *Client) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if c.pool == nil {
+		return nil, ErrNotInitialized
+	}
+	//log.Debugf("Do %v %v", cmd, args)
+	return c.pool.Exec(context.Background(), firstKey(args), func(red redis.Conn) (interface{}, error) {
+		return red.Do(cmd, args...)
+	})
+}
+
+//doKey 在key所在节点上执行命令, 用于第一个参数不是key的命令
+//timeout>0时为读超时, 用于阻塞命令, 0为一直等待
+func (c *Client) doKey(key string, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if c.pool == nil {
+		return nil, ErrNotInitialized
+	}
+	return c.pool.Exec(context.Background(), key, func(red redis.Conn) (interface{}, error) {
+		return redis.DoWithTimeout(red, timeout, cmd, args...)
+	})
+}
+
+//blockTimeout 阻塞命令的读超时, 比命令的超时时间(秒)多1秒, 0为一直等待
+func blockTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds+1) * time.Second
+}
+
+//firstKey 命令的第一个参数为字符串时作为key
+func firstKey(args []interface{}) string {
+	if len(args) > 0 {
+		key, _ := args[0].(string)
+		return key
+	}
+	return ""
+}
+
+func (c *Client) SetString(k string, v string, expire ...int) error {
+	//SET key value [EX seconds]
+	if len(expire) > 0 {
+		return c.Send("SET", k, v, "EX", expire[0]) //return Send("SET", k, v, "EX "+strconv.Itoa(expire[0]))
+	} else {
+		return c.Send("SET", k, v)
+	}
+}
+
+func (c *Client) GetString(k string) (string, error) {
+	str, err := redis.String(c.Do("GET", k))
+	if err == nil {
+		str = strings.Trim(str, "\"")
+	}
+	return str, err
+}
+
+func (c *Client) SetObject(k string, v interface{}, expire ...int) error {
+	b, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(expire) > 0 {
+		return c.Send("SET", k, b, "EX", expire[0])
+	} else {
+		return c.Send("SET", k, b)
+	}
+}
+
+func (c *Client) GetObject(k string, clazz interface{}) error {
+	b, err := redis.Bytes(c.Do("GET", k))
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(b, clazz)
+}
+
+func (c *Client) Del(k string) error {
+	return c.Send("DEL", k)
+}
+
+func (c *Client) TTL(k string) (int, error) {
+	b, err := redis.Int(c.Do("TTL", k))
+	if err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func (c *Client) NewHashMap(name string) *HashMap {
+	c.Do("PING")
+	return &HashMap{Name: name, c: c}
+}
+
+func (c *Client) NewSortedSet(name string) *SortedSet {
+	c.Do("PING")
+	return &SortedSet{Name: name, c: c}
+}
+
+//unmarshalSlice 使用cd将items反序列化后追加到slicePtr指向的slice
+func unmarshalSlice(cd codec.Codec, items [][]byte, slicePtr interface{}) error {
+	v := reflect.ValueOf(slicePtr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return errors.New("redis: slicePtr must be a pointer to slice")
+	}
+	slice := v.Elem()
+	for _, b := range items {
+		e := reflect.New(slice.Type().Elem())
+		if err := cd.Unmarshal(b, e.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, e.Elem())
+	}
+	v.Elem().Set(slice)
+	return nil
+}
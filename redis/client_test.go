@@ -0,0 +1,787 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/event"
+	"github.com/tryor/commons/redisutil"
+	"github.com/tryor/commons/redisutil/redistest"
+)
+
+func Test_NotInitialized(t *testing.T) {
+	c := &Client{}
+	if _, err := c.Do("GET", "k"); err != ErrNotInitialized {
+		t.Fatal(err)
+	}
+	if err := c.SetString("k", "v"); err != ErrNotInitialized {
+		t.Fatal(err)
+	}
+	if err := c.NewHashMap("h").SetString("k", "v"); err != ErrNotInitialized {
+		t.Fatal(err)
+	}
+	if _, err := c.Pipeline().Exec(); err != nil {
+		t.Fatal("empty pipeline", err)
+	}
+}
+
+func Test_Pipeline(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := NewClient(`{"addr":"` + s.Addr + `"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	p := c.Pipeline()
+	p.Queue("SET", "k", "v")
+	get := p.Queue("GET", "k")
+	incr := p.Queue("INCRBY", "n", 5)
+	bad := p.Queue("NOSUCHCMD", "k")
+	none := p.Queue("GET", "none")
+	results, err := p.Exec()
+	if err == nil || bad.Err == nil {
+		t.Fatal("error expected")
+	}
+	if len(results) != 5 || results[1] != get || p.Len() != 0 {
+		t.Fatal("results", results)
+	}
+	if v, err := get.String(); err != nil || v != "v" {
+		t.Fatal("GET", v, err)
+	}
+	if n, err := incr.Int(); err != nil || n != 5 {
+		t.Fatal("INCRBY", n, err)
+	}
+	if _, err := none.String(); err != redis.ErrNil {
+		t.Fatal("GET none", err)
+	}
+}
+
+func Test_PipelineCluster(t *testing.T) {
+	cluster, err := redistest.NewCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	c, err := NewClientOptions(redisutil.Options{Mode: redisutil.ModeCluster, Addrs: cluster.Addrs()[:1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	p := c.Pipeline()
+	for i := 0; i < 10; i++ {
+		key := "k" + strconv.Itoa(i)
+		p.Queue("SET", key, key)
+	}
+	if _, err = p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	cluster.Move(redisutil.Slot("k3"), 0)
+	cluster.Move(redisutil.Slot("k7"), 1)
+	for i := 0; i < 10; i++ {
+		p.Queue("GET", "k"+strconv.Itoa(i))
+	}
+	results, err := p.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if v, err := r.String(); err != nil || v != "k"+strconv.Itoa(i) {
+			t.Fatal(i, v, err)
+		}
+	}
+}
+
+func Test_Watch(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := NewClient(`{"addr":"` + s.Addr + `"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s.Set("counter", "1")
+	calls := 0
+	var set *Result
+	err = c.Watch(func(tx *Tx) error {
+		calls++
+		n, err := redis.Int(tx.Do("GET", "counter"))
+		if err != nil {
+			return err
+		}
+		//第一次执行时其它客户端修改了counter
+		if calls == 1 {
+			s.Set("counter", "10")
+		}
+		set = tx.Queue("SET", "counter", n+1)
+		return nil
+	}, "counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Value("counter"); calls != 2 || v != "11" {
+		t.Fatal("calls", calls, "counter", v)
+	}
+	if v, err := set.String(); err != nil || v != "OK" {
+		t.Fatal("SET", v, err)
+	}
+
+	err = c.Watch(func(tx *Tx) error {
+		s.Set("counter", "0")
+		tx.Queue("SET", "counter", "1")
+		return nil
+	}, "counter")
+	if err != ErrTxFailed {
+		t.Fatal("ErrTxFailed expected", err)
+	}
+
+	if err = c.Multi(func(tx *Tx) error {
+		tx.Queue("SET", "a", "1")
+		tx.Queue("INCRBY", "a", 2)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Value("a"); v != "3" {
+		t.Fatal("MULTI", v)
+	}
+}
+
+func Test_Bulk(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := NewClient(`{"addr":"` + s.Addr + `"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	objs := make(map[string]interface{})
+	members := make([]Z, 0, batchSize+10)
+	for i := 0; i < batchSize+10; i++ {
+		objs[strconv.Itoa(i)] = map[string]int{"i": i}
+		members = append(members, Z{Score: float64(i), Member: strconv.Itoa(i)})
+	}
+	h := c.NewHashMap("h")
+	if err = h.SetObjects(objs); err != nil {
+		t.Fatal(err)
+	}
+	if s.CommandCount("HMSET") != 2 {
+		t.Fatal("HMSET not batched")
+	}
+	var v map[string]int
+	if err = h.GetObject("1005", &v); err != nil || v["i"] != 1005 {
+		t.Fatal(v, err)
+	}
+
+	z := c.NewSortedSet("z")
+	if err = z.AddMany(members...); err != nil {
+		t.Fatal(err)
+	}
+	if n := z.Size(); n != batchSize+10 {
+		t.Fatal("Size", n)
+	}
+	if list, err := z.GetStrings(0, 1); err != nil || len(list) != 2 || list[1] != "1" {
+		t.Fatal(list, err)
+	}
+}
+
+func newTestClient(t *testing.T) (*Client, *redistest.Server) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(`{"addr":"` + s.Addr + `"}`)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return c, s
+}
+
+type item struct {
+	ID   int
+	Name string
+}
+
+func Test_List(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	defer c.Close()
+
+	l := c.NewList("queue")
+	if n, err := l.RPushObject(item{1, "a"}, item{2, "b"}); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	if n, err := l.LPushObject(item{0, "z"}); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	var items []item
+	if err := l.GetObjects(0, -1, &items); err != nil || len(items) != 3 || items[0].ID != 0 || items[2].Name != "b" {
+		t.Fatal(items, err)
+	}
+	var it item
+	if err := l.BLPopObject(1, &it); err != nil || it.ID != 0 {
+		t.Fatal(it, err)
+	}
+	if err := l.RPopObject(&it); err != nil || it.ID != 2 {
+		t.Fatal(it, err)
+	}
+	if n, err := l.RemoveObject(item{1, "a"}); err != nil || n != 1 {
+		t.Fatal("RemoveObject", n, err)
+	}
+	if _, err := l.BRPopString(1); err != redis.ErrNil {
+		t.Fatal("ErrNil expected", err)
+	}
+
+	l.RPushString("1", "2", "3", "4")
+	if err := l.Trim(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := l.GetStrings(0, -1); err != nil || len(list) != 2 || list[0] != "2" {
+		t.Fatal(list, err)
+	}
+	if n, _ := l.Size(); n != 2 {
+		t.Fatal("Size", n)
+	}
+}
+
+func Test_Set(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	defer c.Close()
+
+	a, b := c.NewSet("a"), c.NewSet("b")
+	a.AddString("1", "2", "3")
+	b.AddString("2", "3", "4")
+	if !a.ExistsString("1") || a.ExistsString("4") {
+		t.Fatal("ExistsString")
+	}
+	cases := []struct {
+		fn   func(...*Set) ([]string, error)
+		want string
+	}{
+		{a.Inter, "2,3"},
+		{a.Union, "1,2,3,4"},
+		{a.Diff, "1"},
+	}
+	for _, cs := range cases {
+		if list, err := cs.fn(b); err != nil || strings.Join(list, ",") != cs.want {
+			t.Fatal(list, err, cs.want)
+		}
+	}
+	a.RemoveString("1")
+	if n, _ := a.Size(); n != 2 {
+		t.Fatal("Size", n)
+	}
+
+	o := c.NewSet("objects")
+	o.AddObject(item{1, "a"}, item{2, "b"})
+	if !o.ExistsObject(item{2, "b"}) {
+		t.Fatal("ExistsObject")
+	}
+	var items []item
+	if err := o.GetObjects(&items); err != nil || len(items) != 2 {
+		t.Fatal(items, err)
+	}
+}
+
+func Test_HyperLogLog(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	defer c.Close()
+
+	a, b := c.NewHyperLogLog("uv:1"), c.NewHyperLogLog("uv:2")
+	a.AddString("u1", "u2", "u1")
+	b.AddObject(item{1, "u3"})
+	b.AddString("u2")
+	if n, err := a.Count(); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	if n, err := a.Count(b); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := a.Count(); n != 3 {
+		t.Fatal("Merge", n)
+	}
+}
+
+func Test_Stream(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	defer c.Close()
+
+	st := c.NewStream("events")
+	st.MaxLen = 100
+	if err := st.CreateGroup("workers", "$"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateGroup("workers", "$"); err != nil {
+		t.Fatal("BUSYGROUP not ignored", err)
+	}
+	id, err := st.AddObject(item{1, "created"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := st.Add(map[string]interface{}{"type": "deleted", "id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.CommandCount("XADD events MAXLEN ~ 100") != 2 {
+		t.Fatal("MAXLEN not used")
+	}
+
+	msgs, err := st.ReadGroup("workers", "w1", 10, 1)
+	if err != nil || len(msgs) != 2 || msgs[0].ID != id {
+		t.Fatal(msgs, err)
+	}
+	var it item
+	if err = msgs[0].Object(&it); err != nil || it.Name != "created" {
+		t.Fatal(it, err)
+	}
+	if msgs[1].Values["type"] != "deleted" {
+		t.Fatal(msgs[1].Values)
+	}
+	if msgs, err = st.ReadGroup("workers", "w2", 10, -1); err != nil || len(msgs) != 0 {
+		t.Fatal("no new messages expected", msgs, err)
+	}
+
+	if n, err := st.Ack("workers", id); err != nil || n != 1 {
+		t.Fatal("Ack", n, err)
+	}
+	pending, err := st.Pending("workers", 10, "w1")
+	if err != nil || len(pending) != 1 || pending[0].ID != id2 || pending[0].Consumer != "w1" || pending[0].Deliveries != 1 {
+		t.Fatal(pending, err)
+	}
+	if msgs, err = st.ReadPending("workers", "w1", 10); err != nil || len(msgs) != 1 || msgs[0].Values["id"] != "1" {
+		t.Fatal("ReadPending", msgs, err)
+	}
+}
+
+func Test_SortedSet(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	defer c.Close()
+
+	z := c.NewSortedSet("board")
+	z.AddString(10, "a")
+	z.AddString(20, "b")
+	z.AddString(20.5, "c")
+	z.AddString(30, "d")
+	if score, err := z.StringScore("c"); err != nil || score != 20.5 {
+		t.Fatal("StringScore", score, err)
+	}
+	if _, err := z.StringScore("none"); err != redis.ErrNil {
+		t.Fatal("ErrNil expected", err)
+	}
+	if score, err := z.IncrStringScore("a", 15.5); err != nil || score != 25.5 {
+		t.Fatal("IncrStringScore", score, err)
+	}
+	if r, err := z.StringRank("a"); err != nil || r != 2 {
+		t.Fatal("StringRank", r, err)
+	}
+	if r, err := z.StringRevRank("d"); err != nil || r != 0 {
+		t.Fatal("StringRevRank", r, err)
+	}
+	if _, err := z.StringRank("none"); err != redis.ErrNil {
+		t.Fatal("ErrNil expected", err)
+	}
+
+	cases := []struct {
+		min, max      ScoreBound
+		offset, limit int
+		want          string
+	}{
+		{NegInf, PosInf, 0, 0, "b,c,a,d"},
+		{Exclusive(20), Inclusive(30), 0, 0, "c,a,d"},
+		{NegInf, PosInf, 1, 2, "c,a"},
+		{Inclusive(20), Exclusive(25.5), 1, 0, "c"},
+	}
+	for _, cs := range cases {
+		if list, err := z.RangeByScore(cs.min, cs.max, cs.offset, cs.limit); err != nil || strings.Join(list, ",") != cs.want {
+			t.Fatal(cs, list, err)
+		}
+	}
+	if list, err := z.RevRangeByScore(PosInf, Exclusive(20), 0, 2); err != nil || strings.Join(list, ",") != "d,a" {
+		t.Fatal("RevRangeByScore", list, err)
+	}
+	top, err := z.RevRangeWithScores(0, 1)
+	if err != nil || len(top) != 2 || top[0] != (Z{30, "d"}) || top[1] != (Z{25.5, "a"}) {
+		t.Fatal("RevRangeWithScores", top, err)
+	}
+	if list, err := z.RangeByScoreWithScores(Inclusive(20), Inclusive(20.5), 0, 0); err != nil || len(list) != 2 || list[1] != (Z{20.5, "c"}) {
+		t.Fatal("RangeByScoreWithScores", list, err)
+	}
+
+	if list, err := z.PopMin(1); err != nil || len(list) != 1 || list[0] != (Z{20, "b"}) {
+		t.Fatal("PopMin", list, err)
+	}
+	if list, err := z.PopMax(2); err != nil || len(list) != 2 || list[1].Member != "a" {
+		t.Fatal("PopMax", list, err)
+	}
+	if n := z.Size(); n != 1 {
+		t.Fatal("Size", n)
+	}
+
+	o := c.NewSortedSet("objects")
+	for i := 1; i <= 5; i++ {
+		o.AddObject(float64(i), item{i, strconv.Itoa(i)})
+	}
+	var items []item
+	if err = o.RevRangeByScoreObjects(PosInf, NegInf, 0, 3, &items); err != nil || len(items) != 3 || items[0].ID != 5 {
+		t.Fatal(items, err)
+	}
+	if r, err := o.RevRank(item{4, "4"}); err != nil || r != 1 {
+		t.Fatal("RevRank object", r, err)
+	}
+	if score, err := o.ObjectScore(item{2, "2"}); err != nil || score != 2 {
+		t.Fatal("ObjectScore", score, err)
+	}
+	var it item
+	if err = o.GetObject(1, &it); err != nil || it.ID != 2 {
+		t.Fatal("GetObject", it, err)
+	}
+	if n, err := o.RemoveByScore(NegInf, Exclusive(3)); err != nil || n != 2 {
+		t.Fatal("RemoveByScore", n, err)
+	}
+	items = nil
+	if err = o.GetObjects(0, -1, &items); err != nil || len(items) != 3 || items[0].ID != 3 {
+		t.Fatal("GetObjects", items, err)
+	}
+
+	//对象成员的方法使用相同的编码, string对象也使用json
+	m := c.NewSortedSet("mixed")
+	m.AddObject(1, "alice")
+	m.AddMany(Z{2, "bob"}, Z{3, item{1, "1"}})
+	if r, err := m.Rank("alice"); err != nil || r != 0 {
+		t.Fatal("Rank mixed", r, err)
+	}
+	if score, err := m.IncrScore("alice", 5); err != nil || score != 6 {
+		t.Fatal("IncrScore mixed", score, err)
+	}
+	if r, err := m.RevRank("alice"); err != nil || r != 0 {
+		t.Fatal("RevRank mixed", r, err)
+	}
+	if score, err := m.ObjectScore("bob"); err != nil || score != 2 {
+		t.Fatal("ObjectScore mixed", score, err)
+	}
+	if r, err := m.Rank(item{1, "1"}); err != nil || r != 1 {
+		t.Fatal("Rank object", r, err)
+	}
+	if _, err := m.StringScore("alice"); err != redis.ErrNil {
+		t.Fatal("string member is not json", err)
+	}
+	if err = m.RemoveObject("bob"); err != nil || m.Size() != 2 {
+		t.Fatal("RemoveObject mixed", m.Size(), err)
+	}
+	if list, err := m.GetStrings(0, -1); err != nil || strings.Join(list, ",") != "{\"ID\":1,\"Name\":\"1\"},alice" {
+		t.Fatal("GetStrings mixed", list, err)
+	}
+
+	//返回的字符串成员再次传给Rank等方法时对应同一个成员
+	r := c.NewSortedSet("roundtrip")
+	r.AddObject(1, "a")
+	r.AddObject(2, `say "hi"`)
+	r.AddMany(Z{3, "c"})
+	list, err := r.RangeByScore(NegInf, PosInf, 0, 0)
+	if err != nil || strings.Join(list, ",") != `a,say "hi",c` {
+		t.Fatal("RangeByScore roundtrip", list, err)
+	}
+	for i, member := range list {
+		if rank, err := r.Rank(member); err != nil || rank != i {
+			t.Fatal("Rank roundtrip", member, rank, err)
+		}
+	}
+	if list, err = r.RevRangeByScore(PosInf, NegInf, 0, 1); err != nil || len(list) != 1 || list[0] != "c" {
+		t.Fatal("RevRangeByScore roundtrip", list, err)
+	}
+	zs, err := r.RangeByScoreWithScores(Inclusive(2), PosInf, 0, 0)
+	if err != nil || len(zs) != 2 || zs[0] != (Z{2, `say "hi"`}) {
+		t.Fatal("RangeByScoreWithScores roundtrip", zs, err)
+	}
+	if score, err := r.ObjectScore(zs[0].Member); err != nil || score != 2 {
+		t.Fatal("ObjectScore roundtrip", score, err)
+	}
+	if s, err := r.GetString(1); err != nil || s != `say "hi"` {
+		t.Fatal("GetString roundtrip", s, err)
+	}
+}
+
+//waitFor 等待cond成立, 最多等待5秒
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 500 && !cond(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if !cond() {
+		t.Fatal("timeout")
+	}
+}
+
+func receive(t *testing.T, msgs <-chan *Message) *Message {
+	select {
+	case m := <-msgs:
+		return m
+	case <-time.After(time.Second * 5):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func Test_Subscriber(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	defer c.Close()
+
+	sub := c.NewSubscriber(nil)
+	sub.Subscribe("news")
+	sub.PSubscribe("user.*")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sub.Run(ctx) }()
+	numSub := func() int {
+		//CloseConns后连接池中的连接也已关闭, 出错时返回-1
+		reply, err := redis.Values(c.Do("PUBSUB", "NUMSUB", "news"))
+		if err != nil || len(reply) != 2 {
+			return -1
+		}
+		n, _ := redis.Int(reply[1], nil)
+		return n
+	}
+	waitFor(t, func() bool { return numSub() == 1 && s.CommandCount("PSUBSCRIBE") == 1 })
+
+	if n, err := c.Publish("news", "hello"); err != nil || n != 1 {
+		t.Fatal("Publish", n, err)
+	}
+	c.Publish("user.1", item{1, "a"})
+	if m := receive(t, sub.Messages()); m.Channel != "news" || m.String() != "hello" {
+		t.Fatal(m)
+	}
+	m := receive(t, sub.Messages())
+	var it item
+	if err := m.Object(&it); err != nil || m.Pattern != "user.*" || m.Channel != "user.1" || it.Name != "a" {
+		t.Fatal(m, it, err)
+	}
+
+	//断线后重新连接并恢复订阅
+	s.CloseConns()
+	waitFor(t, func() bool { return s.CommandCount("SUBSCRIBE news") == 2 && numSub() == 1 })
+	c.Publish("news", "again")
+	if m := receive(t, sub.Messages()); m.String() != "again" {
+		t.Fatal(m)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+	if _, ok := <-sub.Messages(); ok {
+		t.Fatal("Messages not closed")
+	}
+	if err := sub.Run(context.Background()); err == nil {
+		t.Fatal("Run twice")
+	}
+}
+
+type eventRecorder struct {
+	events chan event.IEvent
+}
+
+func (l *eventRecorder) HandleEvent(e event.IEvent) bool {
+	l.events <- e
+	return true
+}
+
+func Test_SubscriberEvent(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	defer c.Close()
+
+	d := event.NewDispatcher()
+	l := &eventRecorder{events: make(chan event.IEvent, 1)}
+	d.RegisterListener(1, l)
+	sub := c.NewSubscriber(EventHandler(d, 1))
+	sub.Subscribe("events")
+	done := make(chan error, 1)
+	go func() { done <- sub.Run(context.Background()) }()
+	waitFor(t, func() bool {
+		n, _ := c.Publish("events", "created")
+		return n == 1
+	})
+
+	select {
+	case e := <-l.events:
+		if m, ok := e.GetSource().(*Message); !ok || m.String() != "created" {
+			t.Fatal(e.GetSource())
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("no event")
+	}
+	sub.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+var echoScript = RegisterScript("test.echo", 1, "return ARGV[1]")
+
+func Test_Script(t *testing.T) {
+	c, s := newTestClient(t)
+	defer s.Close()
+	defer c.Close()
+
+	s.HandleScript(echoScript.src, func(keys, args []string) interface{} {
+		return keys[0] + ":" + args[0]
+	})
+	if v, err := redis.String(c.Eval(echoScript, "k", "hello")); err != nil || v != "k:hello" {
+		t.Fatal(v, err)
+	}
+	//新连接上已加载全部脚本, 直接使用EVALSHA
+	if n := s.CommandCount("SCRIPT LOAD"); n < 4 {
+		t.Fatal("SCRIPT LOAD", n)
+	}
+	if s.CommandCount("EVALSHA") != 1 || s.CommandCount("EVAL") != 0 {
+		t.Fatal("EVALSHA expected", s.Commands)
+	}
+
+	c.Do("SCRIPT", "FLUSH")
+	if v, err := redis.String(c.Eval(echoScript, "k", "again")); err != nil || v != "k:again" {
+		t.Fatal("NOSCRIPT fallback", v, err)
+	}
+	if s.CommandCount("EVAL") != 1 {
+		t.Fatal("EVAL expected")
+	}
+
+	//参数错误时不执行脚本
+	evals := s.CommandCount("EVALSHA") + s.CommandCount("EVAL")
+	if _, err := c.TakeTokens("bucket", 10, 0, 1); err == nil {
+		t.Fatal("rate 0")
+	}
+	l := c.NewList("capped")
+	if _, err := l.CappedPushString(0, "a"); err == nil {
+		t.Fatal("max 0")
+	}
+	if _, err := l.CappedPushObject(3); err == nil {
+		t.Fatal("no values")
+	}
+	if s.CommandCount("EVALSHA")+s.CommandCount("EVAL") != evals {
+		t.Fatal("invalid arguments sent", s.Commands)
+	}
+	if LookupScript("compareAndDelete") != CompareAndDeleteScript {
+		t.Fatal("LookupScript")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("panic expected")
+		}
+	}()
+	RegisterScript("test.echo", 0, "")
+}
+
+//newLuaClient 连接miniredis, 用于执行真实的lua脚本
+func newLuaClient(t *testing.T) (*Client, *miniredis.Miniredis) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(`{"addr":"` + m.Addr() + `"}`)
+	if err != nil {
+		m.Close()
+		t.Fatal(err)
+	}
+	return c, m
+}
+
+func Test_ScriptLua(t *testing.T) {
+	c, m := newLuaClient(t)
+	defer m.Close()
+	defer c.Close()
+	bucket, lock, capped := "bucket", "lock", "capped"
+
+	r, err := c.TakeTokens(bucket, 3, 1, 2)
+	if err != nil || !r.Allowed || r.Remaining != 1 || r.Wait != 0 {
+		t.Fatal(r, err)
+	}
+	r, err = c.TakeTokens(bucket, 3, 1, 2)
+	if err != nil || r.Allowed || r.Remaining != 1 || r.Wait <= 0 || r.Wait > time.Second {
+		t.Fatal(r, err)
+	}
+	if r, err = c.TakeTokens(bucket, 3, 1, 4); err != nil || r.Allowed || r.Wait != -1 {
+		t.Fatal("n > capacity", r, err)
+	}
+	if ttl := m.TTL(bucket); ttl <= 0 || ttl > time.Second*3 {
+		t.Fatal("bucket not expiring", ttl)
+	}
+	//预约时仍然取出, 剩余令牌为负数, 之后的请求需要等待补充
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	reserved := []int{}
+	for _, n := range []int{2, 2, 1} {
+		v, err := redis.Ints(c.Eval(TokenBucketScript, bucket+"2", 3, 1, now, n, "1"))
+		if err != nil {
+			t.Fatal("reserve", err)
+		}
+		reserved = append(reserved, v...)
+	}
+	if fmt.Sprint(reserved) != "[1 1 0 0 0 1000 0 0 2000]" {
+		t.Fatal("reserve", reserved)
+	}
+	if _, err = c.TakeTokens(bucket, 0, 1, 1); err == nil {
+		t.Fatal("capacity 0")
+	}
+
+	c.Do("SET", lock, "v1")
+	if ok, err := c.CompareAndDelete(lock, "v2"); err != nil || ok {
+		t.Fatal("CompareAndDelete v2", ok, err)
+	}
+	if ok, err := c.CompareAndDelete(lock, "v1"); err != nil || !ok {
+		t.Fatal("CompareAndDelete v1", ok, err)
+	}
+
+	l := c.NewList(capped)
+	if n, err := l.CappedPushString(3, "a", "b"); err != nil || n != 2 {
+		t.Fatal("CappedPushString", n, err)
+	}
+	if n, err := l.CappedPushString(3, "c", "d"); err != nil || n != 3 {
+		t.Fatal("CappedPushString", n, err)
+	}
+	if list, err := l.GetStrings(0, -1); err != nil || strings.Join(list, ",") != "d,c,b" {
+		t.Fatal("GetStrings", list, err)
+	}
+	//参数错误时返回错误, 不修改列表
+	if _, err := l.CappedPushString(0, "e"); err == nil {
+		t.Fatal("max 0")
+	}
+	if _, err := l.CappedPushString(3); err == nil {
+		t.Fatal("no values")
+	}
+	if n, _ := redis.Int(c.Do("LLEN", capped)); n != 3 {
+		t.Fatal("list changed", n)
+	}
+	if n, err := l.CappedPushObject(1, item{1, "a"}); err != nil || n != 1 {
+		t.Fatal("CappedPushObject", n, err)
+	}
+	var items []item
+	if err = l.GetObjects(0, -1, &items); err != nil || len(items) != 1 || items[0].Name != "a" {
+		t.Fatal("GetObjects", items, err)
+	}
+}
@@ -0,0 +1,68 @@
+package redis
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+//HyperLogLog 基数估算, 用于统计不重复元素的数量, 误差约0.81%
+//对象元素使用json; cluster模式Count, Merge的HyperLogLog必须属于同一个slot, 可以使用{tag}
+type HyperLogLog struct {
+	Name string
+	c    *Client
+}
+
+func (c *Client) NewHyperLogLog(name string) *HyperLogLog {
+	return &HyperLogLog{Name: name, c: c}
+}
+
+//NewHyperLogLog 使用默认客户端, 见Client.NewHyperLogLog
+func NewHyperLogLog(name string) *HyperLogLog {
+	return std.NewHyperLogLog(name)
+}
+
+//client HyperLogLog{Name: name}直接创建时使用默认客户端
+func (this *HyperLogLog) client() *Client {
+	if this.c == nil {
+		return std
+	}
+	return this.c
+}
+
+func (this *HyperLogLog) SetExpire(second int) error {
+	return this.client().Send("EXPIRE", this.Name, second)
+}
+
+func (this *HyperLogLog) AddObject(vs ...interface{}) error {
+	args, err := jsonArgs(this.Name, vs)
+	if err != nil {
+		return err
+	}
+	return this.client().Send("PFADD", args...)
+}
+
+func (this *HyperLogLog) AddString(vs ...string) error {
+	return this.client().Send("PFADD", stringArgs(this.Name, vs)...)
+}
+
+//Count 返回不重复元素的估算数量, 指定others时为并集的数量
+func (this *HyperLogLog) Count(others ...*HyperLogLog) (int64, error) {
+	return redis.Int64(this.client().Do("PFCOUNT", this.hllArgs(others)...))
+}
+
+//Merge 将others合并到当前HyperLogLog
+func (this *HyperLogLog) Merge(others ...*HyperLogLog) error {
+	return this.client().Send("PFMERGE", this.hllArgs(others)...)
+}
+
+func (this *HyperLogLog) hllArgs(others []*HyperLogLog) []interface{} {
+	args := make([]interface{}, 0, len(others)+1)
+	args = append(args, this.Name)
+	for _, o := range others {
+		args = append(args, o.Name)
+	}
+	return args
+}
+
+func (this *HyperLogLog) Clear() error {
+	return this.client().Send("DEL", this.Name)
+}
@@ -0,0 +1,224 @@
+package redis
+
+import (
+	"errors"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+//List redis列表, 可作为队列使用, 对象使用客户端的序列化方式
+type List struct {
+	Name string
+	c    *Client
+}
+
+func (c *Client) NewList(name string) *List {
+	return &List{Name: name, c: c}
+}
+
+//NewList 使用默认客户端, 见Client.NewList
+func NewList(name string) *List {
+	return std.NewList(name)
+}
+
+//client List{Name: name}直接创建时使用默认客户端
+func (this *List) client() *Client {
+	if this.c == nil {
+		return std
+	}
+	return this.c
+}
+
+func (this *List) SetExpire(second int) error {
+	return this.client().Send("EXPIRE", this.Name, second)
+}
+
+//LPushObject 在头部添加, 返回添加后的长度
+func (this *List) LPushObject(vs ...interface{}) (int, error) {
+	return this.pushObjects("LPUSH", vs)
+}
+
+//RPushObject 在尾部添加, 返回添加后的长度
+func (this *List) RPushObject(vs ...interface{}) (int, error) {
+	return this.pushObjects("RPUSH", vs)
+}
+
+func (this *List) pushObjects(cmd string, vs []interface{}) (int, error) {
+	args := make([]interface{}, 0, len(vs)+1)
+	args = append(args, this.Name)
+	for _, v := range vs {
+		b, err := this.client().codec.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, b)
+	}
+	return redis.Int(this.client().Do(cmd, args...))
+}
+
+//CappedPushObject 在头部添加并只保留前max个元素, 如最近的max条记录, 返回添加后的长度
+//max必须大于0, 且至少添加一个元素
+func (this *List) CappedPushObject(max int, vs ...interface{}) (int, error) {
+	if err := checkCapped(max, len(vs)); err != nil {
+		return 0, err
+	}
+	args := make([]interface{}, 0, len(vs)+2)
+	args = append(args, this.Name, max)
+	for _, v := range vs {
+		b, err := this.client().codec.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, b)
+	}
+	return redis.Int(this.client().Eval(CappedPushScript, args...))
+}
+
+//CappedPushString 同CappedPushObject
+func (this *List) CappedPushString(max int, vs ...string) (int, error) {
+	if err := checkCapped(max, len(vs)); err != nil {
+		return 0, err
+	}
+	args := make([]interface{}, 0, len(vs)+2)
+	args = append(args, this.Name, max)
+	for _, v := range vs {
+		args = append(args, v)
+	}
+	return redis.Int(this.client().Eval(CappedPushScript, args...))
+}
+
+//checkCapped max<=0时LTRIM会清空或保留错误的范围, 没有元素时LPUSH出错
+func checkCapped(max, n int) error {
+	if max <= 0 {
+		return errors.New("redis: max must be positive")
+	}
+	if n == 0 {
+		return errors.New("redis: no values to push")
+	}
+	return nil
+}
+
+func (this *List) LPushString(vs ...string) (int, error) {
+	return redis.Int(this.client().Do("LPUSH", stringArgs(this.Name, vs)...))
+}
+
+func (this *List) RPushString(vs ...string) (int, error) {
+	return redis.Int(this.client().Do("RPUSH", stringArgs(this.Name, vs)...))
+}
+
+//LPopObject 从头部取出, 列表为空时返回redis.ErrNil
+func (this *List) LPopObject(clazz interface{}) error {
+	b, err := redis.Bytes(this.client().Do("LPOP", this.Name))
+	if err != nil {
+		return err
+	}
+	return this.client().codec.Unmarshal(b, clazz)
+}
+
+//RPopObject 从尾部取出, 列表为空时返回redis.ErrNil
+func (this *List) RPopObject(clazz interface{}) error {
+	b, err := redis.Bytes(this.client().Do("RPOP", this.Name))
+	if err != nil {
+		return err
+	}
+	return this.client().codec.Unmarshal(b, clazz)
+}
+
+func (this *List) LPopString() (string, error) {
+	return redis.String(this.client().Do("LPOP", this.Name))
+}
+
+func (this *List) RPopString() (string, error) {
+	return redis.String(this.client().Do("RPOP", this.Name))
+}
+
+//BLPopObject 从头部取出, 列表为空时最多等待timeout秒, 0为一直等待, 超时返回redis.ErrNil
+func (this *List) BLPopObject(timeout int, clazz interface{}) error {
+	b, err := this.bpop("BLPOP", timeout)
+	if err != nil {
+		return err
+	}
+	return this.client().codec.Unmarshal(b, clazz)
+}
+
+//BRPopObject 从尾部取出, 同BLPopObject
+func (this *List) BRPopObject(timeout int, clazz interface{}) error {
+	b, err := this.bpop("BRPOP", timeout)
+	if err != nil {
+		return err
+	}
+	return this.client().codec.Unmarshal(b, clazz)
+}
+
+//BLPopString 同BLPopObject
+func (this *List) BLPopString(timeout int) (string, error) {
+	return redis.String(this.bpop("BLPOP", timeout))
+}
+
+//BRPopString 同BRPopObject
+func (this *List) BRPopString(timeout int) (string, error) {
+	return redis.String(this.bpop("BRPOP", timeout))
+}
+
+//bpop 执行BLPOP/BRPOP, 返回取出的值
+func (this *List) bpop(cmd string, timeout int) ([]byte, error) {
+	reply, err := redis.ByteSlices(this.client().doKey(this.Name, blockTimeout(timeout), cmd, this.Name, timeout))
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 2 {
+		return nil, redis.ErrNil
+	}
+	return reply[1], nil
+}
+
+//GetStrings 返回下标start到stop(包含)的元素, 负数表示从尾部计算, 如-1为最后一个
+func (this *List) GetStrings(start, stop int) ([]string, error) {
+	return redis.Strings(this.client().Do("LRANGE", this.Name, start, stop))
+}
+
+//GetObjects 同GetStrings, 结果追加到slicePtr指向的slice, 如*[]User
+func (this *List) GetObjects(start, stop int, slicePtr interface{}) error {
+	items, err := redis.ByteSlices(this.client().Do("LRANGE", this.Name, start, stop))
+	if err != nil {
+		return err
+	}
+	return unmarshalSlice(this.client().codec, items, slicePtr)
+}
+
+//Trim 只保留下标start到stop(包含)的元素
+func (this *List) Trim(start, stop int) error {
+	return this.client().Send("LTRIM", this.Name, start, stop)
+}
+
+//RemoveObject 删除等于v的元素, 返回删除的数量
+func (this *List) RemoveObject(v interface{}) (int, error) {
+	b, err := this.client().codec.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(this.client().Do("LREM", this.Name, 0, b))
+}
+
+//RemoveString 删除等于v的元素, 返回删除的数量
+func (this *List) RemoveString(v string) (int, error) {
+	return redis.Int(this.client().Do("LREM", this.Name, 0, v))
+}
+
+func (this *List) Size() (int, error) {
+	return redis.Int(this.client().Do("LLEN", this.Name))
+}
+
+func (this *List) Clear() error {
+	return this.client().Send("DEL", this.Name)
+}
+
+//stringArgs 返回name及vs组成的命令参数
+func stringArgs(name string, vs []string) []interface{} {
+	args := make([]interface{}, 0, len(vs)+1)
+	args = append(args, name)
+	for _, v := range vs {
+		args = append(args, v)
+	}
+	return args
+}
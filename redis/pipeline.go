@@ -0,0 +1,265 @@
+package redis
+
+import (
+	"context"
+	"errors"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/codec"
+	"github.com/tryor/commons/redisutil"
+)
+
+//maxTxRetries Watch的key被修改时事务最多执行次数
+const maxTxRetries = 10
+
+//ErrTxFailed Watch的key一直被其它客户端修改, 重试后事务仍未执行
+var ErrTxFailed = errors.New("redis: transaction failed, watched keys modified")
+
+//errTxAborted EXEC返回nil, 即WATCH的key已被修改
+var errTxAborted = errors.New("redis: transaction aborted")
+
+//Result Pipeline或Tx中一条命令的结果, Exec或事务执行后有效
+type Result struct {
+	Reply interface{}
+	Err   error
+	codec codec.Codec
+}
+
+func (r *Result) Int() (int, error)                     { return redis.Int(r.Reply, r.Err) }
+func (r *Result) Int64() (int64, error)                 { return redis.Int64(r.Reply, r.Err) }
+func (r *Result) Float64() (float64, error)             { return redis.Float64(r.Reply, r.Err) }
+func (r *Result) Bool() (bool, error)                   { return redis.Bool(r.Reply, r.Err) }
+func (r *Result) String() (string, error)               { return redis.String(r.Reply, r.Err) }
+func (r *Result) Bytes() ([]byte, error)                { return redis.Bytes(r.Reply, r.Err) }
+func (r *Result) Strings() ([]string, error)            { return redis.Strings(r.Reply, r.Err) }
+func (r *Result) Values() ([]interface{}, error)        { return redis.Values(r.Reply, r.Err) }
+func (r *Result) StringMap() (map[string]string, error) { return redis.StringMap(r.Reply, r.Err) }
+
+//Object 使用客户端的序列化方式将结果反序列化到clazz
+func (r *Result) Object(clazz interface{}) error {
+	b, err := redis.Bytes(r.Reply, r.Err)
+	if err != nil {
+		return err
+	}
+	return r.codec.Unmarshal(b, clazz)
+}
+
+type command struct {
+	name   string
+	args   []interface{}
+	result *Result
+}
+
+//Pipeline 批量发送命令, 一次网络往返后按顺序返回结果
+//cluster模式按命令第一个参数(key)所属的slot分组发送, 每组一次往返
+type Pipeline struct {
+	c    *Client
+	cmds []*command
+}
+
+//Pipeline 创建Pipeline, Queue添加命令后调用Exec
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+//NewPipeline 使用默认客户端创建Pipeline
+func NewPipeline() *Pipeline {
+	return std.Pipeline()
+}
+
+//Queue 添加命令, 返回的Result在Exec后有效
+func (p *Pipeline) Queue(cmd string, args ...interface{}) *Result {
+	r := &Result{codec: p.c.codec}
+	p.cmds = append(p.cmds, &command{name: cmd, args: args, result: r})
+	return r
+}
+
+//Len 未执行的命令数量
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+//Exec 发送所有命令并读取结果, 返回的结果与Queue的顺序一致, 执行后清空命令
+//error为连接错误或第一个执行出错的命令的错误
+func (p *Pipeline) Exec() ([]*Result, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	results := make([]*Result, len(cmds))
+	for i, cmd := range cmds {
+		results[i] = cmd.result
+	}
+	if len(cmds) == 0 {
+		return results, nil
+	}
+	if p.c.pool == nil {
+		return results, ErrNotInitialized
+	}
+
+	groups := [][]*command{cmds}
+	if p.c.pool.IsCluster() {
+		keys := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			keys[i] = firstKey(cmd.args)
+		}
+		groups = groups[:0]
+		for _, g := range redisutil.GroupBySlot(keys) {
+			group := make([]*command, len(g))
+			for i, k := range g {
+				group[i] = cmds[k]
+			}
+			groups = append(groups, group)
+		}
+	}
+	for _, group := range groups {
+		_, err := p.c.pool.Exec(context.Background(), firstKey(group[0].args), func(red redis.Conn) (interface{}, error) {
+			return nil, pipeline(red, group)
+		})
+		if err != nil && !isReplyError(err) {
+			return results, err
+		}
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			return results, r.Err
+		}
+	}
+	return results, nil
+}
+
+//pipeline 在red上发送cmds并读取结果
+//所有命令都返回MOVED或ASK时返回该错误, 由Pool.Exec重定向后重新发送, 否则各命令的错误记录在Result中
+func pipeline(red redis.Conn, cmds []*command) error {
+	for _, cmd := range cmds {
+		if err := red.Send(cmd.name, cmd.args...); err != nil {
+			return err
+		}
+	}
+	if err := red.Flush(); err != nil {
+		return err
+	}
+	redirects := 0
+	for _, cmd := range cmds {
+		reply, err := red.Receive()
+		if err != nil && !isReplyError(err) {
+			return err
+		}
+		if redisutil.IsRedirect(err) {
+			redirects++
+		}
+		cmd.result.Reply, cmd.result.Err = reply, err
+	}
+	if redirects == len(cmds) {
+		return cmds[0].result.Err
+	}
+	return nil
+}
+
+//isReplyError err是否为redis返回的错误, 而不是连接错误
+func isReplyError(err error) bool {
+	_, ok := err.(redis.Error)
+	return ok
+}
+
+//Tx MULTI/EXEC事务, 在Client.Watch的fn中使用
+type Tx struct {
+	c    *Client
+	red  redis.Conn
+	cmds []*command
+}
+
+//Do 立即在事务的连接上执行命令, 用于读取Watch的key
+func (tx *Tx) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return tx.red.Do(cmd, args...)
+}
+
+//Queue 添加在MULTI/EXEC中执行的命令, 返回的Result在事务执行后有效
+func (tx *Tx) Queue(cmd string, args ...interface{}) *Result {
+	r := &Result{codec: tx.c.codec}
+	tx.cmds = append(tx.cmds, &command{name: cmd, args: args, result: r})
+	return r
+}
+
+//Watch 乐观锁事务: WATCH keys后调用fn, fn中使用tx.Do读取, tx.Queue添加命令, 然后在MULTI/EXEC中执行,
+//keys在此期间被其它客户端修改时重新调用fn, 最多执行maxTxRetries次, 仍失败时返回ErrTxFailed
+//fn返回错误时放弃事务并返回该错误; 事务执行后返回第一个出错的命令的错误
+//cluster模式keys及命令的key必须属于同一个slot, 可以使用{tag}
+func (c *Client) Watch(fn func(tx *Tx) error, keys ...string) error {
+	if c.pool == nil {
+		return ErrNotInitialized
+	}
+	var key string
+	if len(keys) > 0 {
+		key = keys[0]
+	}
+	for i := 0; i < maxTxRetries; i++ {
+		_, err := c.pool.Exec(context.Background(), key, func(red redis.Conn) (interface{}, error) {
+			return nil, c.tx(red, fn, keys)
+		})
+		if err != errTxAborted {
+			return err
+		}
+	}
+	return ErrTxFailed
+}
+
+//Multi 不Watch的事务, fn中添加的命令在MULTI/EXEC中执行
+func (c *Client) Multi(fn func(tx *Tx) error) error {
+	return c.Watch(fn)
+}
+
+//Watch 使用默认客户端, 见Client.Watch
+func Watch(fn func(tx *Tx) error, keys ...string) error {
+	return std.Watch(fn, keys...)
+}
+
+//Multi 使用默认客户端, 见Client.Multi
+func Multi(fn func(tx *Tx) error) error {
+	return std.Multi(fn)
+}
+
+func (c *Client) tx(red redis.Conn, fn func(tx *Tx) error, keys []string) error {
+	if len(keys) > 0 {
+		args := make([]interface{}, len(keys))
+		for i, key := range keys {
+			args[i] = key
+		}
+		if _, err := red.Do("WATCH", args...); err != nil {
+			return err
+		}
+	}
+	tx := &Tx{c: c, red: red}
+	if err := fn(tx); err != nil {
+		red.Do("UNWATCH")
+		return err
+	}
+	if len(tx.cmds) == 0 {
+		_, err := red.Do("UNWATCH")
+		return err
+	}
+
+	red.Send("MULTI")
+	for _, cmd := range tx.cmds {
+		red.Send(cmd.name, cmd.args...)
+	}
+	replies, err := redis.Values(red.Do("EXEC"))
+	if err == redis.ErrNil {
+		return errTxAborted
+	}
+	if err != nil {
+		return err
+	}
+	if len(replies) != len(tx.cmds) {
+		return errors.New("redis: invalid EXEC reply")
+	}
+	var first error
+	for i, cmd := range tx.cmds {
+		cmd.result.Reply = replies[i]
+		if e, ok := replies[i].(redis.Error); ok {
+			cmd.result.Reply, cmd.result.Err = nil, e
+			if first == nil {
+				first = e
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,326 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/codec"
+	"github.com/tryor/commons/event"
+)
+
+const (
+	//subscriberBuffer 没有handler时Messages的缓冲大小
+	subscriberBuffer = 100
+	//pingInterval 订阅连接的心跳间隔, 超过2倍间隔没有收到任何回复时重新连接
+	pingInterval = time.Second * 30
+	minBackoff   = time.Millisecond * 100
+	maxBackoff   = time.Second * 5
+)
+
+//Message 订阅收到的消息
+type Message struct {
+	Channel string
+	Pattern string //PSubscribe收到的消息为匹配的模式
+	Data    []byte
+	codec   codec.Codec
+}
+
+func (m *Message) String() string {
+	return string(m.Data)
+}
+
+//Object 使用客户端的序列化方式反序列化Publish的对象
+func (m *Message) Object(clazz interface{}) error {
+	return m.codec.Unmarshal(m.Data, clazz)
+}
+
+//Publish 发布消息, msg为string或[]byte时直接发送, 否则使用客户端的序列化方式, 返回收到消息的订阅者数量
+func (c *Client) Publish(channel string, msg interface{}) (int, error) {
+	data := msg
+	switch msg.(type) {
+	case string, []byte:
+	default:
+		b, err := c.codec.Marshal(msg)
+		if err != nil {
+			return 0, err
+		}
+		data = b
+	}
+	return redis.Int(c.Do("PUBLISH", channel, data))
+}
+
+//Publish 使用默认客户端, 见Client.Publish
+func Publish(channel string, msg interface{}) (int, error) {
+	return std.Publish(channel, msg)
+}
+
+//Subscriber 订阅者, 使用单独的连接接收消息, 连接断开后自动重新连接并恢复订阅, 断开期间的消息会丢失
+//Subscribe等方法可以在Run之前或运行期间调用, Subscriber只能Run一次, Run返回后需要新建Subscriber
+type Subscriber struct {
+	c        *Client
+	handler  func(m *Message)
+	msgs     chan *Message
+	lock     sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+	conn     *redis.PubSubConn //当前连接, 未连接时为nil
+	started  bool              //已调用过Run, Run返回时已关闭Messages, 不能再次Run
+	closed   bool
+	cancel   context.CancelFunc
+}
+
+//NewSubscriber 创建订阅者, 由Run开始接收消息
+//handler不为nil时在接收消息的goroutine中依次调用, 否则消息发送到Messages
+func (c *Client) NewSubscriber(handler func(m *Message)) *Subscriber {
+	s := &Subscriber{c: c, handler: handler, channels: make(map[string]bool), patterns: make(map[string]bool)}
+	if handler == nil {
+		s.msgs = make(chan *Message, subscriberBuffer)
+	}
+	return s
+}
+
+//NewSubscriber 使用默认客户端, 见Client.NewSubscriber
+func NewSubscriber(handler func(m *Message)) *Subscriber {
+	return std.NewSubscriber(handler)
+}
+
+//EventHandler 返回触发类型为t的事件的handler, 事件源为*Message, 用于由redis消息驱动event.Dispatcher
+func EventHandler(d event.IDispatcher, t event.Type, asyn ...bool) func(m *Message) {
+	return func(m *Message) {
+		d.FireEvent(event.NewEvent(t, m), asyn...)
+	}
+}
+
+//Messages 没有handler时接收消息的channel, Run返回时关闭
+func (s *Subscriber) Messages() <-chan *Message {
+	return s.msgs
+}
+
+func (s *Subscriber) Subscribe(channels ...string) error {
+	return s.update(s.channels, true, "SUBSCRIBE", channels)
+}
+
+//PSubscribe 订阅匹配模式的channel, 如"news.*"
+func (s *Subscriber) PSubscribe(patterns ...string) error {
+	return s.update(s.patterns, true, "PSUBSCRIBE", patterns)
+}
+
+//Unsubscribe 取消订阅, 没有参数时取消全部channel
+func (s *Subscriber) Unsubscribe(channels ...string) error {
+	return s.update(s.channels, false, "UNSUBSCRIBE", channels)
+}
+
+//PUnsubscribe 取消模式订阅, 没有参数时取消全部模式
+func (s *Subscriber) PUnsubscribe(patterns ...string) error {
+	return s.update(s.patterns, false, "PUNSUBSCRIBE", patterns)
+}
+
+//update 更新订阅集合, 已连接时同时发送命令, 未连接时在连接后订阅
+func (s *Subscriber) update(set map[string]bool, add bool, cmd string, names []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if add && len(names) == 0 {
+		return nil
+	}
+	if !add && len(names) == 0 {
+		for name := range set {
+			delete(set, name)
+		}
+	}
+	for _, name := range names {
+		if add {
+			set[name] = true
+		} else {
+			delete(set, name)
+		}
+	}
+	if s.conn == nil {
+		return nil
+	}
+	return s.send(cmd, names)
+}
+
+//send 发送命令, 调用时持有s.lock
+func (s *Subscriber) send(cmd string, names []string) error {
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	if err := s.conn.Conn.Send(cmd, args...); err != nil {
+		return err
+	}
+	return s.conn.Conn.Flush()
+}
+
+//Run 连接并接收消息直到ctx结束或调用Close, 连接断开后按退避时间重新连接
+//返回时关闭Messages, ctx结束时返回ctx.Err(), 调用Close时返回nil, 再次调用Run返回错误
+func (s *Subscriber) Run(ctx context.Context) error {
+	if s.c.pool == nil {
+		return ErrNotInitialized
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.lock.Lock()
+	if s.started {
+		s.lock.Unlock()
+		return errors.New("redis: subscriber can only run once")
+	}
+	s.started, s.cancel = true, cancel
+	if s.closed {
+		cancel()
+	}
+	s.lock.Unlock()
+	if s.msgs != nil {
+		defer close(s.msgs)
+	}
+
+	backoff := minBackoff
+	for {
+		subscribed, err := s.receive(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		if subscribed {
+			backoff = minBackoff
+		}
+		log.Printf("redis: subscriber connection error, %v\n", err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	return ctx.Err()
+}
+
+//receive 连接并恢复订阅, 接收消息直到连接出错, subscribed表示是否订阅成功过
+func (s *Subscriber) receive(ctx context.Context) (subscribed bool, err error) {
+	c, err := s.c.pool.Dial()
+	if err != nil {
+		return false, err
+	}
+	psc := &redis.PubSubConn{Conn: c}
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		s.lock.Lock()
+		s.conn = nil
+		s.lock.Unlock()
+		c.Close()
+	}()
+	//ctx结束时关闭连接以结束Receive, 并定时发送心跳
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				c.Close()
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.ping()
+			}
+		}
+	}()
+
+	s.lock.Lock()
+	s.conn = psc
+	err = s.subscribeAll()
+	s.lock.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		switch v := psc.ReceiveWithTimeout(s.readTimeout()).(type) {
+		case redis.Subscription:
+			subscribed = true
+		case redis.Message:
+			s.deliver(ctx, &Message{Channel: v.Channel, Data: v.Data, codec: s.c.codec})
+		case redis.PMessage:
+			s.deliver(ctx, &Message{Channel: v.Channel, Pattern: v.Pattern, Data: v.Data, codec: s.c.codec})
+		case error:
+			return subscribed, v
+		}
+	}
+}
+
+//subscribeAll 在新连接上订阅全部channel及模式, 调用时持有s.lock
+func (s *Subscriber) subscribeAll() error {
+	if len(s.channels) > 0 {
+		if err := s.send("SUBSCRIBE", setMembers(s.channels)); err != nil {
+			return err
+		}
+	}
+	if len(s.patterns) > 0 {
+		return s.send("PSUBSCRIBE", setMembers(s.patterns))
+	}
+	return nil
+}
+
+//ping 有订阅时发送心跳, 没有订阅时连接不处于订阅状态, 不能使用订阅的PING
+func (s *Subscriber) ping() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != nil && len(s.channels)+len(s.patterns) > 0 {
+		s.conn.Ping("")
+	}
+}
+
+//readTimeout 有订阅时为2倍心跳间隔, 否则一直等待
+func (s *Subscriber) readTimeout() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.channels)+len(s.patterns) > 0 {
+		return pingInterval * 2
+	}
+	return 0
+}
+
+func (s *Subscriber) deliver(ctx context.Context, m *Message) {
+	if s.handler != nil {
+		s.handler(m)
+		return
+	}
+	select {
+	case s.msgs <- m:
+	case <-ctx.Done():
+	}
+}
+
+//Close 停止Run
+func (s *Subscriber) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+func setMembers(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	return list
+}
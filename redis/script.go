@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+//Script 注册的lua脚本, 使用EVALSHA执行, 脚本未加载(NOSCRIPT)时使用EVAL
+//cluster模式脚本的全部key必须属于同一个slot, 可以使用{tag}
+type Script struct {
+	Name     string
+	keyCount int
+	src      string
+	script   *redis.Script
+}
+
+var scripts = struct {
+	sync.RWMutex
+	m    map[string]*Script
+	list []*Script //按注册顺序
+}{m: make(map[string]*Script)}
+
+//RegisterScript 注册脚本, 应在init或包级变量中调用, 同名脚本注册两次时panic
+//keyCount为脚本KEYS的数量, 客户端的新连接上会预先加载全部已注册的脚本
+func RegisterScript(name string, keyCount int, src string) *Script {
+	scripts.Lock()
+	defer scripts.Unlock()
+	if _, ok := scripts.m[name]; ok {
+		panic("redis: register called twice for script " + name)
+	}
+	s := &Script{Name: name, keyCount: keyCount, src: src, script: redis.NewScript(keyCount, src)}
+	scripts.m[name] = s
+	scripts.list = append(scripts.list, s)
+	return s
+}
+
+//LookupScript 返回名称为name的脚本, 未注册时返回nil
+func LookupScript(name string) *Script {
+	scripts.RLock()
+	defer scripts.RUnlock()
+	return scripts.m[name]
+}
+
+//loadScripts 在新连接上加载已注册的脚本, 加载失败时忽略, 执行时仍可使用EVAL
+func loadScripts(c redis.Conn) {
+	scripts.RLock()
+	list := scripts.list
+	scripts.RUnlock()
+	for _, s := range list {
+		c.Send("SCRIPT", "LOAD", s.src)
+	}
+	if len(list) == 0 || c.Flush() != nil {
+		return
+	}
+	for range list {
+		if _, err := c.Receive(); err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				return
+			}
+		}
+	}
+}
+
+//Hash 脚本的sha1
+func (s *Script) Hash() string {
+	return s.script.Hash()
+}
+
+//Source 脚本的lua源码, 用于通过cache.Eval等其它方式执行
+func (s *Script) Source() string {
+	return s.src
+}
+
+//Eval 执行脚本, keysAndArgs为keyCount个key及脚本的参数, cluster模式按第一个key选择节点
+func (c *Client) Eval(s *Script, keysAndArgs ...interface{}) (interface{}, error) {
+	if c.pool == nil {
+		return nil, ErrNotInitialized
+	}
+	var key string
+	if s.keyCount > 0 {
+		key = firstKey(keysAndArgs)
+	}
+	return c.pool.Exec(context.Background(), key, func(red redis.Conn) (interface{}, error) {
+		return s.script.Do(red, keysAndArgs...)
+	})
+}
+
+//Eval 使用默认客户端, 见Client.Eval
+func Eval(s *Script, keysAndArgs ...interface{}) (interface{}, error) {
+	return std.Eval(s, keysAndArgs...)
+}
+
+//TokenBucketScript 令牌桶, KEYS[1]为桶, ARGV为容量, 每秒补充的令牌数, 当前毫秒数, 取出的令牌数, 是否预约(1/0, 可以省略)
+//令牌不足时不取出, 预约时仍然取出, 剩余令牌为负数, 之后的请求需要等待补充
+//返回{是否取出, 剩余令牌数, 需要等待的毫秒数}, n大于容量时等待时间为-1, 见Client.TakeTokens及ratelimit.TokenBucket
+var TokenBucketScript = RegisterScript("tokenBucket", 1, `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2]) / 1000
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = ARGV[5] == '1'
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+	ts = now
+end
+if n > capacity then
+	return {0, math.max(0, math.floor(tokens)), -1}
+end
+local t = tokens - n
+local wait = 0
+if t < 0 then
+	wait = math.ceil(-t / rate)
+end
+if wait == 0 or reserve then
+	tokens = t
+	redis.call('HMSET', KEYS[1], 'tokens', tokens, 'ts', ts)
+	redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate))
+end
+local allowed = 0
+if wait == 0 then
+	allowed = 1
+end
+return {allowed, math.max(0, math.floor(tokens)), wait}`)
+
+//CompareAndDeleteScript 值等于ARGV[1]时删除KEYS[1], 返回删除的数量, 见Client.CompareAndDelete
+var CompareAndDeleteScript = RegisterScript("compareAndDelete", 1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+//CappedPushScript 在列表KEYS[1]头部添加ARGV[2]及之后的元素, 只保留前ARGV[1]个, 返回添加后的长度, 见List.CappedPushObject
+//ARGV[1]必须大于0, 且至少有一个元素, 由调用者检查
+var CappedPushScript = RegisterScript("cappedPush", 1, `
+local n = redis.call('LPUSH', KEYS[1], unpack(ARGV, 2))
+local max = tonumber(ARGV[1])
+if n > max then
+	redis.call('LTRIM', KEYS[1], 0, max - 1)
+	n = max
+end
+return n`)
+
+//TokenResult 令牌桶TakeTokens的结果
+type TokenResult struct {
+	Allowed   bool
+	Remaining int           //剩余令牌数
+	Wait      time.Duration //令牌不足时需要等待的时间, n大于capacity时为-1, 表示永远不会取出
+}
+
+//TakeTokens 从容量为capacity, 每秒补充rate个令牌的令牌桶key中取出n个令牌, 令牌不足时不取出
+//capacity, rate及n必须大于0
+func (c *Client) TakeTokens(key string, capacity int, rate float64, n int) (TokenResult, error) {
+	if capacity <= 0 || rate <= 0 || n <= 0 {
+		return TokenResult{}, errors.New("redis: capacity, rate and n must be positive")
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	reply, err := redis.Ints(c.Eval(TokenBucketScript, key, capacity, rate, now, n))
+	if err != nil {
+		return TokenResult{}, err
+	}
+	if len(reply) != 3 {
+		return TokenResult{}, errors.New("redis: invalid token bucket reply")
+	}
+	r := TokenResult{Allowed: reply[0] == 1, Remaining: reply[1], Wait: time.Duration(reply[2]) * time.Millisecond}
+	if reply[2] < 0 {
+		r.Wait = -1
+	}
+	return r, nil
+}
+
+//TakeTokens 使用默认客户端, 见Client.TakeTokens
+func TakeTokens(key string, capacity int, rate float64, n int) (TokenResult, error) {
+	return std.TakeTokens(key, capacity, rate, n)
+}
+
+//CompareAndDelete 值等于value时删除key, 如释放自己持有的锁
+func (c *Client) CompareAndDelete(key, value string) (bool, error) {
+	return redis.Bool(c.Eval(CompareAndDeleteScript, key, value))
+}
+
+//CompareAndDelete 使用默认客户端, 见Client.CompareAndDelete
+func CompareAndDelete(key, value string) (bool, error) {
+	return std.CompareAndDelete(key, value)
+}
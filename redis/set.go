@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"encoding/json"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/codec"
+)
+
+//Set redis集合, 同SortedSet, 对象成员始终使用json, 以保证相同对象对应相同成员
+//cluster模式Inter, Union, Diff的集合必须属于同一个slot, 可以使用{tag}
+type Set struct {
+	Name string
+	c    *Client
+}
+
+func (c *Client) NewSet(name string) *Set {
+	return &Set{Name: name, c: c}
+}
+
+//NewSet 使用默认客户端, 见Client.NewSet
+func NewSet(name string) *Set {
+	return std.NewSet(name)
+}
+
+//client Set{Name: name}直接创建时使用默认客户端
+func (this *Set) client() *Client {
+	if this.c == nil {
+		return std
+	}
+	return this.c
+}
+
+func (this *Set) SetExpire(second int) error {
+	return this.client().Send("EXPIRE", this.Name, second)
+}
+
+func (this *Set) AddObject(vs ...interface{}) error {
+	args, err := jsonArgs(this.Name, vs)
+	if err != nil {
+		return err
+	}
+	return this.client().Send("SADD", args...)
+}
+
+func (this *Set) AddString(vs ...string) error {
+	return this.client().Send("SADD", stringArgs(this.Name, vs)...)
+}
+
+func (this *Set) RemoveObject(vs ...interface{}) error {
+	args, err := jsonArgs(this.Name, vs)
+	if err != nil {
+		return err
+	}
+	return this.client().Send("SREM", args...)
+}
+
+func (this *Set) RemoveString(vs ...string) error {
+	return this.client().Send("SREM", stringArgs(this.Name, vs)...)
+}
+
+func (this *Set) ExistsObject(v interface{}) bool {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	ok, _ := redis.Bool(this.client().Do("SISMEMBER", this.Name, b))
+	return ok
+}
+
+func (this *Set) ExistsString(v string) bool {
+	ok, _ := redis.Bool(this.client().Do("SISMEMBER", this.Name, v))
+	return ok
+}
+
+func (this *Set) Size() (int, error) {
+	return redis.Int(this.client().Do("SCARD", this.Name))
+}
+
+//GetStrings 返回全部成员
+func (this *Set) GetStrings() ([]string, error) {
+	return redis.Strings(this.client().Do("SMEMBERS", this.Name))
+}
+
+//GetObjects 返回全部成员, 结果追加到slicePtr指向的slice, 如*[]User
+func (this *Set) GetObjects(slicePtr interface{}) error {
+	items, err := redis.ByteSlices(this.client().Do("SMEMBERS", this.Name))
+	if err != nil {
+		return err
+	}
+	return unmarshalSlice(codec.JSON, items, slicePtr)
+}
+
+//Inter 返回与others的交集
+func (this *Set) Inter(others ...*Set) ([]string, error) {
+	return redis.Strings(this.client().Do("SINTER", this.setArgs(others)...))
+}
+
+//Union 返回与others的并集
+func (this *Set) Union(others ...*Set) ([]string, error) {
+	return redis.Strings(this.client().Do("SUNION", this.setArgs(others)...))
+}
+
+//Diff 返回不在others中的成员
+func (this *Set) Diff(others ...*Set) ([]string, error) {
+	return redis.Strings(this.client().Do("SDIFF", this.setArgs(others)...))
+}
+
+func (this *Set) setArgs(others []*Set) []interface{} {
+	args := make([]interface{}, 0, len(others)+1)
+	args = append(args, this.Name)
+	for _, o := range others {
+		args = append(args, o.Name)
+	}
+	return args
+}
+
+func (this *Set) Clear() error {
+	return this.client().Send("DEL", this.Name)
+}
+
+//jsonArgs 返回name及vs的json组成的命令参数
+func jsonArgs(name string, vs []interface{}) ([]interface{}, error) {
+	args := make([]interface{}, 0, len(vs)+1)
+	args = append(args, name)
+	for _, v := range vs {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, b)
+	}
+	return args, nil
+}
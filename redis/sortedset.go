@@ -0,0 +1,355 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/codec"
+)
+
+//SortedSet redis有序集合, 对象成员始终使用json, 以保证相同对象对应相同成员
+type SortedSet struct {
+	Name string
+	c    *Client
+}
+
+//NewSortedSet 使用默认客户端, 见Client.NewSortedSet
+func NewSortedSet(name string) *SortedSet {
+	return std.NewSortedSet(name)
+}
+
+//client SortedSet{Name: name}直接创建时使用默认客户端
+func (this *SortedSet) client() *Client {
+	if this.c == nil {
+		return std
+	}
+	return this.c
+}
+
+//ScoreBound 分数范围的边界, 使用Inclusive, Exclusive, NegInf, PosInf
+type ScoreBound string
+
+const (
+	NegInf ScoreBound = "-inf" //负无穷
+	PosInf ScoreBound = "+inf" //正无穷
+)
+
+//Inclusive 包含score的边界
+func Inclusive(score float64) ScoreBound {
+	return ScoreBound(strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+//Exclusive 不包含score的边界
+func Exclusive(score float64) ScoreBound {
+	return "(" + Inclusive(score)
+}
+
+func (this *SortedSet) SetExpire(second int) error {
+	return this.client().Send("EXPIRE", this.Name, second)
+}
+
+func (this *SortedSet) AddObject(score float64, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return this.client().Send("ZADD", this.Name, score, b)
+}
+
+//Z SortedSet的成员及分数, AddMany与AddObject相同, Member始终使用json, string成员使用AddString
+//WithScores, PopMin等返回的Z中Member为成员的字符串(string), 与GetStrings相同, 见memberString
+type Z struct {
+	Score  float64
+	Member interface{}
+}
+
+//AddMany 批量添加, 每batchSize个成员一条ZADD, 通过Pipeline一次发送
+func (this *SortedSet) AddMany(members ...Z) error {
+	p := this.client().Pipeline()
+	args := []interface{}{this.Name}
+	for _, z := range members {
+		member, err := json.Marshal(z.Member)
+		if err != nil {
+			return err
+		}
+		if args = append(args, z.Score, member); len(args) > batchSize*2 {
+			p.Queue("ZADD", args...)
+			args = []interface{}{this.Name}
+		}
+	}
+	if len(args) > 1 {
+		p.Queue("ZADD", args...)
+	}
+	_, err := p.Exec()
+	return err
+}
+
+func (this *SortedSet) AddString(score float64, v string) error {
+	return this.client().Send("ZADD", this.Name, score, v)
+}
+
+func (this *SortedSet) Size() int {
+	b, err := redis.Int(this.client().Do("ZCARD", this.Name))
+	if err != nil {
+		return -1
+	}
+	return b
+}
+
+func (this *SortedSet) SizeByScore(min, max float64) int {
+	b, err := redis.Int(this.client().Do("ZCOUNT", this.Name, min, max))
+	if err != nil {
+		return -1
+	}
+	return b
+}
+
+func (this *SortedSet) GetObject(index int, clazz interface{}) error {
+	b, err := this.at(index)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, clazz)
+	return err
+}
+
+func (this *SortedSet) RemoveObject(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return this.client().Send("ZREM", this.Name, b)
+}
+
+func (this *SortedSet) GetString(index int) (string, error) {
+	b, err := this.at(index)
+	if err != nil {
+		return "", err
+	}
+	return memberString(b), nil
+}
+
+//at 返回下标为index的成员, 不存在时返回redis.ErrNil
+func (this *SortedSet) at(index int) ([]byte, error) {
+	items, err := redis.ByteSlices(this.client().Do("ZRANGE", this.Name, index, index))
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, redis.ErrNil
+	}
+	return items[0], nil
+}
+
+func (this *SortedSet) GetStrings(start, limit int) ([]string, error) {
+	return members(this.client().Do("ZRANGE", this.Name, start, start+limit))
+}
+
+func (this *SortedSet) GetStringsRev(start, limit int) ([]string, error) {
+	return members(this.client().Do("ZREVRANGE", this.Name, start, start+limit))
+}
+
+//GetObjects 返回下标start到stop(包含)的成员, 按分数从小到大, 结果追加到slicePtr指向的slice, 如*[]User
+func (this *SortedSet) GetObjects(start, stop int, slicePtr interface{}) error {
+	return this.objects(slicePtr, "ZRANGE", this.Name, start, stop)
+}
+
+//GetObjectsRev 同GetObjects, 按分数从大到小
+func (this *SortedSet) GetObjectsRev(start, stop int, slicePtr interface{}) error {
+	return this.objects(slicePtr, "ZREVRANGE", this.Name, start, stop)
+}
+
+//RangeWithScores 返回下标start到stop(包含)的成员及分数, 按分数从小到大
+func (this *SortedSet) RangeWithScores(start, stop int) ([]Z, error) {
+	return this.withScores(this.client().Do("ZRANGE", this.Name, start, stop, "WITHSCORES"))
+}
+
+//RevRangeWithScores 同RangeWithScores, 按分数从大到小, 如排行榜前N名
+func (this *SortedSet) RevRangeWithScores(start, stop int) ([]Z, error) {
+	return this.withScores(this.client().Do("ZREVRANGE", this.Name, start, stop, "WITHSCORES"))
+}
+
+//RangeByScore 返回分数在min到max之间的成员, 按分数从小到大, 跳过offset个, 最多返回limit个, limit<=0时不限制
+func (this *SortedSet) RangeByScore(min, max ScoreBound, offset, limit int) ([]string, error) {
+	return members(this.client().Do("ZRANGEBYSCORE", this.byScoreArgs(min, max, offset, limit)...))
+}
+
+//RevRangeByScore 同RangeByScore, 按分数从大到小, 注意max在前
+func (this *SortedSet) RevRangeByScore(max, min ScoreBound, offset, limit int) ([]string, error) {
+	return members(this.client().Do("ZREVRANGEBYSCORE", this.byScoreArgs(max, min, offset, limit)...))
+}
+
+//RangeByScoreWithScores 同RangeByScore, 同时返回分数
+func (this *SortedSet) RangeByScoreWithScores(min, max ScoreBound, offset, limit int) ([]Z, error) {
+	args := append(this.byScoreArgs(min, max, offset, limit), "WITHSCORES")
+	return this.withScores(this.client().Do("ZRANGEBYSCORE", args...))
+}
+
+//RevRangeByScoreWithScores 同RevRangeByScore, 同时返回分数
+func (this *SortedSet) RevRangeByScoreWithScores(max, min ScoreBound, offset, limit int) ([]Z, error) {
+	args := append(this.byScoreArgs(max, min, offset, limit), "WITHSCORES")
+	return this.withScores(this.client().Do("ZREVRANGEBYSCORE", args...))
+}
+
+//RangeByScoreObjects 同RangeByScore, 结果追加到slicePtr指向的slice
+func (this *SortedSet) RangeByScoreObjects(min, max ScoreBound, offset, limit int, slicePtr interface{}) error {
+	return this.objects(slicePtr, "ZRANGEBYSCORE", this.byScoreArgs(min, max, offset, limit)...)
+}
+
+//RevRangeByScoreObjects 同RevRangeByScore, 结果追加到slicePtr指向的slice
+func (this *SortedSet) RevRangeByScoreObjects(max, min ScoreBound, offset, limit int, slicePtr interface{}) error {
+	return this.objects(slicePtr, "ZREVRANGEBYSCORE", this.byScoreArgs(max, min, offset, limit)...)
+}
+
+func (this *SortedSet) byScoreArgs(from, to ScoreBound, offset, limit int) []interface{} {
+	args := []interface{}{this.Name, string(from), string(to)}
+	if limit > 0 {
+		args = append(args, "LIMIT", offset, limit)
+	} else if offset > 0 {
+		args = append(args, "LIMIT", offset, -1)
+	}
+	return args
+}
+
+func (this *SortedSet) objects(slicePtr interface{}, cmd string, args ...interface{}) error {
+	items, err := redis.ByteSlices(this.client().Do(cmd, args...))
+	if err != nil {
+		return err
+	}
+	return unmarshalSlice(codec.JSON, items, slicePtr)
+}
+
+//withScores 解析[member, score, ...]
+func (this *SortedSet) withScores(reply interface{}, err error) ([]Z, error) {
+	values, err := redis.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, errors.New("redis: invalid WITHSCORES reply")
+	}
+	list := make([]Z, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Z{Score: score, Member: memberString([]byte(values[i]))})
+	}
+	return list, nil
+}
+
+//memberString 成员的字符串形式, AddObject添加的json字符串成员去掉引号及转义
+//Rank等方法再次编码后即为原成员, AddString添加的成员及对象成员原样返回
+func memberString(b []byte) string {
+	var s string
+	if len(b) > 0 && b[0] == '"' && json.Unmarshal(b, &s) == nil {
+		return s
+	}
+	return string(b)
+}
+
+//members 将ZRANGE等返回的成员转换为字符串, 见memberString
+func members(reply interface{}, err error) ([]string, error) {
+	items, err := redis.ByteSlices(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, len(items))
+	for i, b := range items {
+		list[i] = memberString(b)
+	}
+	return list, nil
+}
+
+//Rank 返回成员按分数从小到大的排名, 从0开始, 成员不存在时返回redis.ErrNil
+//与AddObject相同, member始终使用json, AddString添加的成员使用StringRank
+func (this *SortedSet) Rank(member interface{}) (int, error) {
+	b, err := json.Marshal(member)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(this.client().Do("ZRANK", this.Name, b))
+}
+
+//RevRank 同Rank, 按分数从大到小排名
+func (this *SortedSet) RevRank(member interface{}) (int, error) {
+	b, err := json.Marshal(member)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(this.client().Do("ZREVRANK", this.Name, b))
+}
+
+//StringRank 同Rank, 用于AddString添加的成员
+func (this *SortedSet) StringRank(v string) (int, error) {
+	return redis.Int(this.client().Do("ZRANK", this.Name, v))
+}
+
+//StringRevRank 同RevRank, 用于AddString添加的成员
+func (this *SortedSet) StringRevRank(v string) (int, error) {
+	return redis.Int(this.client().Do("ZREVRANK", this.Name, v))
+}
+
+//IncrScore 将成员的分数增加delta, 成员不存在时添加, 返回新的分数
+//与AddObject相同, member始终使用json, AddString添加的成员使用IncrStringScore
+func (this *SortedSet) IncrScore(member interface{}, delta float64) (float64, error) {
+	b, err := json.Marshal(member)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Float64(this.client().Do("ZINCRBY", this.Name, delta, b))
+}
+
+//IncrStringScore 同IncrScore, 用于AddString添加的成员
+func (this *SortedSet) IncrStringScore(v string, delta float64) (float64, error) {
+	return redis.Float64(this.client().Do("ZINCRBY", this.Name, delta, v))
+}
+
+//PopMin 取出分数最小的count个成员, 需要redis 5.0
+func (this *SortedSet) PopMin(count int) ([]Z, error) {
+	return this.withScores(this.client().Do("ZPOPMIN", this.Name, count))
+}
+
+//PopMax 取出分数最大的count个成员, 需要redis 5.0
+func (this *SortedSet) PopMax(count int) ([]Z, error) {
+	return this.withScores(this.client().Do("ZPOPMAX", this.Name, count))
+}
+
+func (this *SortedSet) RemoveString(v string) error {
+	return this.client().Send("ZREM", this.Name, v)
+}
+
+func (this *SortedSet) Remove(start, limit int) error {
+	return this.client().Send("ZREMRANGEBYRANK", this.Name, start, start+limit-1)
+}
+
+func (this *SortedSet) RemoveByIndex(index int) error {
+	return this.client().Send("ZREMRANGEBYRANK", this.Name, index, index)
+}
+
+//RemoveByScore 删除分数在min到max之间的成员, 返回删除的数量
+func (this *SortedSet) RemoveByScore(min, max ScoreBound) (int, error) {
+	return redis.Int(this.client().Do("ZREMRANGEBYSCORE", this.Name, string(min), string(max)))
+}
+
+//ObjectScore 返回成员的分数, 成员不存在时返回redis.ErrNil
+func (this *SortedSet) ObjectScore(v interface{}) (float64, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Float64(this.client().Do("ZSCORE", this.Name, b))
+}
+
+//StringScore 同ObjectScore
+func (this *SortedSet) StringScore(v string) (float64, error) {
+	return redis.Float64(this.client().Do("ZSCORE", this.Name, v))
+}
+
+func (this *SortedSet) Clear() error {
+	return this.client().Send("DEL", this.Name)
+}
+
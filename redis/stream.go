@@ -0,0 +1,217 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/codec"
+)
+
+//streamObjectField AddObject保存对象的字段名
+const streamObjectField = "object"
+
+//Stream redis stream, 用于事件日志及消费组, 对象使用客户端的序列化方式
+type Stream struct {
+	Name   string
+	MaxLen int //大于0时添加消息后近似裁剪到此长度(MAXLEN ~)
+	c      *Client
+}
+
+//StreamMessage stream中的一条消息
+type StreamMessage struct {
+	ID     string
+	Values map[string]string
+	codec  codec.Codec
+}
+
+//Object 反序列化AddObject添加的对象
+func (m *StreamMessage) Object(clazz interface{}) error {
+	v, ok := m.Values[streamObjectField]
+	if !ok {
+		return redis.ErrNil
+	}
+	return m.codec.Unmarshal([]byte(v), clazz)
+}
+
+//StreamPending 已投递但未确认的消息
+type StreamPending struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration //距离最后一次投递的时间
+	Deliveries int           //投递次数
+}
+
+func (c *Client) NewStream(name string) *Stream {
+	return &Stream{Name: name, c: c}
+}
+
+//NewStream 使用默认客户端, 见Client.NewStream
+func NewStream(name string) *Stream {
+	return std.NewStream(name)
+}
+
+//client Stream{Name: name}直接创建时使用默认客户端
+func (this *Stream) client() *Client {
+	if this.c == nil {
+		return std
+	}
+	return this.c
+}
+
+func (this *Stream) SetExpire(second int) error {
+	return this.client().Send("EXPIRE", this.Name, second)
+}
+
+//Add 添加消息, 返回消息ID
+func (this *Stream) Add(values map[string]interface{}) (string, error) {
+	if len(values) == 0 {
+		return "", errors.New("redis: stream message has no values")
+	}
+	args := this.addArgs()
+	for k, v := range values {
+		args = append(args, k, v)
+	}
+	return redis.String(this.client().Do("XADD", args...))
+}
+
+//AddObject 添加对象, 对象序列化后保存在object字段, 使用StreamMessage.Object读取
+func (this *Stream) AddObject(v interface{}) (string, error) {
+	b, err := this.client().codec.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return redis.String(this.client().Do("XADD", append(this.addArgs(), streamObjectField, b)...))
+}
+
+func (this *Stream) addArgs() []interface{} {
+	args := []interface{}{this.Name}
+	if this.MaxLen > 0 {
+		args = append(args, "MAXLEN", "~", this.MaxLen)
+	}
+	return append(args, "*")
+}
+
+//CreateGroup 创建消费组, stream不存在时同时创建, 消费组已存在时不返回错误
+//start为消费组开始读取的位置, "$"为只读取新消息, "0"为从头读取
+func (this *Stream) CreateGroup(group, start string) error {
+	_, err := this.client().Do("XGROUP", "CREATE", this.Name, group, start, "MKSTREAM")
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+//ReadGroup 以consumer身份从消费组读取最多count条新消息, 消息在Ack之前处于pending状态
+//没有新消息时最多等待timeout秒, 0为一直等待, 小于0为不等待, 超时返回空
+func (this *Stream) ReadGroup(group, consumer string, count, timeout int) ([]StreamMessage, error) {
+	return this.readGroup(group, consumer, count, timeout, ">")
+}
+
+//ReadPending 读取consumer已投递但未确认的消息, 用于消费者重启后继续处理
+func (this *Stream) ReadPending(group, consumer string, count int) ([]StreamMessage, error) {
+	return this.readGroup(group, consumer, count, -1, "0")
+}
+
+func (this *Stream) readGroup(group, consumer string, count, timeout int, id string) ([]StreamMessage, error) {
+	args := []interface{}{"GROUP", group, consumer}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	var readTimeout time.Duration
+	if timeout >= 0 {
+		args = append(args, "BLOCK", timeout*1000)
+		readTimeout = blockTimeout(timeout)
+	}
+	args = append(args, "STREAMS", this.Name, id)
+	reply, err := redis.Values(this.client().doKey(this.Name, readTimeout, "XREADGROUP", args...))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	//[[stream, [[id, [field, value, ...]], ...]]]
+	var msgs []StreamMessage
+	for _, s := range reply {
+		stream, err := redis.Values(s, nil)
+		if err != nil || len(stream) != 2 {
+			return nil, errors.New("redis: invalid XREADGROUP reply")
+		}
+		entries, err := redis.Values(stream[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			msg, err := this.parseMessage(e)
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+//parseMessage 解析[id, [field, value, ...]], 已删除的消息field为nil
+func (this *Stream) parseMessage(reply interface{}) (StreamMessage, error) {
+	entry, err := redis.Values(reply, nil)
+	if err != nil || len(entry) != 2 {
+		return StreamMessage{}, errors.New("redis: invalid stream entry")
+	}
+	msg := StreamMessage{codec: this.client().codec}
+	if msg.ID, err = redis.String(entry[0], nil); err != nil {
+		return msg, err
+	}
+	if entry[1] != nil {
+		if msg.Values, err = redis.StringMap(entry[1], nil); err != nil {
+			return msg, err
+		}
+	}
+	return msg, nil
+}
+
+//Ack 确认消息已处理, 返回确认的数量
+func (this *Stream) Ack(group string, ids ...string) (int, error) {
+	args := []interface{}{this.Name, group}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return redis.Int(this.client().Do("XACK", args...))
+}
+
+//Pending 返回消费组中最多count条未确认的消息, 指定consumer时只返回该消费者的消息
+func (this *Stream) Pending(group string, count int, consumer ...string) ([]StreamPending, error) {
+	args := []interface{}{this.Name, group, "-", "+", count}
+	if len(consumer) > 0 {
+		args = append(args, consumer[0])
+	}
+	reply, err := redis.Values(this.client().Do("XPENDING", args...))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]StreamPending, 0, len(reply))
+	for _, r := range reply {
+		var p StreamPending
+		var idle int64
+		fields, err := redis.Values(r, nil)
+		if err == nil {
+			_, err = redis.Scan(fields, &p.ID, &p.Consumer, &idle, &p.Deliveries)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.Idle = time.Duration(idle) * time.Millisecond
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+func (this *Stream) Size() (int, error) {
+	return redis.Int(this.client().Do("XLEN", this.Name))
+}
+
+func (this *Stream) Clear() error {
+	return this.client().Send("DEL", this.Name)
+}
@@ -0,0 +1,158 @@
+package redisutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ParseOptions 解析cache的redis配置及redis.CacheInitConfig的配置, 值均为字符串
+//mode - 部署方式, sentinel或cluster, 默认：单机
+//addr - redis地址, sentinel模式为sentinel地址, cluster模式为节点地址, 多个地址用逗号分隔
+//masterName - sentinel模式的master名称
+//username - redis 6 ACL用户名, 默认：无, 即AUTH password
+//password - 密码, 默认：无
+//sentinelPassword - sentinel的密码, 默认：无
+//dbNum - 数据库, cluster模式只能为0, 默认：0
+//maxIdleConns - 每个节点的最大空闲连接数, 默认：3
+//maxActiveConns - 每个节点的最大连接数, 默认：0, 不限制
+//wait - 达到maxActiveConns时是否等待连接释放, true或false, 默认：false, 直接返回错误
+//connIdleTimeout - 空闲连接超时时间, 秒, 默认：300
+//noTesttime - 取出空闲超过此时间的连接时先PING, 秒, 默认：60
+//maxConnLifetime - 连接最长使用时间, 超过后关闭, 秒, 默认：0, 不限制
+//connectTimeout, readTimeout, writeTimeout - 连接、读、写超时时间, 秒, 可为小数, 默认：0, 不超时
+//tls - 是否使用TLS连接, true或false, 默认：false
+//tlsCAFile - 验证服务器证书的CA证书文件, 默认：系统CA
+//tlsCertFile, tlsKeyFile - 客户端证书及私钥文件, 默认：无
+//tlsServerName - 验证证书时使用的服务器名称, 默认：连接地址的主机名
+//tlsSkipVerify - 是否跳过服务器证书验证, true或false, 默认：false
+func ParseOptions(cf map[string]string) (Options, error) {
+	opts := Options{
+		Mode:             cf["mode"],
+		MasterName:       cf["masterName"],
+		Username:         cf["username"],
+		Password:         cf["password"],
+		SentinelPassword: cf["sentinelPassword"],
+	}
+	for _, addr := range strings.Split(cf["addr"], ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			opts.Addrs = append(opts.Addrs, addr)
+		}
+	}
+	if len(opts.Addrs) == 0 {
+		return opts, errors.New("config has no addr key")
+	}
+
+	var err error
+	if opts.DB, err = intOption(cf, "dbNum", 0); err != nil {
+		return opts, err
+	}
+	if opts.MaxIdle, err = intOption(cf, "maxIdleConns", 3); err != nil {
+		return opts, err
+	}
+	if opts.MaxActive, err = intOption(cf, "maxActiveConns", 0); err != nil {
+		return opts, err
+	}
+	if opts.Wait, err = boolOption(cf, "wait"); err != nil {
+		return opts, err
+	}
+	durations := []struct {
+		key string
+		def float64
+		d   *time.Duration
+	}{
+		{"connIdleTimeout", 300, &opts.IdleTimeout},
+		{"noTesttime", 60, &opts.TestIdle},
+		{"maxConnLifetime", 0, &opts.MaxConnLifetime},
+		{"connectTimeout", 0, &opts.ConnectTimeout},
+		{"readTimeout", 0, &opts.ReadTimeout},
+		{"writeTimeout", 0, &opts.WriteTimeout},
+	}
+	for _, v := range durations {
+		if *v.d, err = secondsOption(cf, v.key, v.def); err != nil {
+			return opts, err
+		}
+	}
+
+	useTLS, err := boolOption(cf, "tls")
+	if err != nil || !useTLS {
+		return opts, err
+	}
+	opts.TLS, err = tlsConfig(cf)
+	return opts, err
+}
+
+//NewPoolConfig 使用ParseOptions解析的配置创建连接池
+func NewPoolConfig(cf map[string]string) (*Pool, error) {
+	opts, err := ParseOptions(cf)
+	if err != nil {
+		return nil, err
+	}
+	return NewPool(opts)
+}
+
+//tlsConfig 根据tls*配置创建tls.Config
+func tlsConfig(cf map[string]string) (*tls.Config, error) {
+	c := &tls.Config{ServerName: cf["tlsServerName"]}
+	var err error
+	if c.InsecureSkipVerify, err = boolOption(cf, "tlsSkipVerify"); err != nil {
+		return nil, err
+	}
+	if file := cf["tlsCAFile"]; file != "" {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("redisutil: no certificate in " + file)
+		}
+	}
+	if cf["tlsCertFile"] != "" || cf["tlsKeyFile"] != "" {
+		cert, err := tls.LoadX509KeyPair(cf["tlsCertFile"], cf["tlsKeyFile"])
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+func intOption(cf map[string]string, key string, def int) (int, error) {
+	s, ok := cf[key]
+	if !ok || s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("redisutil: invalid " + key + " " + s)
+	}
+	return n, nil
+}
+
+func boolOption(cf map[string]string, key string) (bool, error) {
+	s, ok := cf[key]
+	if !ok || s == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errors.New("redisutil: invalid " + key + " " + s)
+	}
+	return b, nil
+}
+
+func secondsOption(cf map[string]string, key string, def float64) (time.Duration, error) {
+	sec := def
+	if s, ok := cf[key]; ok && s != "" {
+		var err error
+		if sec, err = strconv.ParseFloat(s, 64); err != nil || sec < 0 {
+			return 0, errors.New("redisutil: invalid " + key + " " + s)
+		}
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
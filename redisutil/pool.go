@@ -0,0 +1,557 @@
+/*
+redisutil 连接单个redis, redis sentinel或redis cluster的连接池
+
+单机: Options{Addrs: []string{"127.0.0.1:6379"}}
+sentinel: Options{Mode: "sentinel", MasterName: "mymaster", Addrs: sentinel地址}, 通过sentinel查找master, 切换后重新查找
+cluster: Options{Mode: "cluster", Addrs: 部分或全部节点地址}, 按key的slot选择节点, 处理MOVED/ASK重定向
+*/
+package redisutil
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	ModeStandalone = ""
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+//maxRedirects 一条命令最多重定向次数
+const maxRedirects = 5
+
+//errConnExpired 连接超过MaxConnLifetime, 由连接池关闭
+var errConnExpired = errors.New("redisutil: connection expired")
+
+//Options 连接池选项
+type Options struct {
+	Mode             string   //ModeStandalone, ModeSentinel或ModeCluster
+	Addrs            []string //单机时为redis地址, sentinel模式为sentinel地址, cluster模式为部分或全部节点地址
+	MasterName       string   //sentinel模式的master名称
+	Username         string   //redis 6 ACL用户名, 为空时使用AUTH password
+	Password         string
+	SentinelPassword string        //sentinel的密码, 默认：无
+	DB               int           //为0时不执行SELECT, cluster模式只能为0
+	MaxIdle          int           //每个节点的最大空闲连接数
+	MaxActive        int           //每个节点的最大连接数, 0为不限制
+	Wait             bool          //达到MaxActive时等待连接释放, 否则返回redis.ErrPoolExhausted
+	IdleTimeout      time.Duration //空闲连接超时时间
+	TestIdle         time.Duration //取出空闲超过此时间的连接时先PING
+	MaxConnLifetime  time.Duration //连接最长使用时间, 0为不限制
+	ConnectTimeout   time.Duration //0为不超时, 连接sentinel时默认3秒
+	ReadTimeout      time.Duration //同ConnectTimeout
+	WriteTimeout     time.Duration //同ConnectTimeout
+	TLS              *tls.Config   //不为nil时使用TLS连接, 包括sentinel
+	//OnConnect 新连接认证及SELECT之后调用, 如预先加载脚本, 返回错误时关闭连接, 不包括sentinel的连接
+	OnConnect func(c redis.Conn) error
+}
+
+//Pool 按key选择节点的连接池, 每个节点一个redis.Pool
+type Pool struct {
+	opts  Options
+	lock  sync.RWMutex
+	pools map[string]*redis.Pool
+	addr  string   //单机及sentinel模式的当前地址
+	slots []string //cluster模式, 下标为slot, 值为master地址
+	nodes []string //cluster模式的master地址, 已排序
+
+	refreshLock sync.Mutex
+}
+
+func NewPool(opts Options) (*Pool, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("redisutil: no addr")
+	}
+	p := &Pool{opts: opts, pools: make(map[string]*redis.Pool)}
+	switch opts.Mode {
+	case ModeStandalone:
+		p.addr = opts.Addrs[0]
+	case ModeSentinel:
+		if opts.MasterName == "" {
+			return nil, errors.New("redisutil: sentinel mode requires master name")
+		}
+	case ModeCluster:
+		if opts.DB != 0 {
+			return nil, errors.New("redisutil: cluster mode only supports db 0")
+		}
+	default:
+		return nil, errors.New("redisutil: unknown mode " + opts.Mode)
+	}
+	return p, nil
+}
+
+//IsStandalone 是否为单机模式
+func (p *Pool) IsStandalone() bool {
+	return p.opts.Mode == ModeStandalone
+}
+
+//IsCluster 是否为cluster模式, cluster模式下多个key的命令必须属于同一个slot
+func (p *Pool) IsCluster() bool {
+	return p.opts.Mode == ModeCluster
+}
+
+//dialOptions 连接redis及sentinel的超时和TLS选项, 未设置的超时时间使用def
+func (p *Pool) dialOptions(def time.Duration) []redis.DialOption {
+	timeout := func(d time.Duration) time.Duration {
+		if d > 0 {
+			return d
+		}
+		return def
+	}
+	opts := []redis.DialOption{
+		redis.DialConnectTimeout(timeout(p.opts.ConnectTimeout)),
+		redis.DialReadTimeout(timeout(p.opts.ReadTimeout)),
+		redis.DialWriteTimeout(timeout(p.opts.WriteTimeout)),
+	}
+	if p.opts.TLS != nil {
+		opts = append(opts, redis.DialUseTLS(true), redis.DialTLSConfig(p.opts.TLS))
+	}
+	return opts
+}
+
+func (p *Pool) dial(addr string) (redis.Conn, error) {
+	c, err := redis.Dial("tcp", addr, p.dialOptions(0)...)
+	if err != nil {
+		return nil, err
+	}
+	if err = auth(c, p.opts.Username, p.opts.Password); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if p.opts.DB != 0 {
+		if _, err := c.Do("SELECT", p.opts.DB); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if p.opts.OnConnect != nil {
+		if err := p.opts.OnConnect(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if p.opts.MaxConnLifetime > 0 {
+		return &lifetimeConn{c, time.Now()}, nil
+	}
+	return c, nil
+}
+
+//auth username不为空时使用redis 6的AUTH username password
+func auth(c redis.Conn, username, password string) error {
+	var err error
+	if username != "" {
+		_, err = c.Do("AUTH", username, password)
+	} else if password != "" {
+		_, err = c.Do("AUTH", password)
+	}
+	return err
+}
+
+//lifetimeConn 记录连接的创建时间, 用于MaxConnLifetime
+type lifetimeConn struct {
+	redis.Conn
+	created time.Time
+}
+
+func (c *lifetimeConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+}
+
+func (c *lifetimeConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+//pool 返回addr的连接池, 不存在时创建
+func (p *Pool) pool(addr string) *redis.Pool {
+	p.lock.RLock()
+	rp, ok := p.pools[addr]
+	p.lock.RUnlock()
+	if ok {
+		return rp
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if rp, ok = p.pools[addr]; ok {
+		return rp
+	}
+	rp = &redis.Pool{
+		MaxIdle:     p.opts.MaxIdle,
+		MaxActive:   p.opts.MaxActive,
+		Wait:        p.opts.Wait,
+		IdleTimeout: p.opts.IdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return p.dial(addr)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if lc, ok := c.(*lifetimeConn); ok && time.Since(lc.created) >= p.opts.MaxConnLifetime {
+				return errConnExpired
+			}
+			if time.Now().Sub(t) < p.opts.TestIdle {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	p.pools[addr] = rp
+	return rp
+}
+
+//addrOf 返回key所在节点的地址, key为空时返回任意节点
+func (p *Pool) addrOf(key string) (string, error) {
+	switch p.opts.Mode {
+	case ModeSentinel:
+		p.lock.RLock()
+		addr := p.addr
+		p.lock.RUnlock()
+		if addr != "" {
+			return addr, nil
+		}
+		if err := p.refresh(""); err != nil {
+			return "", err
+		}
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		return p.addr, nil
+	case ModeCluster:
+		p.lock.RLock()
+		slots := p.slots
+		p.lock.RUnlock()
+		if slots == nil {
+			if err := p.refresh(""); err != nil {
+				return "", err
+			}
+		}
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		if key != "" {
+			if addr := p.slots[Slot(key)]; addr != "" {
+				return addr, nil
+			}
+		}
+		if len(p.nodes) == 0 {
+			return "", errors.New("redisutil: no cluster node available")
+		}
+		return p.nodes[0], nil
+	default:
+		return p.addr, nil
+	}
+}
+
+//Get 返回key所在节点的连接, key为空时返回任意节点(sentinel模式为master)的连接
+func (p *Pool) Get(ctx context.Context, key string) (redis.Conn, error) {
+	addr, err := p.addrOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return p.pool(addr).GetContext(ctx)
+}
+
+//Conn 同Get, 出错时返回的连接在调用时返回错误, 同redis.Pool.Get
+func (p *Pool) Conn(key string) redis.Conn {
+	c, err := p.Get(context.Background(), key)
+	if err != nil {
+		return ErrorConn(err)
+	}
+	return c
+}
+
+//Dial 创建不属于连接池的连接, 用于订阅等长时间占用的连接
+//sentinel及cluster模式连接失败时重新查找master或slot分配后重试一次
+func (p *Pool) Dial() (redis.Conn, error) {
+	addr, err := p.addrOf("")
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.dial(addr)
+	if err == nil || p.opts.Mode == ModeStandalone {
+		return c, err
+	}
+	if err := p.refresh(addr); err != nil {
+		return nil, err
+	}
+	if addr, err = p.addrOf(""); err != nil {
+		return nil, err
+	}
+	return p.dial(addr)
+}
+
+//Nodes 返回所有master的地址, 单机和sentinel模式只有一个
+func (p *Pool) Nodes() ([]string, error) {
+	if !p.IsCluster() {
+		addr, err := p.addrOf("")
+		if err != nil {
+			return nil, err
+		}
+		return []string{addr}, nil
+	}
+	if _, err := p.addrOf(""); err != nil {
+		return nil, err
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return append([]string(nil), p.nodes...), nil
+}
+
+//GetNode 返回addr节点的连接, addr为Nodes返回的地址
+func (p *Pool) GetNode(ctx context.Context, addr string) (redis.Conn, error) {
+	return p.pool(addr).GetContext(ctx)
+}
+
+//Exec 在key所在节点的连接上调用fn, 并处理重定向:
+//cluster模式收到MOVED时更新slot后重试, 收到ASK时在目标节点上先发送ASKING再重试
+//sentinel模式收到READONLY(master已切换)或连接失败时重新查找master后重试
+//命令已发送后的网络错误不重试, 以免重复执行
+func (p *Pool) Exec(ctx context.Context, key string, fn func(c redis.Conn) (interface{}, error)) (interface{}, error) {
+	addr, err := p.addrOf(key)
+	if err != nil {
+		return nil, err
+	}
+	asking := false
+	for i := 0; ; i++ {
+		reply, dialed, err := p.exec(ctx, addr, asking, fn)
+		if err == nil || i >= maxRedirects || ctx.Err() != nil {
+			return reply, err
+		}
+		asking = false
+		kind, slot, target := redirection(err)
+		if !dialed {
+			kind = "DIAL"
+		}
+		switch kind {
+		case "MOVED":
+			p.setSlot(slot, target)
+			addr = target
+		case "ASK":
+			addr, asking = target, true
+		case "READONLY", "DIAL":
+			if p.opts.Mode == ModeStandalone {
+				return reply, err
+			}
+			if err := p.refresh(addr); err != nil {
+				return nil, err
+			}
+			if addr, err = p.addrOf(key); err != nil {
+				return nil, err
+			}
+		default:
+			return reply, err
+		}
+	}
+}
+
+//exec 在addr节点上调用fn, dialed为是否已获取到连接
+func (p *Pool) exec(ctx context.Context, addr string, asking bool, fn func(c redis.Conn) (interface{}, error)) (reply interface{}, dialed bool, err error) {
+	c, err := p.pool(addr).GetContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer c.Close()
+	if asking {
+		if _, err := c.Do("ASKING"); err != nil {
+			return nil, true, err
+		}
+	}
+	reply, err = fn(c)
+	return reply, true, err
+}
+
+//IsRedirect err是否为cluster的MOVED或ASK重定向错误
+func IsRedirect(err error) bool {
+	kind, _, _ := redirection(err)
+	return kind == "MOVED" || kind == "ASK"
+}
+
+//redirection 解析重定向错误, 如"MOVED 3999 127.0.0.1:6381"
+func redirection(err error) (kind string, slot int, addr string) {
+	if e, ok := err.(redis.Error); ok {
+		fields := strings.Fields(string(e))
+		if len(fields) == 0 {
+			return "", 0, ""
+		}
+		switch fields[0] {
+		case "MOVED", "ASK":
+			if len(fields) == 3 {
+				slot, _ = strconv.Atoi(fields[1])
+				return fields[0], slot, fields[2]
+			}
+		case "READONLY":
+			return "READONLY", 0, ""
+		}
+	}
+	return "", 0, ""
+}
+
+func (p *Pool) setSlot(slot int, addr string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.slots != nil && slot >= 0 && slot < SlotCount {
+		p.slots[slot] = addr
+	}
+}
+
+//refresh 重新查找master或cluster的slot分配, stale为出错的地址
+//并发调用时只查找一次, 等待期间其它goroutine已更新时直接返回
+func (p *Pool) refresh(stale string) error {
+	p.refreshLock.Lock()
+	defer p.refreshLock.Unlock()
+
+	p.lock.RLock()
+	updated := (p.opts.Mode == ModeSentinel && p.addr != "" && p.addr != stale) ||
+		(p.opts.Mode == ModeCluster && p.slots != nil && stale == "")
+	p.lock.RUnlock()
+	if updated {
+		return nil
+	}
+
+	if p.opts.Mode == ModeSentinel {
+		addr, err := p.masterAddr()
+		if err != nil {
+			return err
+		}
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		//master已切换, 关闭原master的连接池
+		if old, ok := p.pools[p.addr]; ok && p.addr != addr {
+			old.Close()
+			delete(p.pools, p.addr)
+		}
+		p.addr = addr
+		return nil
+	}
+
+	slots, nodes, err := p.clusterSlots()
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	p.slots, p.nodes = slots, nodes
+	p.lock.Unlock()
+	return nil
+}
+
+//masterAddr 依次询问sentinel, 返回master地址
+func (p *Pool) masterAddr() (string, error) {
+	err := errors.New("redisutil: no sentinel available")
+	for _, addr := range p.opts.Addrs {
+		var c redis.Conn
+		if c, err = redis.Dial("tcp", addr, p.dialOptions(time.Second*3)...); err != nil {
+			continue
+		}
+		if err = auth(c, "", p.opts.SentinelPassword); err != nil {
+			c.Close()
+			continue
+		}
+		var reply []string
+		reply, err = redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", p.opts.MasterName))
+		c.Close()
+		if err == nil && len(reply) == 2 {
+			return net.JoinHostPort(reply[0], reply[1]), nil
+		}
+		if err == nil || err == redis.ErrNil {
+			err = errors.New("redisutil: sentinel does not know master " + p.opts.MasterName)
+		}
+	}
+	return "", err
+}
+
+//clusterSlots 依次询问已知节点及配置的节点, 返回CLUSTER SLOTS的结果
+func (p *Pool) clusterSlots() ([]string, []string, error) {
+	p.lock.RLock()
+	addrs := append(append([]string(nil), p.nodes...), p.opts.Addrs...)
+	p.lock.RUnlock()
+
+	err := errors.New("redisutil: no cluster node available")
+	for _, addr := range addrs {
+		var reply []interface{}
+		c, err1 := p.pool(addr).GetContext(context.Background())
+		if err = err1; err != nil {
+			continue
+		}
+		reply, err = redis.Values(c.Do("CLUSTER", "SLOTS"))
+		c.Close()
+		if err != nil {
+			continue
+		}
+		slots, nodes, err := parseSlots(reply)
+		if err == nil {
+			return slots, nodes, nil
+		}
+	}
+	return nil, nil, err
+}
+
+//parseSlots 解析CLUSTER SLOTS: [[start, end, [ip, port, ...], 副本...], ...]
+func parseSlots(reply []interface{}) ([]string, []string, error) {
+	slots := make([]string, SlotCount)
+	seen := make(map[string]bool)
+	var nodes []string
+	for _, r := range reply {
+		entry, err := redis.Values(r, nil)
+		if err != nil || len(entry) < 3 {
+			return nil, nil, errors.New("redisutil: invalid CLUSTER SLOTS reply")
+		}
+		start, err1 := redis.Int(entry[0], nil)
+		end, err2 := redis.Int(entry[1], nil)
+		master, err3 := redis.Values(entry[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(master) < 2 || start < 0 || end >= SlotCount {
+			return nil, nil, errors.New("redisutil: invalid CLUSTER SLOTS reply")
+		}
+		host, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = addr
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			nodes = append(nodes, addr)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, nil, errors.New("redisutil: cluster has no slots")
+	}
+	sort.Strings(nodes)
+	return slots, nodes, nil
+}
+
+//Close 关闭所有连接池
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var err error
+	for addr, rp := range p.pools {
+		if err1 := rp.Close(); err1 != nil {
+			err = err1
+		}
+		delete(p.pools, addr)
+	}
+	return err
+}
+
+//ErrorConn 返回所有方法都返回err的连接, 用于无法获取连接时
+func ErrorConn(err error) redis.Conn {
+	return errorConn{err}
+}
+
+type errorConn struct {
+	err error
+}
+
+func (c errorConn) Close() error                                   { return nil }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
@@ -0,0 +1,252 @@
+package redisutil_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tryor/commons/redisutil"
+	"github.com/tryor/commons/redisutil/redistest"
+)
+
+func Test_Slot(t *testing.T) {
+	cases := map[string]int{
+		"123456789":            12739,
+		"foo":                  12182,
+		"{user1000}.following": redisutil.Slot("user1000"),
+		"foo{}{bar}":           redisutil.Slot("foo{}{bar}"),
+		"{}foo":                redisutil.Slot("{}foo"),
+	}
+	for key, slot := range cases {
+		if got := redisutil.Slot(key); got != slot {
+			t.Fatalf("Slot(%q) = %d, want %d", key, got, slot)
+		}
+	}
+	if redisutil.Slot("{user1000}.followers") != redisutil.Slot("{user1000}.following") {
+		t.Fatal("hash tag not used")
+	}
+
+	groups := redisutil.GroupBySlot([]string{"{a}1", "{b}1", "{a}2", "{b}2", "{c}"})
+	if len(groups) != 3 || len(groups[0]) != 2 || groups[0][1] != 2 || groups[1][1] != 3 || groups[2][0] != 4 {
+		t.Fatal("GroupBySlot", groups)
+	}
+}
+
+func do(p *redisutil.Pool, key string, cmd string, args ...interface{}) (interface{}, error) {
+	return p.Exec(context.Background(), key, func(c redis.Conn) (interface{}, error) {
+		return c.Do(cmd, args...)
+	})
+}
+
+func Test_Cluster(t *testing.T) {
+	cluster, err := redistest.NewCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	p, err := redisutil.NewPool(redisutil.Options{Mode: redisutil.ModeCluster, Addrs: cluster.Addrs()[:1], MaxIdle: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	keys := []string{"k1", "k2", "k3", "k4", "k5", "k6"}
+	for _, key := range keys {
+		if _, err = do(p, key, "SET", key, "v"+key); err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := cluster.Owner(key).Value(key); !ok || v != "v"+key {
+			t.Fatal(key, "not stored on the owner node")
+		}
+	}
+	nodes, err := p.Nodes()
+	if err != nil || len(nodes) != 3 {
+		t.Fatal("Nodes", nodes, err)
+	}
+
+	//slot迁移后返回MOVED
+	slot := redisutil.Slot("k1")
+	owner := cluster.Owner("k1")
+	to := 0
+	for cluster.Servers[to] == owner {
+		to++
+	}
+	cluster.Move(slot, to)
+	if v, err := redis.String(do(p, "k1", "GET", "k1")); err != nil || v != "vk1" {
+		t.Fatal("GET after MOVED", v, err)
+	}
+	n := cluster.Servers[to].CommandCount("GET")
+	do(p, "k1", "GET", "k1")
+	if cluster.Servers[to].CommandCount("GET") != n+1 || owner.CommandCount("GET") != 1 {
+		t.Fatal("slot table not updated after MOVED")
+	}
+
+	//迁移中的slot返回ASK
+	slot = redisutil.Slot("k2")
+	owner = cluster.Owner("k2")
+	to = 0
+	for cluster.Servers[to] == owner {
+		to++
+	}
+	cluster.Migrating(slot, to)
+	cluster.Servers[to].Set("k2", "migrated")
+	owner.Del("k2")
+	if v, err := redis.String(do(p, "k2", "GET", "k2")); err != nil || v != "migrated" {
+		t.Fatal("GET after ASK", v, err)
+	}
+	if cluster.Servers[to].CommandCount("ASKING") != 1 {
+		t.Fatal("ASKING not sent")
+	}
+
+	//不同slot的key不能在一条命令中使用
+	if _, err = do(p, "k3", "MGET", "k3", "k4", "k5"); err == nil {
+		t.Fatal("CROSSSLOT expected")
+	}
+}
+
+func Test_Sentinel(t *testing.T) {
+	master, _ := redistest.NewServer()
+	defer master.Close()
+	replica, _ := redistest.NewServer()
+	defer replica.Close()
+	sentinel, _ := redistest.NewServer()
+	defer sentinel.Close()
+	sentinel.SetMaster(master)
+
+	p, err := redisutil.NewPool(redisutil.Options{Mode: redisutil.ModeSentinel, MasterName: "mymaster", Addrs: []string{"127.0.0.1:1", sentinel.Addr}, MaxIdle: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if _, err = do(p, "k", "SET", "k", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := master.Value("k"); v != "1" {
+		t.Fatal("not written to master")
+	}
+
+	//故障切换: 原master变为只读, sentinel返回新master
+	master.SetReadOnly(true)
+	sentinel.SetMaster(replica)
+	if _, err = do(p, "k", "SET", "k", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := replica.Value("k"); v != "2" {
+		t.Fatal("not written to new master")
+	}
+
+	//master不可用时重新查找
+	replica.Close()
+	sentinel.SetMaster(master)
+	master.SetReadOnly(false)
+	if _, err = do(p, "k", "SET", "k", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := master.Value("k"); v != "3" {
+		t.Fatal("not written after master recovered")
+	}
+	//Dial连接失败时同样重新查找master
+	other, _ := redistest.NewServer()
+	defer other.Close()
+	sentinel.SetMaster(other)
+	master.Close()
+	c, err := p.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Do("SET", "k", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := other.Value("k"); v != "4" {
+		t.Fatal("Dial not connected to new master")
+	}
+}
+
+func Test_ParseOptions(t *testing.T) {
+	opts, err := redisutil.ParseOptions(map[string]string{
+		"mode":            "cluster",
+		"addr":            "127.0.0.1:7000, 127.0.0.1:7001",
+		"username":        "app",
+		"maxActiveConns":  "20",
+		"wait":            "true",
+		"readTimeout":     "0.5",
+		"maxConnLifetime": "600",
+		"tls":             "true",
+		"tlsSkipVerify":   "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.Addrs) != 2 || opts.Addrs[1] != "127.0.0.1:7001" || opts.Username != "app" || opts.MaxActive != 20 || !opts.Wait {
+		t.Fatalf("%+v", opts)
+	}
+	if opts.ReadTimeout != time.Millisecond*500 || opts.MaxConnLifetime != time.Minute*10 || opts.IdleTimeout != time.Second*300 || opts.MaxIdle != 3 {
+		t.Fatalf("%+v", opts)
+	}
+	if opts.TLS == nil || !opts.TLS.InsecureSkipVerify {
+		t.Fatal("tls not configured")
+	}
+
+	bad := []map[string]string{
+		{},
+		{"addr": "127.0.0.1:6379", "maxActiveConns": "x"},
+		{"addr": "127.0.0.1:6379", "readTimeout": "-1"},
+		{"addr": "127.0.0.1:6379", "tls": "true", "tlsCAFile": "/nonexistent"},
+	}
+	for _, cf := range bad {
+		if _, err = redisutil.ParseOptions(cf); err == nil {
+			t.Fatal("error expected", cf)
+		}
+	}
+}
+
+func Test_PoolLimits(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p, err := redisutil.NewPoolConfig(map[string]string{
+		"addr":            s.Addr,
+		"username":        "app",
+		"password":        "secret",
+		"maxActiveConns":  "1",
+		"maxConnLifetime": "0.05",
+		"readTimeout":     "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	c1, err := p.Get(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Get(context.Background(), ""); err != redis.ErrPoolExhausted {
+		t.Fatal("ErrPoolExhausted expected", err)
+	}
+	if _, err = redis.DoWithTimeout(c1, time.Second, "PING"); err != nil {
+		t.Fatal(err)
+	}
+	c1.Close()
+	if s.CommandCount("AUTH app secret") != 1 {
+		t.Fatal("ACL AUTH not sent")
+	}
+
+	//超过maxConnLifetime的连接重新创建
+	time.Sleep(time.Millisecond * 60)
+	c2, err := p.Get(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+	if n := s.CommandCount("AUTH"); n != 2 {
+		t.Fatal("connection not recreated", n)
+	}
+}
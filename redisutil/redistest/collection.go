@@ -0,0 +1,409 @@
+package redistest
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//stream XADD写入的消息及消费组
+type stream struct {
+	entries []streamEntry
+	seq     int
+	groups  map[string]*streamGroup
+}
+
+type streamEntry struct {
+	id     string
+	fields []string
+}
+
+//streamGroup 消费组, next为下一条未投递消息在entries中的下标
+type streamGroup struct {
+	next    int
+	pending []*pendingEntry
+}
+
+type pendingEntry struct {
+	id         string
+	consumer   string
+	delivered  time.Time
+	deliveries int
+}
+
+//collectionCommand 执行list, set, stream及HyperLogLog命令, 调用时持有s.lock
+func (s *Server) collectionCommand(name string, args []string) (interface{}, bool) {
+	switch name {
+	case "LPUSH", "RPUSH":
+		list := s.lists[args[0]]
+		for _, v := range args[1:] {
+			if name == "LPUSH" {
+				list = append([]string{v}, list...)
+			} else {
+				list = append(list, v)
+			}
+		}
+		s.lists[args[0]] = list
+		return len(list), true
+	case "LPOP", "RPOP":
+		return s.pop(args[0], name == "LPOP"), true
+	case "BLPOP", "BRPOP":
+		for _, key := range args[:len(args)-1] {
+			if v := s.pop(key, name == "BLPOP"); v != nil {
+				return []interface{}{key, v}, true
+			}
+		}
+		return nil, true
+	case "LLEN":
+		return len(s.lists[args[0]]), true
+	case "LRANGE":
+		list := s.lists[args[0]]
+		start, stop := listRange(len(list), args[1], args[2])
+		reply := []interface{}{}
+		for i := start; i <= stop; i++ {
+			reply = append(reply, list[i])
+		}
+		return reply, true
+	case "LTRIM":
+		list := s.lists[args[0]]
+		start, stop := listRange(len(list), args[1], args[2])
+		if start > stop {
+			delete(s.lists, args[0])
+		} else {
+			s.lists[args[0]] = append([]string(nil), list[start:stop+1]...)
+		}
+		return status("OK"), true
+	case "LREM":
+		//只支持count为0, 即删除全部
+		var list []string
+		n := 0
+		for _, v := range s.lists[args[0]] {
+			if v == args[2] {
+				n++
+			} else {
+				list = append(list, v)
+			}
+		}
+		s.setList(args[0], list)
+		return n, true
+	case "SADD", "PFADD":
+		m := s.sets
+		if name == "PFADD" {
+			m = s.hlls
+		}
+		set, ok := m[args[0]]
+		if !ok {
+			set = make(map[string]bool)
+			m[args[0]] = set
+		}
+		n := 0
+		for _, v := range args[1:] {
+			if !set[v] {
+				set[v] = true
+				n++
+			}
+		}
+		if name == "PFADD" && n > 0 {
+			n = 1
+		}
+		return n, true
+	case "SREM":
+		n := 0
+		for _, v := range args[1:] {
+			if s.sets[args[0]][v] {
+				delete(s.sets[args[0]], v)
+				n++
+			}
+		}
+		if len(s.sets[args[0]]) == 0 {
+			delete(s.sets, args[0])
+		}
+		return n, true
+	case "SISMEMBER":
+		if s.sets[args[0]][args[1]] {
+			return 1, true
+		}
+		return 0, true
+	case "SCARD":
+		return len(s.sets[args[0]]), true
+	case "SMEMBERS":
+		return members(s.sets[args[0]]), true
+	case "SINTER", "SUNION", "SDIFF":
+		result := make(map[string]bool)
+		for v := range s.sets[args[0]] {
+			result[v] = true
+		}
+		for _, key := range args[1:] {
+			other := s.sets[key]
+			for v := range result {
+				if (name == "SINTER" && !other[v]) || (name == "SDIFF" && other[v]) {
+					delete(result, v)
+				}
+			}
+			if name == "SUNION" {
+				for v := range other {
+					result[v] = true
+				}
+			}
+		}
+		return members(result), true
+	case "PFCOUNT":
+		union := make(map[string]bool)
+		for _, key := range args {
+			for v := range s.hlls[key] {
+				union[v] = true
+			}
+		}
+		return len(union), true
+	case "PFMERGE":
+		union := make(map[string]bool)
+		for _, key := range args {
+			for v := range s.hlls[key] {
+				union[v] = true
+			}
+		}
+		s.hlls[args[0]] = union
+		return status("OK"), true
+	case "XADD":
+		return s.xadd(args), true
+	case "XLEN":
+		if st, ok := s.streams[args[0]]; ok {
+			return len(st.entries), true
+		}
+		return 0, true
+	case "XGROUP":
+		return s.xgroup(args), true
+	case "XREADGROUP":
+		return s.xreadgroup(args), true
+	case "XACK":
+		return s.xack(args), true
+	case "XPENDING":
+		return s.xpending(args), true
+	}
+	return nil, false
+}
+
+func (s *Server) pop(key string, left bool) interface{} {
+	list := s.lists[key]
+	if len(list) == 0 {
+		return nil
+	}
+	var v string
+	if left {
+		v, list = list[0], list[1:]
+	} else {
+		v, list = list[len(list)-1], list[:len(list)-1]
+	}
+	s.setList(key, list)
+	return v
+}
+
+func (s *Server) setList(key string, list []string) {
+	if len(list) == 0 {
+		delete(s.lists, key)
+	} else {
+		s.lists[key] = list
+	}
+}
+
+//listRange 将LRANGE/LTRIM的start, stop转换为有效的下标, start > stop时为空
+func listRange(n int, startArg, stopArg string) (int, int) {
+	start, _ := strconv.Atoi(startArg)
+	stop, _ := strconv.Atoi(stopArg)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return start, stop
+}
+
+func members(set map[string]bool) []interface{} {
+	list := make([]string, 0, len(set))
+	for v := range set {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	reply := make([]interface{}, len(list))
+	for i, v := range list {
+		reply[i] = v
+	}
+	return reply
+}
+
+//xadd XADD key [MAXLEN [~] n] * field value ...
+func (s *Server) xadd(args []string) interface{} {
+	key, args := args[0], args[1:]
+	maxLen := -1
+	if len(args) > 1 && strings.ToUpper(args[0]) == "MAXLEN" {
+		args = args[1:]
+		if args[0] == "~" || args[0] == "=" {
+			args = args[1:]
+		}
+		maxLen, _ = strconv.Atoi(args[0])
+		args = args[1:]
+	}
+	if len(args) < 3 || args[0] != "*" || len(args)%2 != 1 {
+		return errors.New("ERR wrong number of arguments for 'xadd' command")
+	}
+	st, ok := s.streams[key]
+	if !ok {
+		st = &stream{groups: make(map[string]*streamGroup)}
+		s.streams[key] = st
+	}
+	st.seq++
+	id := strconv.Itoa(st.seq) + "-0"
+	st.entries = append(st.entries, streamEntry{id, append([]string(nil), args[1:]...)})
+	if maxLen >= 0 && len(st.entries) > maxLen {
+		trim := len(st.entries) - maxLen
+		st.entries = st.entries[trim:]
+		for _, g := range st.groups {
+			if g.next -= trim; g.next < 0 {
+				g.next = 0
+			}
+		}
+	}
+	return id
+}
+
+//xgroup XGROUP CREATE key group id [MKSTREAM], id只支持$和0
+func (s *Server) xgroup(args []string) interface{} {
+	if len(args) < 4 || strings.ToUpper(args[0]) != "CREATE" {
+		return errors.New("ERR only XGROUP CREATE is supported")
+	}
+	st, ok := s.streams[args[1]]
+	if !ok {
+		if len(args) < 5 || strings.ToUpper(args[4]) != "MKSTREAM" {
+			return errors.New("ERR The XGROUP subcommand requires the key to exist")
+		}
+		st = &stream{groups: make(map[string]*streamGroup)}
+		s.streams[args[1]] = st
+	}
+	if _, ok := st.groups[args[2]]; ok {
+		return errors.New("BUSYGROUP Consumer Group name already exists")
+	}
+	g := &streamGroup{}
+	if args[3] == "$" {
+		g.next = len(st.entries)
+	}
+	st.groups[args[2]] = g
+	return status("OK")
+}
+
+//xreadgroup XREADGROUP GROUP group consumer [COUNT n] [BLOCK ms] STREAMS key id, 只支持一个stream
+func (s *Server) xreadgroup(args []string) interface{} {
+	if len(args) < 6 || strings.ToUpper(args[0]) != "GROUP" {
+		return errors.New("ERR syntax error")
+	}
+	group, consumer := args[1], args[2]
+	count := 0
+	var key, id string
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			i++
+			count, _ = strconv.Atoi(args[i])
+		case "BLOCK":
+			i++
+		case "STREAMS":
+			if i+2 < len(args) {
+				key, id = args[i+1], args[i+2]
+			}
+			i = len(args)
+		}
+	}
+	st, ok := s.streams[key]
+	if !ok || st.groups[group] == nil {
+		return errors.New("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+	}
+	g := st.groups[group]
+	var entries []interface{}
+	if id == ">" {
+		for ; g.next < len(st.entries) && (count <= 0 || len(entries) < count); g.next++ {
+			e := st.entries[g.next]
+			g.pending = append(g.pending, &pendingEntry{id: e.id, consumer: consumer, delivered: time.Now(), deliveries: 1})
+			entries = append(entries, entryReply(e))
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+	} else {
+		//id为0时返回该消费者已投递未确认的消息
+		for _, p := range g.pending {
+			if p.consumer != consumer || (count > 0 && len(entries) >= count) {
+				continue
+			}
+			for _, e := range st.entries {
+				if e.id == p.id {
+					p.deliveries++
+					entries = append(entries, entryReply(e))
+				}
+			}
+		}
+		if entries == nil {
+			entries = []interface{}{}
+		}
+	}
+	return []interface{}{[]interface{}{key, entries}}
+}
+
+func entryReply(e streamEntry) interface{} {
+	fields := make([]interface{}, len(e.fields))
+	for i, f := range e.fields {
+		fields[i] = f
+	}
+	return []interface{}{e.id, fields}
+}
+
+//xack XACK key group id ...
+func (s *Server) xack(args []string) interface{} {
+	st, ok := s.streams[args[0]]
+	if !ok || st.groups[args[1]] == nil {
+		return 0
+	}
+	g := st.groups[args[1]]
+	n := 0
+	for _, id := range args[2:] {
+		for i, p := range g.pending {
+			if p.id == id {
+				g.pending = append(g.pending[:i], g.pending[i+1:]...)
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+//xpending XPENDING key group - + count [consumer], 只支持完整形式
+func (s *Server) xpending(args []string) interface{} {
+	if len(args) < 5 {
+		return errors.New("ERR only extended XPENDING is supported")
+	}
+	st, ok := s.streams[args[0]]
+	if !ok || st.groups[args[1]] == nil {
+		return errors.New("NOGROUP No such key '" + args[0] + "' or consumer group '" + args[1] + "'")
+	}
+	count, _ := strconv.Atoi(args[4])
+	reply := []interface{}{}
+	for _, p := range st.groups[args[1]].pending {
+		if len(args) > 5 && p.consumer != args[5] {
+			continue
+		}
+		if len(reply) >= count {
+			break
+		}
+		idle := int(time.Since(p.delivered) / time.Millisecond)
+		reply = append(reply, []interface{}{p.id, p.consumer, idle, p.deliveries})
+	}
+	return reply
+}
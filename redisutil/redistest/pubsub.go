@@ -0,0 +1,87 @@
+package redistest
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+//pubsub 执行发布/订阅命令, 调用时持有s.lock
+func (s *Server) pubsub(st *conn, name string, args []string) interface{} {
+	switch name {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(args) == 0 {
+			return errors.New("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		}
+		if st.channels == nil {
+			st.channels, st.patterns = make(map[string]bool), make(map[string]bool)
+		}
+		set := st.channels
+		if name == "PSUBSCRIBE" {
+			set = st.patterns
+		}
+		var reply replies
+		for _, arg := range args {
+			set[arg] = true
+			reply = append(reply, []interface{}{strings.ToLower(name), arg, len(st.channels) + len(st.patterns)})
+		}
+		s.subs[st] = true
+		return reply
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		set := st.channels
+		if name == "PUNSUBSCRIBE" {
+			set = st.patterns
+		}
+		if len(args) == 0 {
+			for arg := range set {
+				args = append(args, arg)
+			}
+			sort.Strings(args)
+		}
+		var reply replies
+		for _, arg := range args {
+			delete(set, arg)
+			reply = append(reply, []interface{}{strings.ToLower(name), arg, len(st.channels) + len(st.patterns)})
+		}
+		if len(st.channels)+len(st.patterns) == 0 {
+			delete(s.subs, st)
+		}
+		if len(reply) == 0 {
+			reply = append(reply, []interface{}{strings.ToLower(name), nil, 0})
+		}
+		return reply
+	case "PUBLISH":
+		n := 0
+		for sub := range s.subs {
+			if sub.channels[args[0]] {
+				sub.write([]interface{}{"message", args[0], args[1]})
+				n++
+			}
+			for pattern := range sub.patterns {
+				if ok, _ := path.Match(pattern, args[0]); ok {
+					sub.write([]interface{}{"pmessage", pattern, args[0], args[1]})
+					n++
+				}
+			}
+		}
+		return n
+	case "PUBSUB":
+		//只支持NUMSUB
+		if len(args) == 0 || strings.ToUpper(args[0]) != "NUMSUB" {
+			return errors.New("ERR only PUBSUB NUMSUB is supported")
+		}
+		reply := []interface{}{}
+		for _, ch := range args[1:] {
+			n := 0
+			for sub := range s.subs {
+				if sub.channels[ch] {
+					n++
+				}
+			}
+			reply = append(reply, ch, n)
+		}
+		return reply
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//ScriptFunc 脚本的Go实现, 返回值可以为nil, error, int, string及[]interface{}
+type ScriptFunc func(keys, args []string) interface{}
+
+//HandleScript 注册脚本src的Go实现, 测试服务器不能执行lua, EVAL及EVALSHA时调用fn
+//fn执行时持有服务器的锁, 不能调用Server的方法
+func (s *Server) HandleScript(src string, fn ScriptFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[src] = fn
+}
+
+func scriptHash(src string) string {
+	h := sha1.Sum([]byte(src))
+	return hex.EncodeToString(h[:])
+}
+
+//script 执行SCRIPT, EVAL及EVALSHA命令, 调用时持有s.lock
+func (s *Server) script(name string, args []string) interface{} {
+	if name == "SCRIPT" {
+		if len(args) == 0 {
+			return errors.New("ERR wrong number of arguments for 'script' command")
+		}
+		switch strings.ToUpper(args[0]) {
+		case "LOAD":
+			if len(args) != 2 {
+				return errors.New("ERR wrong number of arguments for 'script|load' command")
+			}
+			sha := scriptHash(args[1])
+			s.scripts[sha] = args[1]
+			return sha
+		case "FLUSH":
+			s.scripts = make(map[string]string)
+			return status("OK")
+		case "EXISTS":
+			reply := []interface{}{}
+			for _, sha := range args[1:] {
+				_, ok := s.scripts[sha]
+				reply = append(reply, boolInt(ok))
+			}
+			return reply
+		}
+		return errors.New("ERR unknown SCRIPT subcommand")
+	}
+
+	if len(args) < 2 {
+		return errors.New("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+	}
+	src := args[0]
+	if name == "EVALSHA" {
+		var ok bool
+		if src, ok = s.scripts[args[0]]; !ok {
+			return errors.New("NOSCRIPT No matching script. Please use EVAL.")
+		}
+	} else {
+		s.scripts[scriptHash(src)] = src
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n > len(args)-2 {
+		return errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	fn, ok := s.handlers[src]
+	if !ok {
+		return errors.New("ERR test server can not run lua scripts, use HandleScript")
+	}
+	return fn(args[2:2+n], args[2+n:])
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,673 @@
+/*
+redistest 进程内的redis测试服务器, 支持常用的字符串、hash、sorted set、list、set、stream及HyperLogLog命令,
+MULTI/EXEC/WATCH事务, 发布/订阅, 脚本(通过HandleScript使用Go实现), cluster的slot分配及重定向和sentinel
+只用于测试, 不检查过期时间, 阻塞命令(BLPOP, XREADGROUP BLOCK等)没有数据时立即返回, HyperLogLog为精确计数
+*/
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tryor/commons/redisutil"
+)
+
+//Server 测试服务器
+type Server struct {
+	Addr string
+
+	l        net.Listener
+	lock     sync.Mutex
+	conns    map[net.Conn]bool
+	data     map[string]string
+	hashes   map[string]map[string]string
+	zsets    map[string]map[string]float64
+	lists    map[string][]string
+	sets     map[string]map[string]bool
+	hlls     map[string]map[string]bool
+	streams  map[string]*stream
+	version  map[string]int        //key的修改次数, 用于WATCH
+	subs     map[*conn]bool        //SUBSCRIBE或PSUBSCRIBE的连接
+	scripts  map[string]string     //SCRIPT LOAD或EVAL的脚本, key为sha1
+	handlers map[string]ScriptFunc //HandleScript注册的脚本实现
+	cluster  *Cluster
+	master   *Server //sentinel的master
+	//ReadOnly 为true时写命令返回READONLY错误, 模拟切换后的原master
+	ReadOnly bool
+	//Commands 收到的命令, 如"GET k1"
+	Commands []string
+}
+
+//NewServer 启动测试服务器, 使用后调用Close
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: l.Addr().String(), l: l, conns: make(map[net.Conn]bool), data: make(map[string]string), hashes: make(map[string]map[string]string),
+		zsets: make(map[string]map[string]float64), lists: make(map[string][]string), sets: make(map[string]map[string]bool),
+		hlls: make(map[string]map[string]bool), streams: make(map[string]*stream), version: make(map[string]int),
+		subs: make(map[*conn]bool), scripts: make(map[string]string), handlers: make(map[string]ScriptFunc)}
+	go s.serve()
+	return s, nil
+}
+
+//Close 停止监听并关闭所有连接
+func (s *Server) Close() error {
+	err := s.l.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+//CloseConns 关闭所有客户端连接, 继续监听, 用于测试断线重连
+func (s *Server) CloseConns() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+//Set 直接写入数据
+func (s *Server) Set(key, val string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data[key] = val
+	s.version[key]++
+}
+
+//Del 直接删除数据
+func (s *Server) Del(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.data, key)
+	s.version[key]++
+}
+
+//Value 直接读取数据
+func (s *Server) Value(key string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+//SetReadOnly 设置ReadOnly
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ReadOnly = readOnly
+}
+
+//SetMaster 设置sentinel返回的master, s作为sentinel使用
+func (s *Server) SetMaster(master *Server) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.master = master
+}
+
+//CommandCount 返回收到的名称为name的命令数量, name可以带开头的参数, 如"AUTH user"
+func (s *Server) CommandCount(name string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := 0
+	for _, cmd := range s.Commands {
+		if cmd == name || strings.HasPrefix(cmd, name+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.conns[c] = true
+		s.lock.Unlock()
+		go s.handle(c)
+	}
+}
+
+//conn 连接状态
+type conn struct {
+	w        *bufio.Writer
+	wlock    sync.Mutex //PUBLISH时其它连接的goroutine也会写入
+	channels map[string]bool
+	patterns map[string]bool
+	asking   bool
+	multi    bool           //MULTI之后, EXEC之前
+	queued   [][]string     //MULTI之后的命令
+	watched  map[string]int //WATCH的key及其当时的修改次数
+}
+
+func (s *Server) handle(c net.Conn) {
+	r := bufio.NewReader(c)
+	st := &conn{w: bufio.NewWriter(c)}
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, c)
+		delete(s.subs, st)
+		s.lock.Unlock()
+		c.Close()
+	}()
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if st.write(s.exec(st, args)) != nil {
+			return
+		}
+	}
+}
+
+func (st *conn) write(reply interface{}) error {
+	st.wlock.Lock()
+	defer st.wlock.Unlock()
+	writeReply(st.w, reply)
+	return st.w.Flush()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+//status 简单字符串回复
+type status string
+
+//replies 依次发送的多个回复, 如SUBSCRIBE多个channel
+type replies []interface{}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case status:
+		w.WriteString("+" + string(v) + "\r\n")
+	case error:
+		w.WriteString("-" + v.Error() + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	case replies:
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	}
+}
+
+var writeCommands = map[string]bool{"SET": true, "DEL": true, "INCRBY": true, "HSET": true, "HMSET": true, "EXPIRE": true, "PEXPIRE": true, "PERSIST": true,
+	"ZADD": true, "ZINCRBY": true, "ZREM": true, "ZPOPMIN": true, "ZPOPMAX": true, "ZREMRANGEBYSCORE": true, "ZREMRANGEBYRANK": true,
+	"LPUSH": true, "RPUSH": true, "LPOP": true, "RPOP": true, "BLPOP": true, "BRPOP": true, "LTRIM": true, "LREM": true,
+	"SADD": true, "SREM": true, "XADD": true, "XGROUP": true, "XREADGROUP": true, "XACK": true, "PFADD": true, "PFMERGE": true,
+	"EVAL": true, "EVALSHA": true}
+
+//keyCommands 参数全部为key的命令, 其它命令只有第一个参数为key
+var keyCommands = map[string]bool{"MGET": true, "DEL": true, "EXISTS": true, "SINTER": true, "SUNION": true, "SDIFF": true,
+	"PFCOUNT": true, "PFMERGE": true}
+
+//commandKeys 返回命令中的key
+func commandKeys(name string, args []string) []string {
+	switch {
+	case len(args) == 0:
+		return nil
+	case keyCommands[name]:
+		return args
+	case name == "BLPOP" || name == "BRPOP":
+		return args[:len(args)-1]
+	case name == "XREADGROUP" || name == "XREAD":
+		//STREAMS key ... id ...
+		for i, arg := range args {
+			if strings.ToUpper(arg) == "STREAMS" {
+				keys := args[i+1:]
+				return keys[:len(keys)/2]
+			}
+		}
+		return nil
+	case name == "XGROUP":
+		return args[1:2]
+	case name == "EVAL" || name == "EVALSHA":
+		if len(args) < 2 {
+			return nil
+		}
+		n, _ := strconv.Atoi(args[1])
+		if n < 0 || n > len(args)-2 {
+			return nil
+		}
+		return args[2 : 2+n]
+	}
+	return args[:1]
+}
+
+func (s *Server) exec(st *conn, args []string) interface{} {
+	if len(args) == 0 {
+		return errors.New("ERR empty command")
+	}
+	name := strings.ToUpper(args[0])
+	args = args[1:]
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Commands = append(s.Commands, strings.Join(append([]string{name}, args...), " "))
+
+	switch name {
+	case "MULTI":
+		if st.multi {
+			return errors.New("ERR MULTI calls can not be nested")
+		}
+		st.multi, st.queued = true, nil
+		return status("OK")
+	case "EXEC":
+		if !st.multi {
+			return errors.New("ERR EXEC without MULTI")
+		}
+		queued, watched := st.queued, st.watched
+		st.multi, st.queued, st.watched = false, nil, nil
+		for key, v := range watched {
+			if s.version[key] != v {
+				return nil
+			}
+		}
+		replies := make([]interface{}, len(queued))
+		for i, cmd := range queued {
+			replies[i] = s.run(st, strings.ToUpper(cmd[0]), cmd[1:])
+		}
+		return replies
+	case "DISCARD":
+		if !st.multi {
+			return errors.New("ERR DISCARD without MULTI")
+		}
+		st.multi, st.queued, st.watched = false, nil, nil
+		return status("OK")
+	case "WATCH":
+		if st.multi {
+			return errors.New("ERR WATCH inside MULTI is not allowed")
+		}
+		if st.watched == nil {
+			st.watched = make(map[string]int)
+		}
+		for _, key := range args {
+			st.watched[key] = s.version[key]
+		}
+		return status("OK")
+	case "UNWATCH":
+		st.watched = nil
+		return status("OK")
+	}
+	if st.multi {
+		st.queued = append(st.queued, append([]string{name}, args...))
+		return status("QUEUED")
+	}
+	return s.run(st, name, args)
+}
+
+//run 执行事务以外的命令, 调用时持有s.lock
+func (s *Server) run(st *conn, name string, args []string) interface{} {
+	asking := st.asking
+	st.asking = false
+	switch name {
+	case "PING":
+		if len(st.channels)+len(st.patterns) > 0 {
+			return []interface{}{"pong", strings.Join(args, "")}
+		}
+		return status("PONG")
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PUBLISH", "PUBSUB":
+		return s.pubsub(st, name, args)
+	case "SCRIPT":
+		return s.script(name, args)
+	case "AUTH", "SELECT":
+		return status("OK")
+	case "ASKING":
+		st.asking = true
+		return status("OK")
+	case "SENTINEL":
+		if s.master == nil {
+			return nil
+		}
+		host, port, _ := net.SplitHostPort(s.master.Addr)
+		return []interface{}{host, port}
+	case "CLUSTER":
+		if s.cluster == nil {
+			return errors.New("ERR This instance has cluster support disabled")
+		}
+		return s.cluster.slotsReply()
+	}
+
+	if s.ReadOnly && writeCommands[name] {
+		return errors.New("READONLY You can't write against a read only replica.")
+	}
+	keys := commandKeys(name, args)
+	if s.cluster != nil && len(keys) > 0 && name != "SCAN" {
+		if err := s.cluster.check(s, keys, asking); err != nil {
+			return err
+		}
+	}
+	if writeCommands[name] {
+		for _, key := range keys {
+			s.version[key]++
+		}
+	}
+	return s.command(name, args)
+}
+
+func (s *Server) command(name string, args []string) interface{} {
+	switch name {
+	case "GET":
+		if v, ok := s.data[args[0]]; ok {
+			return v
+		}
+		return nil
+	case "SET":
+		if len(args) > 2 && strings.ToUpper(args[len(args)-1]) == "NX" {
+			if _, ok := s.data[args[0]]; ok {
+				return nil
+			}
+		}
+		s.data[args[0]] = args[1]
+		return status("OK")
+	case "MGET":
+		reply := make([]interface{}, len(args))
+		for i, key := range args {
+			if v, ok := s.data[key]; ok {
+				reply[i] = v
+			}
+		}
+		return reply
+	case "DEL", "EXISTS":
+		n := 0
+		for _, key := range args {
+			if s.exists(key) {
+				n++
+				if name == "DEL" {
+					s.remove(key)
+				}
+			}
+		}
+		return n
+	case "INCRBY":
+		n, _ := strconv.Atoi(s.data[args[0]])
+		delta, _ := strconv.Atoi(args[1])
+		s.data[args[0]] = strconv.Itoa(n + delta)
+		return n + delta
+	case "EXPIRE", "PEXPIRE", "PERSIST":
+		return 1
+	case "HSET", "HMSET":
+		h, ok := s.hashes[args[0]]
+		if !ok {
+			h = make(map[string]string)
+			s.hashes[args[0]] = h
+		}
+		n := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		if name == "HMSET" {
+			return status("OK")
+		}
+		return n
+	case "HGET":
+		if v, ok := s.hashes[args[0]][args[1]]; ok {
+			return v
+		}
+		return nil
+	case "SCAN":
+		//一次返回全部匹配的key
+		pattern := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		var keys []interface{}
+		for _, key := range s.keys() {
+			if ok, _ := path.Match(pattern, key); ok {
+				keys = append(keys, key)
+			}
+		}
+		return []interface{}{"0", keys}
+	case "EVAL", "EVALSHA":
+		return s.script(name, args)
+	case "DBSIZE":
+		return len(s.keys())
+	case "INFO":
+		return fmt.Sprintf("# Stats\r\nexpired_keys:0\r\nevicted_keys:0\r\n# Memory\r\nused_memory:%d\r\n", 1024)
+	}
+	if reply, ok := s.collectionCommand(name, args); ok {
+		return reply
+	}
+	if reply, ok := s.zsetCommand(name, args); ok {
+		return reply
+	}
+	return errors.New("ERR unknown command '" + name + "'")
+}
+
+func (s *Server) exists(key string) bool {
+	_, ok1 := s.data[key]
+	_, ok2 := s.hashes[key]
+	_, ok3 := s.zsets[key]
+	_, ok4 := s.lists[key]
+	_, ok5 := s.sets[key]
+	_, ok6 := s.hlls[key]
+	_, ok7 := s.streams[key]
+	return ok1 || ok2 || ok3 || ok4 || ok5 || ok6 || ok7
+}
+
+func (s *Server) remove(key string) {
+	delete(s.data, key)
+	delete(s.hashes, key)
+	delete(s.zsets, key)
+	delete(s.lists, key)
+	delete(s.sets, key)
+	delete(s.hlls, key)
+	delete(s.streams, key)
+}
+
+func (s *Server) keys() []string {
+	var keys []string
+	for key := range s.data {
+		keys = append(keys, key)
+	}
+	for key := range s.hashes {
+		keys = append(keys, key)
+	}
+	for key := range s.zsets {
+		keys = append(keys, key)
+	}
+	for key := range s.lists {
+		keys = append(keys, key)
+	}
+	for key := range s.sets {
+		keys = append(keys, key)
+	}
+	for key := range s.hlls {
+		keys = append(keys, key)
+	}
+	for key := range s.streams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Cluster 由多个Server组成的cluster, slot平均分配给各个Server
+type Cluster struct {
+	Servers []*Server
+
+	lock  sync.Mutex
+	owner [redisutil.SlotCount]int //slot所属的Server下标
+	ask   map[int]int              //slot正在迁移到的Server下标
+}
+
+//NewCluster 启动n个Server组成的cluster, 使用后调用Close
+func NewCluster(n int) (*Cluster, error) {
+	c := &Cluster{ask: make(map[int]int)}
+	for i := 0; i < n; i++ {
+		s, err := NewServer()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		s.cluster = c
+		c.Servers = append(c.Servers, s)
+	}
+	for slot := range c.owner {
+		c.owner[slot] = slot * n / redisutil.SlotCount
+	}
+	return c, nil
+}
+
+func (c *Cluster) Close() {
+	for _, s := range c.Servers {
+		s.Close()
+	}
+}
+
+//Addrs 返回所有Server的地址
+func (c *Cluster) Addrs() []string {
+	addrs := make([]string, len(c.Servers))
+	for i, s := range c.Servers {
+		addrs[i] = s.Addr
+	}
+	return addrs
+}
+
+//Owner 返回key所属的Server
+func (c *Cluster) Owner(key string) *Server {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.Servers[c.owner[redisutil.Slot(key)]]
+}
+
+//Move 将slot及其中的数据迁移到Servers[to], 客户端再访问原Server时返回MOVED
+func (c *Cluster) Move(slot int, to int) {
+	c.lock.Lock()
+	from := c.Servers[c.owner[slot]]
+	c.owner[slot] = to
+	delete(c.ask, slot)
+	c.lock.Unlock()
+
+	target := c.Servers[to]
+	from.lock.Lock()
+	moved := make(map[string]string)
+	for key, val := range from.data {
+		if redisutil.Slot(key) == slot {
+			moved[key] = val
+			delete(from.data, key)
+		}
+	}
+	from.lock.Unlock()
+	target.lock.Lock()
+	for key, val := range moved {
+		target.data[key] = val
+	}
+	target.lock.Unlock()
+}
+
+//Migrating 将slot标记为正在迁移到Servers[to], 原Server上不存在的key返回ASK
+func (c *Cluster) Migrating(slot int, to int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ask[slot] = to
+}
+
+//check 检查keys是否属于同一个slot且由s负责, 调用时持有s.lock
+func (c *Cluster) check(s *Server, keys []string, asking bool) error {
+	slot := redisutil.Slot(keys[0])
+	for _, key := range keys[1:] {
+		if redisutil.Slot(key) != slot {
+			return errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	owner := c.Servers[c.owner[slot]]
+	to, migrating := c.ask[slot]
+	switch {
+	case owner == s:
+		if migrating {
+			for _, key := range keys {
+				if _, ok := s.data[key]; !ok {
+					return fmt.Errorf("ASK %d %s", slot, c.Servers[to].Addr)
+				}
+			}
+		}
+		return nil
+	case migrating && asking && c.Servers[to] == s:
+		return nil
+	default:
+		return fmt.Errorf("MOVED %d %s", slot, owner.Addr)
+	}
+}
+
+func (c *Cluster) slotsReply() interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var reply []interface{}
+	start := 0
+	for slot := 1; slot <= redisutil.SlotCount; slot++ {
+		if slot < redisutil.SlotCount && c.owner[slot] == c.owner[start] {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(c.Servers[c.owner[start]].Addr)
+		p, _ := strconv.Atoi(port)
+		reply = append(reply, []interface{}{start, slot - 1, []interface{}{host, p}})
+		start = slot
+	}
+	return reply
+}
@@ -0,0 +1,238 @@
+package redistest
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNotFloat = errors.New("ERR value is not a valid float")
+
+//zsetCommand 执行sorted set命令, 调用时持有s.lock
+func (s *Server) zsetCommand(name string, args []string) (interface{}, bool) {
+	switch name {
+	case "ZADD":
+		z, ok := s.zsets[args[0]]
+		if !ok {
+			z = make(map[string]float64)
+			s.zsets[args[0]] = z
+		}
+		n := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return errNotFloat, true
+			}
+			if _, ok := z[args[i+1]]; !ok {
+				n++
+			}
+			z[args[i+1]] = score
+		}
+		return n, true
+	case "ZINCRBY":
+		delta, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return errNotFloat, true
+		}
+		z, ok := s.zsets[args[0]]
+		if !ok {
+			z = make(map[string]float64)
+			s.zsets[args[0]] = z
+		}
+		z[args[2]] += delta
+		return formatScore(z[args[2]]), true
+	case "ZSCORE":
+		if score, ok := s.zsets[args[0]][args[1]]; ok {
+			return formatScore(score), true
+		}
+		return nil, true
+	case "ZCARD":
+		return len(s.zsets[args[0]]), true
+	case "ZCOUNT":
+		members, err := s.zrangeByScore(args[0], args[1], args[2])
+		if err != nil {
+			return err, true
+		}
+		return len(members), true
+	case "ZRANK", "ZREVRANK":
+		members := s.zrange(args[0], name == "ZREVRANK")
+		for i, m := range members {
+			if m == args[1] {
+				return i, true
+			}
+		}
+		return nil, true
+	case "ZRANGE", "ZREVRANGE":
+		members := s.zrange(args[0], name == "ZREVRANGE")
+		start, stop := listRange(len(members), args[1], args[2])
+		if start > stop {
+			members = nil
+		} else {
+			members = members[start : stop+1]
+		}
+		return s.zreply(args[0], members, hasOption(args[3:], "WITHSCORES")), true
+	case "ZRANGEBYSCORE", "ZREVRANGEBYSCORE":
+		min, max := args[1], args[2]
+		if name == "ZREVRANGEBYSCORE" {
+			min, max = max, min
+		}
+		members, err := s.zrangeByScore(args[0], min, max)
+		if err != nil {
+			return err, true
+		}
+		if name == "ZREVRANGEBYSCORE" {
+			reverse(members)
+		}
+		for i := 3; i+2 < len(args); i++ {
+			if strings.ToUpper(args[i]) == "LIMIT" {
+				offset, _ := strconv.Atoi(args[i+1])
+				count, _ := strconv.Atoi(args[i+2])
+				if offset > len(members) {
+					offset = len(members)
+				}
+				members = members[offset:]
+				if count >= 0 && count < len(members) {
+					members = members[:count]
+				}
+			}
+		}
+		return s.zreply(args[0], members, hasOption(args[3:], "WITHSCORES")), true
+	case "ZPOPMIN", "ZPOPMAX":
+		count := 1
+		if len(args) > 1 {
+			count, _ = strconv.Atoi(args[1])
+		}
+		members := s.zrange(args[0], name == "ZPOPMAX")
+		if count < len(members) {
+			members = members[:count]
+		}
+		reply := s.zreply(args[0], members, true)
+		s.zrem(args[0], members)
+		return reply, true
+	case "ZREM":
+		n := 0
+		for _, m := range args[1:] {
+			if _, ok := s.zsets[args[0]][m]; ok {
+				n++
+			}
+		}
+		s.zrem(args[0], args[1:])
+		return n, true
+	case "ZREMRANGEBYRANK":
+		members := s.zrange(args[0], false)
+		start, stop := listRange(len(members), args[1], args[2])
+		if start > stop {
+			return 0, true
+		}
+		s.zrem(args[0], members[start:stop+1])
+		return stop - start + 1, true
+	case "ZREMRANGEBYSCORE":
+		members, err := s.zrangeByScore(args[0], args[1], args[2])
+		if err != nil {
+			return err, true
+		}
+		s.zrem(args[0], members)
+		return len(members), true
+	}
+	return nil, false
+}
+
+//zrange 返回按score及成员排序的成员, rev为true时从大到小
+func (s *Server) zrange(key string, rev bool) []string {
+	z := s.zsets[key]
+	members := make([]string, 0, len(z))
+	for m := range z {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if z[members[i]] != z[members[j]] {
+			return z[members[i]] < z[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	if rev {
+		reverse(members)
+	}
+	return members
+}
+
+//zrangeByScore 返回分数在min到max之间的成员, 从小到大
+func (s *Server) zrangeByScore(key, min, max string) ([]string, error) {
+	lo, loEx, err := parseBound(min)
+	if err != nil {
+		return nil, err
+	}
+	hi, hiEx, err := parseBound(max)
+	if err != nil {
+		return nil, err
+	}
+	z := s.zsets[key]
+	var members []string
+	for _, m := range s.zrange(key, false) {
+		score := z[m]
+		if score < lo || loEx && score == lo || score > hi || hiEx && score == hi {
+			continue
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+//parseBound 解析分数边界, 如"1.5", "(1.5", "-inf", "+inf"
+func parseBound(arg string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	arg = strings.TrimPrefix(arg, "(")
+	switch strings.ToLower(arg) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+	v, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, false, errors.New("ERR min or max is not a float")
+	}
+	return v, exclusive, nil
+}
+
+func (s *Server) zreply(key string, members []string, withScores bool) []interface{} {
+	reply := []interface{}{}
+	for _, m := range members {
+		reply = append(reply, m)
+		if withScores {
+			reply = append(reply, formatScore(s.zsets[key][m]))
+		}
+	}
+	return reply
+}
+
+func (s *Server) zrem(key string, members []string) {
+	z := s.zsets[key]
+	for _, m := range members {
+		delete(z, m)
+	}
+	if len(z) == 0 {
+		delete(s.zsets, key)
+	}
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+func hasOption(args []string, option string) bool {
+	for _, arg := range args {
+		if strings.ToUpper(arg) == option {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(list []string) {
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+}
@@ -0,0 +1,51 @@
+package redisutil
+
+import (
+	"strings"
+)
+
+//SlotCount redis cluster的slot数量
+const SlotCount = 16384
+
+//Slot 返回key所属的slot, key中包含{tag}时只计算tag
+func Slot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key)) % SlotCount
+}
+
+//crc16 CRC16-CCITT(XMODEM), redis cluster使用的算法
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+//GroupBySlot 按slot对keys分组, 返回每组key在keys中的下标, 组的顺序为每组第一个key在keys中的顺序
+func GroupBySlot(keys []string) [][]int {
+	var groups [][]int
+	index := make(map[int]int)
+	for i, key := range keys {
+		slot := Slot(key)
+		g, ok := index[slot]
+		if !ok {
+			g = len(groups)
+			index[slot] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}